@@ -0,0 +1,209 @@
+package certutils
+
+import (
+	"crypto"
+	"crypto/x509"
+	"crypto/x509/pkix"
+	"errors"
+	"fmt"
+)
+
+// CAKeyUsage is the key usage given to CA certificates created by this package.
+const CAKeyUsage = x509.KeyUsageCertSign | x509.KeyUsageCRLSign
+
+var ErrAuthorityNotCA = errors.New("authority certificate is not a CA")
+
+// ErrPathLenConstraint is returned when an intermediate CA would violate the path length
+// constraint of its issuer.
+type ErrPathLenConstraint struct {
+	Reason string
+}
+
+func (e ErrPathLenConstraint) Error() string {
+	return fmt.Sprintf("path length constraint violated: %s", e.Reason)
+}
+
+// AuthorityEndpoints are the URLs relying parties use to fetch revocation information and
+// issuer certificates for the certificates an authority issues.
+type AuthorityEndpoints struct {
+	// CRLDistributionPoints are stamped in the CRL Distribution Points extension.
+	CRLDistributionPoints []string
+	// OCSPServers are stamped as OCSP responders in the Authority Information Access extension.
+	OCSPServers []string
+	// IssuingCertificateURLs are stamped as caIssuers in the Authority Information Access extension.
+	IssuingCertificateURLs []string
+	// FreshestCRLURLs are stamped in the Freshest CRL extension to advertise delta CRLs.
+	FreshestCRLURLs []string
+	// CRLPartitioner, if set, replaces CRLDistributionPoints with the URL of the CRL partition
+	// the certificate's serial number will be listed in.
+	CRLPartitioner *CRLPartitioner
+}
+
+// merge returns the endpoints with any empty field filled in from defaults.
+func (e AuthorityEndpoints) merge(defaults AuthorityEndpoints) AuthorityEndpoints {
+	if len(e.CRLDistributionPoints) == 0 {
+		e.CRLDistributionPoints = defaults.CRLDistributionPoints
+	}
+	if len(e.OCSPServers) == 0 {
+		e.OCSPServers = defaults.OCSPServers
+	}
+	if len(e.IssuingCertificateURLs) == 0 {
+		e.IssuingCertificateURLs = defaults.IssuingCertificateURLs
+	}
+	if len(e.FreshestCRLURLs) == 0 {
+		e.FreshestCRLURLs = defaults.FreshestCRLURLs
+	}
+	if e.CRLPartitioner == nil {
+		e.CRLPartitioner = defaults.CRLPartitioner
+	}
+	return e
+}
+
+// apply stamps the endpoints onto a certificate template. The template's serial number
+// must already be set.
+func (e AuthorityEndpoints) apply(certificate *x509.Certificate) error {
+	certificate.CRLDistributionPoints = e.CRLDistributionPoints
+	if e.CRLPartitioner != nil {
+		if err := e.CRLPartitioner.checkURLFormat(); err != nil {
+			return err
+		}
+		partition := e.CRLPartitioner.Partition(certificate.SerialNumber)
+		certificate.CRLDistributionPoints = []string{e.CRLPartitioner.URL(partition)}
+	}
+	certificate.OCSPServer = e.OCSPServers
+	certificate.IssuingCertificateURL = e.IssuingCertificateURLs
+
+	if len(e.FreshestCRLURLs) > 0 {
+		ext, err := FreshestCRLExtension{URLs: e.FreshestCRLURLs}.Marshal()
+		if err != nil {
+			return err
+		}
+		certificate.ExtraExtensions = append(certificate.ExtraExtensions, ext)
+	}
+	return nil
+}
+
+// CertificateAuthority is an authority certificate and key together with the defaults
+// applied to every certificate it issues.
+type CertificateAuthority struct {
+	Certificate *x509.Certificate
+	Key         crypto.Signer
+	// Endpoints are stamped on every issued certificate. Fields set in the SigningParameters
+	// of an individual request take precedence.
+	Endpoints AuthorityEndpoints
+}
+
+// Sign signs a CSR with the authority, applying the authority's defaults.
+func (a *CertificateAuthority) Sign(csr *x509.CertificateRequest, parameters SigningParameters) (*x509.Certificate, error) {
+	parameters.Endpoints = parameters.Endpoints.merge(a.Endpoints)
+	return SignCertificate(csr, a.Certificate, a.Key, parameters)
+}
+
+// SignIntermediateCA issues an intermediate CA certificate from a CSR, applying the
+// authority's defaults.
+func (a *CertificateAuthority) SignIntermediateCA(csr *x509.CertificateRequest, parameters CAParameters) (*x509.Certificate, error) {
+	parameters.Endpoints = parameters.Endpoints.merge(a.Endpoints)
+	return SignIntermediateCA(csr, a.Certificate, a.Key, parameters)
+}
+
+// CAParameters sets parameters for creating a CA certificate.
+type CAParameters struct {
+	// MaxPathLen and MaxPathLenZero have the same meaning as in x509.Certificate: a MaxPathLen
+	// of 0 is treated as unset unless MaxPathLenZero is true. An intermediate with an unset
+	// path length under a constrained issuer gets one less than its issuer.
+	MaxPathLen     int
+	MaxPathLenZero bool
+	// SigningParameters sets the serial number, validity and signature algorithm. A zero
+	// NotBefore or NotAfter is replaced with CertificateNotBefore and CACertificateNotAfter.
+	// Policy is always replaced with one which issues a CA certificate.
+	SigningParameters
+}
+
+// caIssuancePolicy returns the policy used to issue a CA certificate.
+func caIssuancePolicy(maxPathLen int, maxPathLenZero bool) *IssuancePolicy {
+	return &IssuancePolicy{
+		BasicConstraints: ExtensionPolicyOverride,
+		IsCA:             true,
+		MaxPathLen:       maxPathLen,
+		MaxPathLenZero:   maxPathLenZero,
+		KeyUsage:         ExtensionPolicyOverride,
+		KeyUsageValue:    CAKeyUsage,
+		ExtKeyUsage:      ExtensionPolicyDrop,
+		SubjectAltNames:  ExtensionPolicyDrop,
+	}
+}
+
+// CreateRootCA creates a self-signed root CA certificate for the given key.
+func CreateRootCA(subject pkix.Name, key crypto.Signer, parameters CAParameters) (*x509.Certificate, error) {
+	csr, err := GenerateCSR(subject, CSRParameters{
+		KeyUsage:       CAKeyUsage,
+		IsCA:           true,
+		MaxPathLen:     parameters.MaxPathLen,
+		MaxPathLenZero: parameters.MaxPathLenZero,
+	}, key)
+	if err != nil {
+		return nil, err
+	}
+
+	signing := parameters.SigningParameters
+	if signing.NotBefore.IsZero() {
+		signing.NotBefore = CertificateNotBefore()
+	}
+	if signing.NotAfter.IsZero() {
+		signing.NotAfter = CACertificateNotAfter(0)
+	}
+	signing.Policy = caIssuancePolicy(parameters.MaxPathLen, parameters.MaxPathLenZero)
+
+	return SignCertificate(csr, nil, key, signing)
+}
+
+// CreateIntermediateCA creates an intermediate CA certificate for key, issued by authority.
+func CreateIntermediateCA(subject pkix.Name, key crypto.Signer, authority *x509.Certificate,
+	authorityKey crypto.Signer, parameters CAParameters) (*x509.Certificate, error) {
+	csr, err := GenerateCSR(subject, CSRParameters{
+		KeyUsage:       CAKeyUsage,
+		IsCA:           true,
+		MaxPathLen:     parameters.MaxPathLen,
+		MaxPathLenZero: parameters.MaxPathLenZero,
+	}, key)
+	if err != nil {
+		return nil, err
+	}
+	return SignIntermediateCA(csr, authority, authorityKey, parameters)
+}
+
+// SignIntermediateCA issues an intermediate CA certificate from a CSR. Only the subject and
+// public key are taken from the request; the CA properties come from parameters.
+func SignIntermediateCA(csr *x509.CertificateRequest, authority *x509.Certificate,
+	authorityKey crypto.Signer, parameters CAParameters) (*x509.Certificate, error) {
+	if authority == nil || !authority.BasicConstraintsValid || !authority.IsCA {
+		return nil, ErrAuthorityNotCA
+	}
+
+	maxPathLen, maxPathLenZero := parameters.MaxPathLen, parameters.MaxPathLenZero
+	childUnset := maxPathLen < 0 || (maxPathLen == 0 && !maxPathLenZero)
+	switch {
+	case authority.MaxPathLen == 0 && authority.MaxPathLenZero:
+		return nil, &ErrPathLenConstraint{"authority may not issue CA certificates"}
+	case authority.MaxPathLen > 0 && childUnset:
+		maxPathLen = authority.MaxPathLen - 1
+		maxPathLenZero = maxPathLen == 0
+	case authority.MaxPathLen > 0 && maxPathLen >= authority.MaxPathLen:
+		return nil, &ErrPathLenConstraint{fmt.Sprintf("path length %d must be less than the authority's %d",
+			maxPathLen, authority.MaxPathLen)}
+	}
+
+	signing := parameters.SigningParameters
+	if signing.NotBefore.IsZero() {
+		signing.NotBefore = CertificateNotBefore()
+	}
+	if signing.NotAfter.IsZero() {
+		signing.NotAfter = CACertificateNotAfter(0)
+	}
+	if signing.NotAfter.After(authority.NotAfter) {
+		signing.NotAfter = authority.NotAfter
+	}
+	signing.Policy = caIssuancePolicy(maxPathLen, maxPathLenZero)
+
+	return SignCertificate(csr, authority, authorityKey, signing)
+}
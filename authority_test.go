@@ -0,0 +1,104 @@
+package certutils
+
+import (
+	"crypto/x509"
+	"crypto/x509/pkix"
+
+	. "gopkg.in/check.v1"
+)
+
+type AuthoritySuite struct {
+}
+
+var _ = Suite(&AuthoritySuite{})
+
+func (s *AuthoritySuite) TestRootAndIntermediate(c *C) {
+	rootKey, err := GeneratePrivateKey(PrivateKeyTypeEcp384)
+	c.Assert(err, IsNil)
+	root, err := CreateRootCA(pkix.Name{CommonName: "Root CA"}, rootKey, CAParameters{MaxPathLen: 1})
+	c.Assert(err, IsNil)
+	c.Check(root.IsCA, Equals, true)
+	c.Check(root.MaxPathLen, Equals, 1)
+	c.Check(root.KeyUsage, Equals, CAKeyUsage)
+	c.Check(root.SubjectKeyId, Not(HasLen), 0)
+
+	intermediateKey, err := GeneratePrivateKey(PrivateKeyTypeEcp256)
+	c.Assert(err, IsNil)
+	intermediate, err := CreateIntermediateCA(pkix.Name{CommonName: "Intermediate CA"}, intermediateKey,
+		root, rootKey, CAParameters{})
+	c.Assert(err, IsNil)
+	c.Check(intermediate.IsCA, Equals, true)
+	c.Check(intermediate.MaxPathLen, Equals, 0)
+	c.Check(intermediate.MaxPathLenZero, Equals, true)
+	c.Check(intermediate.AuthorityKeyId, DeepEquals, root.SubjectKeyId)
+	c.Check(intermediate.NotAfter.After(root.NotAfter), Equals, false)
+
+	// The intermediate cannot issue further CAs...
+	subKey, err := GeneratePrivateKey(PrivateKeyTypeEcp256)
+	c.Assert(err, IsNil)
+	_, err = CreateIntermediateCA(pkix.Name{CommonName: "Sub CA"}, subKey, intermediate, intermediateKey, CAParameters{})
+	c.Assert(err, FitsTypeOf, &ErrPathLenConstraint{})
+
+	// ...but can issue leaves which chain to the root.
+	leaf := newTestLeaf(c, intermediate, intermediateKey, PrivateKeyTypeEcp256, "leaf.example.com")
+
+	roots := x509.NewCertPool()
+	roots.AddCert(root)
+	intermediates := x509.NewCertPool()
+	intermediates.AddCert(intermediate)
+	_, err = leaf.Leaf.Verify(x509.VerifyOptions{Roots: roots, Intermediates: intermediates, DNSName: "leaf.example.com"})
+	c.Assert(err, IsNil)
+}
+
+func (s *AuthoritySuite) TestPathLenZeroAndUnset(c *C) {
+	key, err := GeneratePrivateKey(PrivateKeyTypeEcp256)
+	c.Assert(err, IsNil)
+
+	unconstrained, err := CreateRootCA(pkix.Name{CommonName: "Unconstrained"}, key, CAParameters{})
+	c.Assert(err, IsNil)
+	c.Check(unconstrained.MaxPathLen, Equals, -1)
+
+	constrained, err := CreateRootCA(pkix.Name{CommonName: "Constrained"}, key, CAParameters{MaxPathLenZero: true})
+	c.Assert(err, IsNil)
+	c.Check(constrained.MaxPathLen, Equals, 0)
+	c.Check(constrained.MaxPathLenZero, Equals, true)
+
+	_, err = CreateIntermediateCA(pkix.Name{CommonName: "Sub CA"}, key, constrained, key, CAParameters{})
+	c.Assert(err, FitsTypeOf, &ErrPathLenConstraint{})
+}
+
+func (s *AuthoritySuite) TestEndpoints(c *C) {
+	caCert, caKey := newTestCA(c, PrivateKeyTypeEcp256)
+	ca := &CertificateAuthority{
+		Certificate: caCert,
+		Key:         caKey,
+		Endpoints: AuthorityEndpoints{
+			CRLDistributionPoints:  []string{"http://pki.example.com/ca.crl"},
+			OCSPServers:            []string{"http://ocsp.example.com"},
+			IssuingCertificateURLs: []string{"http://pki.example.com/ca.crt"},
+		},
+	}
+
+	key, err := GeneratePrivateKey(PrivateKeyTypeEcp256)
+	c.Assert(err, IsNil)
+	csr, err := GenerateCSR(pkix.Name{}, CSRParameters{KeyUsage: x509.KeyUsageDigitalSignature}, key, "aia.example.com")
+	c.Assert(err, IsNil)
+
+	cert, err := ca.Sign(csr, SigningParameters{
+		NotBefore: CertificateNotBefore(),
+		NotAfter:  CertificateNotAfter(0, caCert),
+		Endpoints: AuthorityEndpoints{OCSPServers: []string{"http://ocsp2.example.com"}},
+	})
+	c.Assert(err, IsNil)
+	c.Check(cert.CRLDistributionPoints, DeepEquals, []string{"http://pki.example.com/ca.crl"})
+	c.Check(cert.OCSPServer, DeepEquals, []string{"http://ocsp2.example.com"})
+	c.Check(cert.IssuingCertificateURL, DeepEquals, []string{"http://pki.example.com/ca.crt"})
+
+	intermediateKey, err := GeneratePrivateKey(PrivateKeyTypeEcp256)
+	c.Assert(err, IsNil)
+	intermediateCSR, err := GenerateCSR(pkix.Name{CommonName: "Intermediate"}, CSRParameters{}, intermediateKey)
+	c.Assert(err, IsNil)
+	intermediate, err := ca.SignIntermediateCA(intermediateCSR, CAParameters{})
+	c.Assert(err, IsNil)
+	c.Check(intermediate.CRLDistributionPoints, DeepEquals, []string{"http://pki.example.com/ca.crl"})
+}
@@ -0,0 +1,138 @@
+package certutils
+
+import (
+	"encoding/asn1"
+	"errors"
+)
+
+var errMalformedBER = errors.New("malformed BER encoding")
+
+// maxBERDepth bounds the nesting of elements berToDER accepts, so hostile input cannot
+// exhaust the stack. Real PKCS#7 and PKCS#12 files nest less than 20 deep.
+const maxBERDepth = 64
+
+// berToDER re-encodes BER with definite lengths and merges constructed OCTET STRINGs, which
+// is enough for encoding/asn1 to read the PKCS#7 and PKCS#12 files written by Windows and
+// Java. Primitive contents, and so DER input, are left unchanged.
+func berToDER(data []byte) ([]byte, error) {
+	der, rest, err := berElementToDER(data, 0)
+	if err != nil {
+		return nil, err
+	}
+	if len(rest) > 0 {
+		return nil, errMalformedBER
+	}
+	return der, nil
+}
+
+// berElementToDER converts the first element of data, which is nested depth elements deep,
+// and returns it with the data following it.
+func berElementToDER(data []byte, depth int) ([]byte, []byte, error) {
+	if depth > maxBERDepth {
+		return nil, nil, errMalformedBER
+	}
+	tagLength := 1
+	if len(data) > 0 && data[0]&0x1f == 0x1f {
+		for tagLength < len(data) && data[tagLength]&0x80 != 0 {
+			tagLength++
+		}
+		tagLength++
+	}
+	if len(data) < tagLength+1 {
+		return nil, nil, errMalformedBER
+	}
+	identifier := data[:tagLength]
+	constructed := data[0]&0x20 != 0
+
+	var contents, rest []byte
+	lengthByte := data[tagLength]
+	offset := tagLength + 1
+	switch {
+	case lengthByte == 0x80:
+		if !constructed {
+			return nil, nil, errMalformedBER
+		}
+		rest = data[offset:]
+		for {
+			if len(rest) >= 2 && rest[0] == 0 && rest[1] == 0 {
+				rest = rest[2:]
+				break
+			}
+			if len(rest) == 0 {
+				return nil, nil, errMalformedBER
+			}
+			child, remaining, err := berElementToDER(rest, depth+1)
+			if err != nil {
+				return nil, nil, err
+			}
+			contents = append(contents, child...)
+			rest = remaining
+		}
+	default:
+		length := int(lengthByte)
+		if lengthByte&0x80 != 0 {
+			count := int(lengthByte & 0x7f)
+			if count > 4 || len(data) < offset+count {
+				return nil, nil, errMalformedBER
+			}
+			length = 0
+			for _, b := range data[offset : offset+count] {
+				length = length<<8 | int(b)
+			}
+			offset += count
+		}
+		if length < 0 || len(data)-offset < length {
+			return nil, nil, errMalformedBER
+		}
+		contents, rest = data[offset:offset+length], data[offset+length:]
+		if constructed {
+			var children []byte
+			for remaining := contents; len(remaining) > 0; {
+				child, next, err := berElementToDER(remaining, depth+1)
+				if err != nil {
+					return nil, nil, err
+				}
+				children = append(children, child...)
+				remaining = next
+			}
+			contents = children
+		}
+	}
+
+	if len(identifier) == 1 && identifier[0] == 0x24 {
+		merged, err := concatenateOctetStrings(contents)
+		if err != nil {
+			return nil, nil, err
+		}
+		identifier, contents = []byte{0x04}, merged
+	}
+	return append(derHeader(identifier, len(contents)), contents...), rest, nil
+}
+
+// concatenateOctetStrings joins the contents of a series of DER OCTET STRINGs.
+func concatenateOctetStrings(data []byte) ([]byte, error) {
+	joined := []byte{}
+	for len(data) > 0 {
+		var segment []byte
+		var err error
+		if data, err = asn1.Unmarshal(data, &segment); err != nil {
+			return nil, errMalformedBER
+		}
+		joined = append(joined, segment...)
+	}
+	return joined, nil
+}
+
+// derHeader returns the identifier and definite length octets of a DER element.
+func derHeader(identifier []byte, length int) []byte {
+	header := append([]byte{}, identifier...)
+	if length < 0x80 {
+		return append(header, byte(length))
+	}
+	var octets []byte
+	for l := length; l > 0; l >>= 8 {
+		octets = append([]byte{byte(l)}, octets...)
+	}
+	header = append(header, 0x80|byte(len(octets)))
+	return append(header, octets...)
+}
@@ -0,0 +1,414 @@
+package certutils
+
+import (
+	"bytes"
+	"crypto"
+	"crypto/x509"
+	"encoding/asn1"
+	"encoding/base64"
+	"encoding/pem"
+	"errors"
+	"fmt"
+	"unicode"
+)
+
+var ErrNoBundleItems = errors.New("no certificates, keys, requests or CRLs found")
+var ErrUnrecognizedBundleData = errors.New("data is not a certificate, key, request, CRL or bundle of them")
+var ErrMalformedPEMBlock = errors.New("malformed PEM block")
+
+// BundleItemType is the kind of a BundleItem.
+type BundleItemType int
+
+const (
+	// BundleItemUnknown is data which could not be identified.
+	BundleItemUnknown BundleItemType = iota
+	BundleItemCertificate
+	BundleItemPrivateKey
+	BundleItemCertificateRequest
+	BundleItemCRL
+)
+
+func (t BundleItemType) String() string {
+	switch t {
+	case BundleItemUnknown:
+		return "unknown"
+	case BundleItemCertificate:
+		return "certificate"
+	case BundleItemPrivateKey:
+		return "private key"
+	case BundleItemCertificateRequest:
+		return "certificate request"
+	case BundleItemCRL:
+		return "CRL"
+	default:
+		return fmt.Sprintf("BundleItemType(%d)", int(t))
+	}
+}
+
+// BundleFormat is the format a BundleItem was read from.
+type BundleFormat int
+
+const (
+	BundleFormatPEM BundleFormat = iota
+	BundleFormatDER
+	// BundleFormatBase64 is DER encoded as base64 without PEM armor.
+	BundleFormatBase64
+	BundleFormatPKCS7
+	BundleFormatPKCS12
+)
+
+func (f BundleFormat) String() string {
+	switch f {
+	case BundleFormatPEM:
+		return "PEM"
+	case BundleFormatDER:
+		return "DER"
+	case BundleFormatBase64:
+		return "base64"
+	case BundleFormatPKCS7:
+		return "PKCS#7"
+	case BundleFormatPKCS12:
+		return "PKCS#12"
+	default:
+		return fmt.Sprintf("BundleFormat(%d)", int(f))
+	}
+}
+
+// BundleOrigin records where in its input a BundleItem was found.
+type BundleOrigin struct {
+	// Block is the index of the PEM block, or of the top level DER element, the item was read
+	// from. Items from one PKCS#7 or PKCS#12 bundle share its block.
+	Block int
+	// Offset is the byte offset of the block in the input. For base64 input it is the offset
+	// of the base64 text.
+	Offset int
+	// Format is PKCS#7 or PKCS#12 for items from such a bundle, however the bundle was
+	// encoded, and otherwise the encoding of the block.
+	Format BundleFormat
+}
+
+// BundleItem is a certificate, private key, certificate request or CRL found by LoadBundle.
+// The field matching Type is set unless Err is.
+type BundleItem struct {
+	Type   BundleItemType
+	Origin BundleOrigin
+
+	Certificate        *x509.Certificate
+	PrivateKey         crypto.PrivateKey
+	CertificateRequest *x509.CertificateRequest
+	CRL                *x509.RevocationList
+
+	// Err is why the item could not be read. Type is still set if it is known what the item
+	// was meant to be.
+	Err error
+}
+
+// Bundle holds the items found by LoadBundle in the order they appear in the input. Keys from
+// a PKCS#12 bundle come before its certificates.
+type Bundle struct {
+	Items []BundleItem
+}
+
+// Certificates returns the certificates which were read successfully.
+func (b *Bundle) Certificates() []*x509.Certificate {
+	certificates := []*x509.Certificate{}
+	for _, item := range b.Items {
+		if item.Type == BundleItemCertificate && item.Err == nil {
+			certificates = append(certificates, item.Certificate)
+		}
+	}
+	return certificates
+}
+
+// PrivateKeys returns the private keys which were read successfully.
+func (b *Bundle) PrivateKeys() []crypto.PrivateKey {
+	keys := []crypto.PrivateKey{}
+	for _, item := range b.Items {
+		if item.Type == BundleItemPrivateKey && item.Err == nil {
+			keys = append(keys, item.PrivateKey)
+		}
+	}
+	return keys
+}
+
+// CertificateRequests returns the certificate requests which were read successfully.
+func (b *Bundle) CertificateRequests() []*x509.CertificateRequest {
+	requests := []*x509.CertificateRequest{}
+	for _, item := range b.Items {
+		if item.Type == BundleItemCertificateRequest && item.Err == nil {
+			requests = append(requests, item.CertificateRequest)
+		}
+	}
+	return requests
+}
+
+// CRLs returns the CRLs which were read successfully.
+func (b *Bundle) CRLs() []*x509.RevocationList {
+	crls := []*x509.RevocationList{}
+	for _, item := range b.Items {
+		if item.Type == BundleItemCRL && item.Err == nil {
+			crls = append(crls, item.CRL)
+		}
+	}
+	return crls
+}
+
+// Err joins the errors of the items which could not be read, or returns nil if there are none.
+func (b *Bundle) Err() error {
+	var errs []error
+	for _, item := range b.Items {
+		if item.Err != nil {
+			errs = append(errs, fmt.Errorf("%s block %d at offset %d: %w",
+				item.Origin.Format, item.Origin.Block, item.Origin.Offset, item.Err))
+		}
+	}
+	return errors.Join(errs...)
+}
+
+// LoadBundle reads the certificates, private keys, certificate requests and CRLs in data,
+// which may be PEM, DER or base64 encoded DER without PEM armor. PEM may hold any mix of
+// blocks, and DER any number of concatenated elements, each of which may also be a PKCS#7 or
+// PKCS#12 bundle. PEM blocks of other types are skipped.
+//
+// Items which cannot be read are returned with their error rather than failing the whole
+// input; see Bundle.Err. An error is only returned if nothing at all is found. passphrase
+// decrypts encrypted keys and PKCS#12 bundles, and is invoked at most once.
+func LoadBundle(data []byte, passphrase PassphraseFunc) (*Bundle, error) {
+	loader := &bundleLoader{passphrase: passphrase, bundle: &Bundle{}}
+	switch {
+	case len(bytes.TrimSpace(data)) == 0:
+	case bytes.Contains(data, []byte("-----BEGIN ")):
+		loader.loadPEM(data)
+	default:
+		if offset, der, ok := decodeBareBase64(data); ok {
+			loader.loadDER(der, offset, BundleFormatBase64)
+		} else {
+			loader.loadDER(data, 0, BundleFormatDER)
+		}
+	}
+	if len(loader.bundle.Items) == 0 {
+		return nil, ErrNoBundleItems
+	}
+	return loader.bundle, nil
+}
+
+type bundleLoader struct {
+	passphrase PassphraseFunc
+	bundle     *Bundle
+
+	prompted      bool
+	password      []byte
+	passwordError error
+}
+
+func (l *bundleLoader) add(item BundleItem) {
+	l.bundle.Items = append(l.bundle.Items, item)
+}
+
+// getPassword invokes the passphrase callback the first time it is called.
+func (l *bundleLoader) getPassword() ([]byte, error) {
+	if l.passphrase == nil {
+		return nil, ErrPassphraseRequired
+	}
+	if !l.prompted {
+		l.password, l.passwordError = l.passphrase()
+		l.prompted = true
+	}
+	return l.password, l.passwordError
+}
+
+// passphraseFunc returns getPassword as a PassphraseFunc, or nil if there is no passphrase.
+func (l *bundleLoader) passphraseFunc() PassphraseFunc {
+	if l.passphrase == nil {
+		return nil
+	}
+	return l.getPassword
+}
+
+// loadPEM reads each PEM block on its own, so a malformed block is reported rather than
+// skipped.
+func (l *bundleLoader) loadPEM(data []byte) {
+	begin := []byte("-----BEGIN ")
+	offset := bytes.Index(data, begin)
+	for index := 0; offset >= 0; index++ {
+		end := len(data)
+		next := bytes.Index(data[offset+len(begin):], begin)
+		if next >= 0 {
+			end = offset + len(begin) + next
+		}
+
+		origin := BundleOrigin{Block: index, Offset: offset, Format: BundleFormatPEM}
+		if block, _ := pem.Decode(data[offset:end]); block != nil {
+			l.loadPEMBlock(block, origin)
+		} else {
+			l.add(BundleItem{Origin: origin, Err: ErrMalformedPEMBlock})
+		}
+
+		if next < 0 {
+			break
+		}
+		offset = end
+	}
+}
+
+func (l *bundleLoader) loadPEMBlock(block *pem.Block, origin BundleOrigin) {
+	switch block.Type {
+	case CertificateBlockType:
+		certificate, err := x509.ParseCertificate(block.Bytes)
+		l.add(BundleItem{Type: BundleItemCertificate, Origin: origin, Certificate: certificate, Err: err})
+	// Some Windows tools write requests as "NEW CERTIFICATE REQUEST".
+	case CertificateRequestBlockType, "NEW CERTIFICATE REQUEST":
+		request, err := x509.ParseCertificateRequest(block.Bytes)
+		l.add(BundleItem{Type: BundleItemCertificateRequest, Origin: origin, CertificateRequest: request, Err: err})
+	case CRLBlockType:
+		crl, err := x509.ParseRevocationList(block.Bytes)
+		l.add(BundleItem{Type: BundleItemCRL, Origin: origin, CRL: crl, Err: err})
+	case PKCS7BlockType:
+		l.loadPKCS7(block.Bytes, origin)
+	case RSAKeyBlockType, ECKeyBlockType, PrivateKeyBlockType, EncryptedPrivateKeyBlockType:
+		var key crypto.PrivateKey
+		var err error
+		if block.Headers["Proc-Type"] == "4,ENCRYPTED" {
+			var password []byte
+			if password, err = l.getPassword(); err == nil {
+				key, err = DecryptPrivateKey(block, password)
+			}
+		} else {
+			key, err = parseDERPrivateKey(block.Bytes, l.passphraseFunc())
+		}
+		l.add(BundleItem{Type: BundleItemPrivateKey, Origin: origin, PrivateKey: key, Err: err})
+	}
+}
+
+// loadDER reads each top level element of data. offset is the position of data in the input.
+func (l *bundleLoader) loadDER(data []byte, offset int, format BundleFormat) {
+	for index, rest := 0, data; len(rest) > 0; index++ {
+		origin := BundleOrigin{Block: index, Offset: offset, Format: format}
+		if format == BundleFormatDER {
+			origin.Offset += len(data) - len(rest)
+		}
+		// Splitting on BER lets indefinite length PKCS#7 and PKCS#12 bundles through.
+		_, next, err := berElementToDER(rest, 0)
+		if err != nil {
+			l.add(BundleItem{Origin: origin, Err: ErrUnrecognizedBundleData})
+			return
+		}
+		l.loadDERElement(rest[:len(rest)-len(next)], origin)
+		rest = next
+	}
+}
+
+func (l *bundleLoader) loadDERElement(der []byte, origin BundleOrigin) {
+	if certificate, err := x509.ParseCertificate(der); err == nil {
+		l.add(BundleItem{Type: BundleItemCertificate, Origin: origin, Certificate: certificate})
+		return
+	}
+	if request, err := x509.ParseCertificateRequest(der); err == nil {
+		l.add(BundleItem{Type: BundleItemCertificateRequest, Origin: origin, CertificateRequest: request})
+		return
+	}
+	if crl, err := x509.ParseRevocationList(der); err == nil {
+		l.add(BundleItem{Type: BundleItemCRL, Origin: origin, CRL: crl})
+		return
+	}
+	if isPKCS7(der) {
+		l.loadPKCS7(der, origin)
+		return
+	}
+	if isPFX(der) {
+		l.loadPKCS12(der, origin)
+		return
+	}
+
+	key, err := parseDERPrivateKey(der, l.passphraseFunc())
+	switch {
+	case err == nil:
+		l.add(BundleItem{Type: BundleItemPrivateKey, Origin: origin, PrivateKey: key})
+	case errors.Is(err, ErrPassphraseRequired), errors.Is(err, ErrIncorrectPassphrase),
+		errors.Is(err, ErrUnsupportedKeyEncryption):
+		l.add(BundleItem{Type: BundleItemPrivateKey, Origin: origin, Err: err})
+	default:
+		l.add(BundleItem{Origin: origin, Err: ErrUnrecognizedBundleData})
+	}
+}
+
+func (l *bundleLoader) loadPKCS7(der []byte, origin BundleOrigin) {
+	origin.Format = BundleFormatPKCS7
+	certificates, err := ParsePKCS7Certificates(der)
+	if err != nil {
+		l.add(BundleItem{Type: BundleItemCertificate, Origin: origin, Err: err})
+		return
+	}
+	for _, certificate := range certificates {
+		l.add(BundleItem{Type: BundleItemCertificate, Origin: origin, Certificate: certificate})
+	}
+}
+
+// loadPKCS12 reads a PKCS#12 bundle, trying an empty password if there is no passphrase.
+func (l *bundleLoader) loadPKCS12(der []byte, origin BundleOrigin) {
+	origin.Format = BundleFormatPKCS12
+	var bundle *PKCS12Bundle
+	var err error
+	if l.passphrase == nil {
+		if bundle, err = DecodePKCS12(der, nil); errors.Is(err, ErrIncorrectPassphrase) {
+			err = ErrPassphraseRequired
+		}
+	} else {
+		var password []byte
+		if password, err = l.getPassword(); err == nil {
+			bundle, err = DecodePKCS12(der, password)
+		}
+	}
+	if err != nil {
+		l.add(BundleItem{Origin: origin, Err: err})
+		return
+	}
+	for _, key := range bundle.Keys {
+		l.add(BundleItem{Type: BundleItemPrivateKey, Origin: origin, PrivateKey: key.PrivateKey})
+	}
+	for _, certificate := range bundle.Certificates {
+		l.add(BundleItem{Type: BundleItemCertificate, Origin: origin, Certificate: certificate.Certificate})
+	}
+}
+
+// isPKCS7 reports whether der is a PKCS#7 SignedData.
+func isPKCS7(der []byte) bool {
+	der, err := berToDER(der)
+	if err != nil {
+		return false
+	}
+	var info pkcs7ContentInfo
+	rest, err := asn1.Unmarshal(der, &info)
+	return err == nil && len(rest) == 0 && info.ContentType.Equal(oidPKCS7SignedData)
+}
+
+// isPFX reports whether der has the structure of a PKCS#12 bundle.
+func isPFX(der []byte) bool {
+	der, err := berToDER(der)
+	if err != nil {
+		return false
+	}
+	var pfx pfxPDU
+	rest, err := asn1.Unmarshal(der, &pfx)
+	return err == nil && len(rest) == 0 && pfx.Version == 3
+}
+
+// decodeBareBase64 decodes data which is nothing but base64 and whitespace, returning the
+// offset at which the base64 starts.
+func decodeBareBase64(data []byte) (int, []byte, bool) {
+	trimmed := bytes.TrimLeftFunc(data, unicode.IsSpace)
+	compact := bytes.Map(func(r rune) rune {
+		if unicode.IsSpace(r) {
+			return -1
+		}
+		return r
+	}, trimmed)
+	if len(compact) == 0 {
+		return 0, nil, false
+	}
+	decoded, err := base64.StdEncoding.DecodeString(string(compact))
+	if err != nil {
+		return 0, nil, false
+	}
+	return len(data) - len(trimmed), decoded, true
+}
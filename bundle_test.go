@@ -0,0 +1,192 @@
+package certutils
+
+import (
+	"bytes"
+	"crypto"
+	"crypto/x509"
+	"crypto/x509/pkix"
+	"encoding/base64"
+	"encoding/pem"
+	"math/big"
+
+	. "gopkg.in/check.v1"
+)
+
+type BundleSuite struct {
+}
+
+var _ = Suite(&BundleSuite{})
+
+func (s *BundleSuite) TestLoadPEM(c *C) {
+	ca, caKey := newTestCA(c, PrivateKeyTypeEcp256)
+	csr, err := GenerateCSR(pkix.Name{CommonName: "www.example.com"}, CSRParameters{}, caKey, "www.example.com")
+	c.Assert(err, IsNil)
+	crl, err := CreateCRL(nil, ca, caKey, CRLParameters{Number: big.NewInt(1)})
+	c.Assert(err, IsNil)
+	key1, err := GeneratePrivateKey(PrivateKeyTypeEcp256)
+	c.Assert(err, IsNil)
+	key2, err := GeneratePrivateKey(PrivateKeyTypeEd25519)
+	c.Assert(err, IsNil)
+
+	certPEM, err := EncodeCertificates(ca)
+	c.Assert(err, IsNil)
+	csrPEM, err := EncodeRequest(csr)
+	c.Assert(err, IsNil)
+	crlPEM, err := EncodeCRLs(crl)
+	c.Assert(err, IsNil)
+	keysPEM, err := EncodeEncryptedKeys([]byte("secret"), KeyEncryptionParameters{Iterations: 1000}, key1, key2)
+	c.Assert(err, IsNil)
+
+	var data bytes.Buffer
+	data.WriteString("subject=CN = Test CA\n")
+	data.Write(certPEM)
+	data.Write(pem.EncodeToMemory(&pem.Block{Type: "DH PARAMETERS", Bytes: []byte{1}}))
+	data.Write(csrPEM)
+	data.Write(crlPEM)
+	keysOffset := data.Len()
+	data.Write(keysPEM)
+	data.Write(pem.EncodeToMemory(&pem.Block{Type: CertificateBlockType, Bytes: ca.Raw[:20]}))
+	truncatedOffset := data.Len()
+	data.Write(certPEM[:len(certPEM)/2])
+
+	calls := 0
+	bundle, err := LoadBundle(data.Bytes(), func() ([]byte, error) {
+		calls++
+		return []byte("secret"), nil
+	})
+	c.Assert(err, IsNil)
+	c.Check(calls, Equals, 1)
+	c.Assert(bundle.Items, HasLen, 7)
+
+	types := []BundleItemType{}
+	blocks := []int{}
+	for _, item := range bundle.Items {
+		types = append(types, item.Type)
+		blocks = append(blocks, item.Origin.Block)
+		c.Check(item.Origin.Format, Equals, BundleFormatPEM)
+	}
+	c.Check(types, DeepEquals, []BundleItemType{BundleItemCertificate, BundleItemCertificateRequest,
+		BundleItemCRL, BundleItemPrivateKey, BundleItemPrivateKey, BundleItemCertificate, BundleItemUnknown})
+	c.Check(blocks, DeepEquals, []int{0, 2, 3, 4, 5, 6, 7})
+	c.Check(bundle.Items[0].Origin.Offset, Equals, len("subject=CN = Test CA\n"))
+	c.Check(bundle.Items[3].Origin.Offset, Equals, keysOffset)
+	c.Check(bundle.Items[6].Origin.Offset, Equals, truncatedOffset)
+
+	c.Check(bundle.Certificates(), DeepEquals, []*x509.Certificate{ca})
+	c.Check(bundle.CertificateRequests()[0].Raw, DeepEquals, csr.Raw)
+	c.Check(bundle.CRLs()[0].Raw, DeepEquals, crl.Raw)
+	c.Check(bundle.PrivateKeys(), DeepEquals, []crypto.PrivateKey{key1, key2})
+	c.Check(bundle.Items[5].Err, NotNil)
+	c.Check(bundle.Items[6].Err, Equals, ErrMalformedPEMBlock)
+	c.Check(bundle.Err(), ErrorMatches, "(?s)PEM block 6 at offset .*\nPEM block 7 at offset .*: malformed PEM block")
+
+	// Without a passphrase the encrypted keys are reported, not dropped.
+	bundle, err = LoadBundle(keysPEM, nil)
+	c.Assert(err, IsNil)
+	c.Assert(bundle.Items, HasLen, 2)
+	c.Check(bundle.Items[0].Type, Equals, BundleItemPrivateKey)
+	c.Check(bundle.Items[0].Err, Equals, ErrPassphraseRequired)
+	c.Check(bundle.PrivateKeys(), HasLen, 0)
+}
+
+func (s *BundleSuite) TestLoadDER(c *C) {
+	intermediate, intermediateKey, root := newTestIntermediateCA(c)
+	csr, err := GenerateCSR(pkix.Name{CommonName: "www.example.com"}, CSRParameters{}, intermediateKey, "www.example.com")
+	c.Assert(err, IsNil)
+	crl, err := CreateCRL(nil, intermediate, intermediateKey, CRLParameters{Number: big.NewInt(1)})
+	c.Assert(err, IsNil)
+	key, err := GeneratePrivateKey(PrivateKeyTypeEcp256)
+	c.Assert(err, IsNil)
+	keyDER, err := x509.MarshalPKCS8PrivateKey(key)
+	c.Assert(err, IsNil)
+	p7b, err := MarshalPKCS7Certificates(intermediate, root)
+	c.Assert(err, IsNil)
+
+	elements := [][]byte{root.Raw, indefiniteLengthBER(c, p7b), csr.Raw, crl.Raw, keyDER, {0x05, 0x00}}
+	data := bytes.Join(elements, nil)
+	bundle, err := LoadBundle(data, nil)
+	c.Assert(err, IsNil)
+
+	offsets := []int{}
+	for offset, i := 0, 0; i < len(elements); i++ {
+		offsets = append(offsets, offset)
+		offset += len(elements[i])
+	}
+	expected := []struct {
+		itemType BundleItemType
+		block    int
+		format   BundleFormat
+	}{
+		{BundleItemCertificate, 0, BundleFormatDER},
+		{BundleItemCertificate, 1, BundleFormatPKCS7},
+		{BundleItemCertificate, 1, BundleFormatPKCS7},
+		{BundleItemCertificateRequest, 2, BundleFormatDER},
+		{BundleItemCRL, 3, BundleFormatDER},
+		{BundleItemPrivateKey, 4, BundleFormatDER},
+		{BundleItemUnknown, 5, BundleFormatDER},
+	}
+	c.Assert(bundle.Items, HasLen, len(expected))
+	for i, item := range bundle.Items {
+		c.Check(item.Type, Equals, expected[i].itemType)
+		c.Check(item.Origin, Equals, BundleOrigin{Block: expected[i].block, Offset: offsets[expected[i].block], Format: expected[i].format})
+	}
+	c.Check(bundle.Certificates(), DeepEquals, []*x509.Certificate{root, intermediate, root})
+	c.Check(bundle.PrivateKeys(), DeepEquals, []crypto.PrivateKey{key})
+	c.Check(bundle.Items[6].Err, Equals, ErrUnrecognizedBundleData)
+
+	// The same data without armor.
+	encoded := base64.StdEncoding.EncodeToString(root.Raw)
+	bundle, err = LoadBundle([]byte("\n"+encoded[:64]+"\n"+encoded[64:]+"\n"), nil)
+	c.Assert(err, IsNil)
+	c.Assert(bundle.Items, HasLen, 1)
+	c.Check(bundle.Items[0].Origin, Equals, BundleOrigin{Block: 0, Offset: 1, Format: BundleFormatBase64})
+	c.Check(bundle.Certificates(), DeepEquals, []*x509.Certificate{root})
+}
+
+func (s *BundleSuite) TestLoadPKCS12(c *C) {
+	intermediate, intermediateKey, _ := newTestIntermediateCA(c)
+	leaf := newTestLeaf(c, intermediate, intermediateKey, PrivateKeyTypeEcp256, "www.example.com")
+
+	pfx, err := EncodePKCS12(*leaf, []byte("secret"), PKCS12Options{})
+	c.Assert(err, IsNil)
+	calls := 0
+	bundle, err := LoadBundle(pfx, func() ([]byte, error) {
+		calls++
+		return []byte("secret"), nil
+	})
+	c.Assert(err, IsNil)
+	c.Check(calls, Equals, 1)
+	c.Assert(bundle.Items, HasLen, 1+len(leaf.Certificate))
+	c.Check(bundle.Items[0].Type, Equals, BundleItemPrivateKey)
+	c.Check(bundle.Items[0].Origin, Equals, BundleOrigin{Format: BundleFormatPKCS12})
+	c.Check(bundle.PrivateKeys(), DeepEquals, []crypto.PrivateKey{leaf.PrivateKey})
+	for i, certificate := range bundle.Certificates() {
+		c.Check(certificate.Raw, DeepEquals, leaf.Certificate[i])
+	}
+
+	bundle, err = LoadBundle(pfx, nil)
+	c.Assert(err, IsNil)
+	c.Assert(bundle.Items, HasLen, 1)
+	c.Check(bundle.Items[0].Err, Equals, ErrPassphraseRequired)
+
+	bundle, err = LoadBundle(pfx, func() ([]byte, error) { return []byte("wrong"), nil })
+	c.Assert(err, IsNil)
+	c.Check(bundle.Items[0].Err, Equals, ErrIncorrectPassphrase)
+
+	// A bundle without a password needs no passphrase.
+	pfx, err = EncodePKCS12(*leaf, nil, PKCS12Options{})
+	c.Assert(err, IsNil)
+	bundle, err = LoadBundle(pfx, nil)
+	c.Assert(err, IsNil)
+	c.Check(bundle.Err(), IsNil)
+	c.Check(bundle.Certificates(), HasLen, len(leaf.Certificate))
+}
+
+func (s *BundleSuite) TestNoItems(c *C) {
+	_, err := LoadBundle(nil, nil)
+	c.Check(err, Equals, ErrNoBundleItems)
+	_, err = LoadBundle([]byte(" \n"), nil)
+	c.Check(err, Equals, ErrNoBundleItems)
+	_, err = LoadBundle(pem.EncodeToMemory(&pem.Block{Type: "DH PARAMETERS", Bytes: []byte{1}}), nil)
+	c.Check(err, Equals, ErrNoBundleItems)
+}
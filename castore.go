@@ -0,0 +1,596 @@
+package certutils
+
+import (
+	"crypto"
+	"crypto/x509"
+	"errors"
+	"fmt"
+	"math/big"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/spf13/afero"
+)
+
+// The layout of a FileCAStore, which follows easy-rsa.
+const (
+	CAStoreCertificateFile    = "ca.crt"
+	CAStoreKeyFile            = "ca.key"
+	CAStoreSerialFile         = "serial"
+	CAStoreCRLNumberFile      = "crlnumber"
+	CAStoreCRLFile            = "crl.pem"
+	CAStoreIndexFile          = "index.txt"
+	CAStorePrivateDir         = "private"
+	CAStoreIssuedDir          = "issued"
+	CAStoreRequestsDir        = "reqs"
+	CAStoreCertsBySerialDir   = "certs_by_serial"
+	CAStoreRevokedDir         = "revoked"
+	CAStoreRevokedCertsDir    = "revoked/certs_by_serial"
+	CAStoreRevokedKeysDir     = "revoked/private_by_serial"
+	CAStoreRevokedRequestsDir = "revoked/reqs_by_serial"
+)
+
+const (
+	caStorePrivateFileMode    os.FileMode = 0600
+	caStorePrivateDirFileMode os.FileMode = 0700
+	caStorePublicFileMode     os.FileMode = 0644
+	caStorePublicDirFileMode  os.FileMode = 0755
+
+	caStoreCertificateExt   = ".crt"
+	caStoreKeyExt           = ".key"
+	caStoreRequestExt       = ".req"
+	caStoreCertsBySerialExt = ".pem"
+)
+
+var ErrCertificateNotFound = errors.New("certificate not found in store")
+var ErrInvalidStoreName = errors.New("store name must be a single non-empty path element and not the CA's name")
+
+// ErrStoreEntryExists is returned when writing would replace a different certificate, key
+// or request already in the store.
+type ErrStoreEntryExists struct {
+	Path string
+}
+
+func (e ErrStoreEntryExists) Error() string {
+	return fmt.Sprintf("store entry already exists: %s", e.Path)
+}
+
+// StoredCertificate is the index entry for a certificate issued by the store's CA.
+type StoredCertificate struct {
+	SerialNumber *big.Int
+	// Name is the base file name the certificate, its key and request are stored under.
+	Name string
+	// Subject is the distinguished name in the format of OpenSSLOneLineName.
+	Subject  string
+	NotAfter time.Time
+	// Expired is set once UpdateExpired has marked the certificate expired.
+	Expired bool
+	// Revoked, RevokedAt, Reason and InvalidityDate record the revocation of the certificate.
+	Revoked        bool
+	RevokedAt      time.Time
+	Reason         RevocationReason
+	InvalidityDate time.Time
+}
+
+// FileCAStore keeps a CA certificate and key, issued certificates, their keys and requests,
+// and revocation state in a directory with an easy-rsa like layout:
+//
+//	ca.crt                       CA certificate
+//	private/ca.key               CA key
+//	private/<name>.key           keys generated for issued certificates
+//	reqs/<name>.req              certificate requests
+//	issued/<name>.crt            issued certificates
+//	certs_by_serial/<serial>.pem every issued certificate by serial number
+//	revoked/                     certificates, keys and requests moved aside on revocation
+//	serial, crlnumber            the next serial and CRL number in hexadecimal
+//	index.txt, index.txt.attr    the database of issued certificates, as used by openssl ca
+//	crl.pem                      the current CRL
+//
+// Names are derived from common names with CommonNameToFileName. Files are written atomically
+// and private keys are only readable by their owner. The serial, crlnumber and index files are
+// compatible with "openssl ca" configured with this directory as its dir and certs_by_serial
+// as its new_certs_dir, so either may issue and revoke certificates.
+type FileCAStore struct {
+	// Passphrase, if set, encrypts private keys written to the store and decrypts them when
+	// read.
+	Passphrase PassphraseFunc
+	// KeyEncryption sets how private keys are encrypted when Passphrase is set.
+	KeyEncryption KeyEncryptionParameters
+
+	fs   afero.Fs
+	root string
+	mu   sync.Mutex
+}
+
+// NewFileCAStore returns a FileCAStore rooted at the given directory. Call Init to create
+// the directory structure.
+func NewFileCAStore(fs afero.Fs, root string) *FileCAStore {
+	return &FileCAStore{fs: fs, root: root}
+}
+
+func (s *FileCAStore) path(elem ...string) string {
+	return filepath.Join(append([]string{s.root}, elem...)...)
+}
+
+// entryPath returns the path of the entry name with extension ext in dir, rejecting names
+// which would resolve outside dir or collide with the CA's own files.
+func (s *FileCAStore) entryPath(dir, name, ext string) (string, error) {
+	if !validStoreName(name) {
+		return "", ErrInvalidStoreName
+	}
+	return s.path(dir, name+ext), nil
+}
+
+// validStoreName reports whether name can be used for an issued certificate, its key and
+// request. The CA key is kept alongside entry keys in private/, so its name is reserved,
+// ignoring case for case-insensitive filesystems.
+func validStoreName(name string) bool {
+	return name != "" && !strings.ContainsAny(name, `/\`) &&
+		!strings.EqualFold(name, strings.TrimSuffix(CAStoreKeyFile, caStoreKeyExt))
+}
+
+// Init creates the store's directories and an empty index. It is safe to call on an existing store.
+func (s *FileCAStore) Init() error {
+	dirs := map[string]os.FileMode{
+		"":                        caStorePublicDirFileMode,
+		CAStorePrivateDir:         caStorePrivateDirFileMode,
+		CAStoreIssuedDir:          caStorePublicDirFileMode,
+		CAStoreRequestsDir:        caStorePublicDirFileMode,
+		CAStoreCertsBySerialDir:   caStorePublicDirFileMode,
+		CAStoreRevokedDir:         caStorePublicDirFileMode,
+		CAStoreRevokedCertsDir:    caStorePublicDirFileMode,
+		CAStoreRevokedKeysDir:     caStorePrivateDirFileMode,
+		CAStoreRevokedRequestsDir: caStorePublicDirFileMode,
+	}
+	for dir, perm := range dirs {
+		if err := s.fs.MkdirAll(s.path(dir), perm); err != nil {
+			return err
+		}
+		if err := s.fs.Chmod(s.path(dir), perm); err != nil {
+			return err
+		}
+	}
+	// openssl ca refuses to run without an index file.
+	if exists, err := afero.Exists(s.fs, s.path(CAStoreIndexFile)); err != nil || exists {
+		return err
+	}
+	return WriteOpenSSLIndex(s.fs, s.path(CAStoreIndexFile), nil, false)
+}
+
+// SerialSource returns a SerialSource backed by the store's serial file.
+func (s *FileCAStore) SerialSource() *FileSerialSource {
+	return NewFileSerialSource(s.fs, s.path(CAStoreSerialFile))
+}
+
+// CRLNumberSource returns a SerialSource backed by the store's crlnumber file.
+func (s *FileCAStore) CRLNumberSource() *FileSerialSource {
+	return NewFileSerialSource(s.fs, s.path(CAStoreCRLNumberFile))
+}
+
+// writeNew writes a file which must not already exist with different contents.
+func (s *FileCAStore) writeNew(path string, data []byte, perm os.FileMode) error {
+	existing, err := afero.ReadFile(s.fs, path)
+	if err == nil {
+		if string(existing) == string(data) {
+			return nil
+		}
+		return &ErrStoreEntryExists{Path: path}
+	} else if !errors.Is(err, os.ErrNotExist) {
+		return err
+	}
+	return writeFileAtomic(s.fs, path, data, perm)
+}
+
+func (s *FileCAStore) encodeKey(key crypto.Signer) ([]byte, error) {
+	if s.Passphrase == nil {
+		return EncodeKeys(key)
+	}
+	passphrase, err := s.Passphrase()
+	if err != nil {
+		return nil, err
+	}
+	return EncodeEncryptedKeys(passphrase, s.KeyEncryption, key)
+}
+
+func (s *FileCAStore) readKey(path string) (crypto.Signer, error) {
+	contents, err := afero.ReadFile(s.fs, path)
+	if err != nil {
+		return nil, err
+	}
+	keys, err := LoadEncryptedPrivateKeysFromPem(contents, s.Passphrase)
+	if err != nil {
+		return nil, err
+	}
+	if len(keys) != 1 {
+		return nil, fmt.Errorf("expected 1 private key in %s, found %d", path, len(keys))
+	}
+	signer, ok := keys[0].(crypto.Signer)
+	if !ok {
+		return nil, &ErrUnknownPrivateKey{}
+	}
+	return signer, nil
+}
+
+func (s *FileCAStore) readCertificate(path string) (*x509.Certificate, error) {
+	contents, err := afero.ReadFile(s.fs, path)
+	if err != nil {
+		return nil, err
+	}
+	certs, err := LoadCertificatesFromPem(contents)
+	if err != nil {
+		return nil, err
+	}
+	if len(certs) == 0 {
+		return nil, ErrCouldNotParsePemCertificateBytes
+	}
+	return certs[0], nil
+}
+
+// SaveCA writes the CA certificate and key. An existing CA is never replaced.
+func (s *FileCAStore) SaveCA(certificate *x509.Certificate, key crypto.Signer) error {
+	if !publicKeysEqual(key.Public(), certificate.PublicKey) {
+		return errors.New("CA key does not match the CA certificate")
+	}
+	keyPEM, err := s.encodeKey(key)
+	if err != nil {
+		return err
+	}
+	certPEM, err := EncodeCertificates(certificate)
+	if err != nil {
+		return err
+	}
+	if err := s.writeNew(s.path(CAStorePrivateDir, CAStoreKeyFile), keyPEM, caStorePrivateFileMode); err != nil {
+		return err
+	}
+	return s.writeNew(s.path(CAStoreCertificateFile), certPEM, caStorePublicFileMode)
+}
+
+// LoadCA returns the CA certificate and key as a CertificateAuthority.
+func (s *FileCAStore) LoadCA() (*CertificateAuthority, error) {
+	certificate, err := s.readCertificate(s.path(CAStoreCertificateFile))
+	if err != nil {
+		return nil, err
+	}
+	key, err := s.readKey(s.path(CAStorePrivateDir, CAStoreKeyFile))
+	if err != nil {
+		return nil, err
+	}
+	return &CertificateAuthority{Certificate: certificate, Key: key}, nil
+}
+
+// SaveKey writes the private key for name.
+func (s *FileCAStore) SaveKey(name string, key crypto.Signer) error {
+	keyPEM, err := s.encodeKey(key)
+	if err != nil {
+		return err
+	}
+	keyPath, err := s.entryPath(CAStorePrivateDir, name, caStoreKeyExt)
+	if err != nil {
+		return err
+	}
+	return s.writeNew(keyPath, keyPEM, caStorePrivateFileMode)
+}
+
+// LoadKey reads the private key for name.
+func (s *FileCAStore) LoadKey(name string) (crypto.Signer, error) {
+	keyPath, err := s.entryPath(CAStorePrivateDir, name, caStoreKeyExt)
+	if err != nil {
+		return nil, err
+	}
+	return s.readKey(keyPath)
+}
+
+// SaveRequest writes a pending certificate request under a name derived from its common name
+// and returns the name.
+func (s *FileCAStore) SaveRequest(csr *x509.CertificateRequest) (string, error) {
+	name := CommonNameToFileName(csr.Subject.CommonName)
+	if name == "" {
+		return "", errors.New("certificate request has no common name")
+	}
+	csrPEM, err := EncodeRequest(csr)
+	if err != nil {
+		return "", err
+	}
+	csrPath, err := s.entryPath(CAStoreRequestsDir, name, caStoreRequestExt)
+	if err != nil {
+		return "", err
+	}
+	return name, s.writeNew(csrPath, csrPEM, caStorePublicFileMode)
+}
+
+// LoadRequest reads the pending certificate request for name.
+func (s *FileCAStore) LoadRequest(name string) (*x509.CertificateRequest, error) {
+	csrPath, err := s.entryPath(CAStoreRequestsDir, name, caStoreRequestExt)
+	if err != nil {
+		return nil, err
+	}
+	contents, err := afero.ReadFile(s.fs, csrPath)
+	if err != nil {
+		return nil, err
+	}
+	csrs, err := LoadRequestsFromPem(contents)
+	if err != nil {
+		return nil, err
+	}
+	if len(csrs) == 0 {
+		return nil, ErrCouldNotParsePemCertificateSigningRequestBytes
+	}
+	return csrs[0], nil
+}
+
+// SignRequest signs the pending request for name with the store's CA and records the
+// issued certificate. The serial number is taken from the store's serial file unless
+// parameters supply one.
+func (s *FileCAStore) SignRequest(name string, parameters SigningParameters) (*x509.Certificate, error) {
+	csr, err := s.LoadRequest(name)
+	if err != nil {
+		return nil, err
+	}
+	authority, err := s.LoadCA()
+	if err != nil {
+		return nil, err
+	}
+	if parameters.SerialNumber == nil && parameters.SerialSource == nil {
+		parameters.SerialSource = s.SerialSource()
+	}
+	certificate, err := authority.Sign(csr, parameters)
+	if err != nil {
+		return nil, err
+	}
+	if err := s.SaveIssued(certificate); err != nil {
+		return nil, err
+	}
+	return certificate, nil
+}
+
+// SaveIssued records a certificate issued by the store's CA, writing it to issued/ and
+// certs_by_serial/ and adding it to the index.
+func (s *FileCAStore) SaveIssued(certificate *x509.Certificate) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	name := caStoreName(certificate.Subject.CommonName, certificate.SerialNumber)
+	certPEM, err := EncodeCertificates(certificate)
+	if err != nil {
+		return err
+	}
+	newEntry, err := NewOpenSSLIndexEntry(certificate)
+	if err != nil {
+		return err
+	}
+
+	index, err := s.readIndex()
+	if err != nil {
+		return err
+	}
+	serial := FormatSerialHex(certificate.SerialNumber)
+	if s.findEntry(index, certificate.SerialNumber) != nil {
+		return &ErrStoreEntryExists{Path: s.path(CAStoreCertsBySerialDir, serial+caStoreCertsBySerialExt)}
+	}
+
+	issuedPath, err := s.entryPath(CAStoreIssuedDir, name, caStoreCertificateExt)
+	if err != nil {
+		return err
+	}
+	if err := s.writeNew(issuedPath, certPEM, caStorePublicFileMode); err != nil {
+		return err
+	}
+	if err := s.writeNew(s.path(CAStoreCertsBySerialDir, serial+caStoreCertsBySerialExt), certPEM, caStorePublicFileMode); err != nil {
+		return err
+	}
+
+	return s.writeIndex(append(index, newEntry))
+}
+
+// LoadIssued reads the issued certificate for name.
+func (s *FileCAStore) LoadIssued(name string) (*x509.Certificate, error) {
+	issuedPath, err := s.entryPath(CAStoreIssuedDir, name, caStoreCertificateExt)
+	if err != nil {
+		return nil, err
+	}
+	return s.readCertificate(issuedPath)
+}
+
+// CertificateBySerial reads an issued certificate, revoked or not, by serial number.
+func (s *FileCAStore) CertificateBySerial(serial *big.Int) (*x509.Certificate, error) {
+	certificate, err := s.readCertificate(s.path(CAStoreCertsBySerialDir, FormatSerialHex(serial)+caStoreCertsBySerialExt))
+	if errors.Is(err, os.ErrNotExist) {
+		return nil, ErrCertificateNotFound
+	}
+	return certificate, err
+}
+
+// Revoke marks the certificate revoked and moves its certificate, key and request under
+// revoked/ so the name can be reused. Revoking a revoked certificate does nothing.
+func (s *FileCAStore) Revoke(serial *big.Int, reason RevocationReason, revokedAt time.Time) error {
+	return s.RevokeWithInvalidityDate(serial, reason, revokedAt, time.Time{})
+}
+
+// RevokeWithInvalidityDate is Revoke recording when the key is known or suspected to have
+// been compromised. Like OpenSSL, the date is only kept for the keyCompromise and
+// CACompromise reasons.
+func (s *FileCAStore) RevokeWithInvalidityDate(serial *big.Int, reason RevocationReason, revokedAt time.Time, invalidityDate time.Time) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	index, err := s.readIndex()
+	if err != nil {
+		return err
+	}
+	entry := s.findEntry(index, serial)
+	if entry == nil {
+		return ErrCertificateNotFound
+	}
+	if entry.Status == OpenSSLIndexRevoked {
+		return nil
+	}
+
+	name := s.entryName(*entry)
+	hexSerial := FormatSerialHex(serial)
+	moves := []struct{ from, to string }{
+		{s.path(CAStoreIssuedDir, name+caStoreCertificateExt), s.path(CAStoreRevokedCertsDir, hexSerial+caStoreCertificateExt)},
+		{s.path(CAStorePrivateDir, name+caStoreKeyExt), s.path(CAStoreRevokedKeysDir, hexSerial+caStoreKeyExt)},
+		{s.path(CAStoreRequestsDir, name+caStoreRequestExt), s.path(CAStoreRevokedRequestsDir, hexSerial+caStoreRequestExt)},
+	}
+	for _, move := range moves {
+		if err := s.fs.Rename(move.from, move.to); err != nil && !errors.Is(err, os.ErrNotExist) {
+			return err
+		}
+	}
+
+	entry.Revoke(revokedAt, reason, invalidityDate)
+	return s.writeIndex(index)
+}
+
+// UpdateExpired marks certificates which have passed their notAfter as expired, as
+// "openssl ca -updatedb" does, and returns how many were changed.
+func (s *FileCAStore) UpdateExpired(now time.Time) (int, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	index, err := s.readIndex()
+	if err != nil {
+		return 0, err
+	}
+	updated := UpdateExpiredOpenSSLIndex(index, now)
+	if updated == 0 {
+		return 0, nil
+	}
+	return updated, s.writeIndex(index)
+}
+
+// Certificates returns the index of issued certificates ordered by serial number.
+func (s *FileCAStore) Certificates() ([]StoredCertificate, error) {
+	s.mu.Lock()
+	index, err := s.readIndex()
+	s.mu.Unlock()
+	if err != nil {
+		return nil, err
+	}
+
+	certificates := make([]StoredCertificate, 0, len(index))
+	for _, entry := range index {
+		certificate := StoredCertificate{
+			SerialNumber: entry.SerialNumber,
+			Name:         s.entryName(entry),
+			Subject:      entry.Subject,
+			NotAfter:     entry.Expiry,
+			Expired:      entry.Status == OpenSSLIndexExpired,
+		}
+		if entry.Status == OpenSSLIndexRevoked {
+			certificate.Revoked = true
+			certificate.RevokedAt = entry.RevokedAt
+			certificate.Reason = entry.Reason
+			certificate.InvalidityDate = entry.InvalidityDate
+		}
+		certificates = append(certificates, certificate)
+	}
+	sort.Slice(certificates, func(i, j int) bool {
+		return certificates[i].SerialNumber.Cmp(certificates[j].SerialNumber) < 0
+	})
+	return certificates, nil
+}
+
+// RevokedCertificates returns the revoked certificates for CRL generation.
+func (s *FileCAStore) RevokedCertificates() ([]RevokedCertificate, error) {
+	s.mu.Lock()
+	index, err := s.readIndex()
+	s.mu.Unlock()
+	if err != nil {
+		return nil, err
+	}
+	return RevokedCertificatesFromOpenSSLIndex(index), nil
+}
+
+// OCSPStatus implements OCSPStatusSource. Only the index is read, so the cost of a lookup
+// does not depend on the certificate files in the store.
+func (s *FileCAStore) OCSPStatus(serial *big.Int) (OCSPStatus, error) {
+	s.mu.Lock()
+	index, err := s.readIndex()
+	s.mu.Unlock()
+	if err != nil {
+		return OCSPStatus{}, err
+	}
+	entry := s.findEntry(index, serial)
+	switch {
+	case entry == nil:
+		return OCSPStatus{Status: CertificateStatusUnknown}, nil
+	case entry.Status == OpenSSLIndexRevoked:
+		return OCSPStatus{
+			Status:    CertificateStatusRevoked,
+			RevokedAt: entry.RevokedAt,
+			Reason:    entry.Reason,
+		}, nil
+	default:
+		return OCSPStatus{Status: CertificateStatusGood}, nil
+	}
+}
+
+// GenerateCRL creates a CRL of the revoked certificates signed by the store's CA, numbered
+// from the crlnumber file, and writes it to crl.pem.
+func (s *FileCAStore) GenerateCRL(parameters CRLParameters) (*x509.RevocationList, error) {
+	authority, err := s.LoadCA()
+	if err != nil {
+		return nil, err
+	}
+	revoked, err := s.RevokedCertificates()
+	if err != nil {
+		return nil, err
+	}
+	if parameters.Number == nil && parameters.NumberSource == nil {
+		parameters.NumberSource = s.CRLNumberSource()
+	}
+	crl, err := CreateCRL(revoked, authority.Certificate, authority.Key, parameters)
+	if err != nil {
+		return nil, err
+	}
+	crlPEM, err := EncodeCRLs(crl)
+	if err != nil {
+		return nil, err
+	}
+	return crl, writeFileAtomic(s.fs, s.path(CAStoreCRLFile), crlPEM, caStorePublicFileMode)
+}
+
+func (s *FileCAStore) readIndex() ([]OpenSSLIndexEntry, error) {
+	return ReadOpenSSLIndex(s.fs, s.path(CAStoreIndexFile))
+}
+
+func (s *FileCAStore) writeIndex(index []OpenSSLIndexEntry) error {
+	return WriteOpenSSLIndex(s.fs, s.path(CAStoreIndexFile), index, false)
+}
+
+func (s *FileCAStore) findEntry(index []OpenSSLIndexEntry, serial *big.Int) *OpenSSLIndexEntry {
+	for i := range index {
+		if index[i].SerialNumber.Cmp(serial) == 0 {
+			return &index[i]
+		}
+	}
+	return nil
+}
+
+// entryName returns the name an index entry's files are stored under. The certificate in
+// certs_by_serial is preferred, as the index escapes non-ASCII common names.
+func (s *FileCAStore) entryName(entry OpenSSLIndexEntry) string {
+	contents, err := afero.ReadFile(s.fs, s.path(CAStoreCertsBySerialDir, FormatSerialHex(entry.SerialNumber)+caStoreCertsBySerialExt))
+	if err == nil {
+		if certificates, err := LoadCertificatesFromPem(contents); err == nil && len(certificates) == 1 {
+			return caStoreName(certificates[0].Subject.CommonName, entry.SerialNumber)
+		}
+	}
+	return caStoreName(openSSLOneLineCommonName(entry.Subject), entry.SerialNumber)
+}
+
+// caStoreName derives a store name from a common name, falling back to the serial number.
+// The result is always a valid store name: serial 0xCA is zero padded to avoid the CA key.
+func caStoreName(commonName string, serial *big.Int) string {
+	for _, name := range []string{CommonNameToFileName(commonName), FormatSerialHex(serial)} {
+		if validStoreName(name) {
+			return name
+		}
+	}
+	return "00" + FormatSerialHex(serial)
+}
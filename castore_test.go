@@ -0,0 +1,304 @@
+package certutils
+
+import (
+	"crypto/x509"
+	"crypto/x509/pkix"
+	"errors"
+	"math/big"
+	"os"
+	"strings"
+	"time"
+
+	"github.com/spf13/afero"
+	. "gopkg.in/check.v1"
+)
+
+type FileCAStoreSuite struct {
+}
+
+var _ = Suite(&FileCAStoreSuite{})
+
+// openCountingFs counts the files opened through it.
+type openCountingFs struct {
+	afero.Fs
+	opened []string
+}
+
+func (fs *openCountingFs) Open(name string) (afero.File, error) {
+	fs.opened = append(fs.opened, name)
+	return fs.Fs.Open(name)
+}
+
+func (fs *openCountingFs) OpenFile(name string, flag int, perm os.FileMode) (afero.File, error) {
+	fs.opened = append(fs.opened, name)
+	return fs.Fs.OpenFile(name, flag, perm)
+}
+
+// newTestCAStore returns an initialised store on an in-memory filesystem holding a new CA.
+func newTestCAStore(c *C, passphrase PassphraseFunc) (afero.Fs, *FileCAStore) {
+	fs := afero.NewMemMapFs()
+	store := NewFileCAStore(fs, "/pki")
+	store.Passphrase = passphrase
+	store.KeyEncryption = KeyEncryptionParameters{Iterations: 1000}
+	c.Assert(store.Init(), IsNil)
+
+	key, err := GeneratePrivateKey(PrivateKeyTypeEcp256)
+	c.Assert(err, IsNil)
+	ca, err := CreateRootCA(pkix.Name{CommonName: "Store CA"}, key, CAParameters{})
+	c.Assert(err, IsNil)
+	c.Assert(store.SaveCA(ca, key), IsNil)
+	return fs, store
+}
+
+// requestForStore creates a key and request for the common name and saves both.
+func requestForStore(c *C, store *FileCAStore, commonName string) string {
+	key, err := GeneratePrivateKey(PrivateKeyTypeEcp256)
+	c.Assert(err, IsNil)
+	csr, err := GenerateCSR(pkix.Name{CommonName: commonName}, CSRParameters{
+		KeyUsage:    x509.KeyUsageDigitalSignature,
+		ExtKeyUsage: []x509.ExtKeyUsage{x509.ExtKeyUsageServerAuth},
+	}, key, commonName)
+	c.Assert(err, IsNil)
+	name, err := store.SaveRequest(csr)
+	c.Assert(err, IsNil)
+	c.Assert(store.SaveKey(name, key), IsNil)
+	return name
+}
+
+func (s *FileCAStoreSuite) TestLayoutAndPermissions(c *C) {
+	fs, store := newTestCAStore(c, StaticPassphrase([]byte("store passphrase")))
+
+	info, err := fs.Stat("/pki/private")
+	c.Assert(err, IsNil)
+	c.Check(info.Mode().Perm(), Equals, caStorePrivateDirFileMode)
+
+	info, err = fs.Stat("/pki/private/ca.key")
+	c.Assert(err, IsNil)
+	c.Check(info.Mode().Perm(), Equals, caStorePrivateFileMode)
+	keyPEM, err := afero.ReadFile(fs, "/pki/private/ca.key")
+	c.Assert(err, IsNil)
+	c.Check(strings.Contains(string(keyPEM), EncryptedPrivateKeyBlockType), Equals, true)
+
+	authority, err := store.LoadCA()
+	c.Assert(err, IsNil)
+	c.Check(authority.Certificate.Subject.CommonName, Equals, "Store CA")
+	c.Check(publicKeysEqual(authority.Key.Public(), authority.Certificate.PublicKey), Equals, true)
+
+	store.Passphrase = nil
+	_, err = store.LoadCA()
+	c.Check(errors.Is(err, ErrPassphraseRequired), Equals, true)
+
+	// The CA is never replaced.
+	other, otherKey := newTestCA(c, PrivateKeyTypeEcp256)
+	c.Check(store.SaveCA(other, otherKey), FitsTypeOf, &ErrStoreEntryExists{})
+}
+
+func (s *FileCAStoreSuite) TestSignAndRevoke(c *C) {
+	fs, store := newTestCAStore(c, nil)
+	name := requestForStore(c, store, "www.example.com")
+	c.Check(name, Equals, "www_example_com")
+
+	certificate, err := store.SignRequest(name, SigningParameters{
+		NotBefore: CertificateNotBefore(),
+		NotAfter:  CertificateNotAfter(0),
+	})
+	c.Assert(err, IsNil)
+	c.Check(certificate.SerialNumber.Int64(), Equals, int64(1))
+
+	issued, err := store.LoadIssued(name)
+	c.Assert(err, IsNil)
+	c.Check(issued.Equal(certificate), Equals, true)
+	bySerial, err := store.CertificateBySerial(big.NewInt(1))
+	c.Assert(err, IsNil)
+	c.Check(bySerial.Equal(certificate), Equals, true)
+	serialFile, err := afero.ReadFile(fs, "/pki/serial")
+	c.Assert(err, IsNil)
+	c.Check(string(serialFile), Equals, "02\n")
+
+	status, err := store.OCSPStatus(certificate.SerialNumber)
+	c.Assert(err, IsNil)
+	c.Check(status.Status, Equals, CertificateStatusGood)
+
+	// Recording the same serial twice is refused.
+	c.Check(store.SaveIssued(certificate), FitsTypeOf, &ErrStoreEntryExists{})
+
+	revokedAt := time.Now().UTC().Truncate(time.Second)
+	c.Assert(store.Revoke(certificate.SerialNumber, RevocationReasonSuperseded, revokedAt), IsNil)
+	for _, path := range []string{"/pki/issued/www_example_com.crt", "/pki/private/www_example_com.key", "/pki/reqs/www_example_com.req"} {
+		exists, err := afero.Exists(fs, path)
+		c.Assert(err, IsNil)
+		c.Check(exists, Equals, false, Commentf(path))
+	}
+	for _, path := range []string{"/pki/revoked/certs_by_serial/01.crt", "/pki/revoked/private_by_serial/01.key", "/pki/revoked/reqs_by_serial/01.req"} {
+		exists, err := afero.Exists(fs, path)
+		c.Assert(err, IsNil)
+		c.Check(exists, Equals, true, Commentf(path))
+	}
+
+	status, err = store.OCSPStatus(certificate.SerialNumber)
+	c.Assert(err, IsNil)
+	c.Check(status, DeepEquals, OCSPStatus{Status: CertificateStatusRevoked, RevokedAt: revokedAt, Reason: RevocationReasonSuperseded})
+	status, err = store.OCSPStatus(big.NewInt(99))
+	c.Assert(err, IsNil)
+	c.Check(status.Status, Equals, CertificateStatusUnknown)
+
+	// A lookup reads the index and no certificate files.
+	counting := &openCountingFs{Fs: fs}
+	countingStore := NewFileCAStore(counting, "/pki")
+	status, err = countingStore.OCSPStatus(certificate.SerialNumber)
+	c.Assert(err, IsNil)
+	c.Check(status.Status, Equals, CertificateStatusRevoked)
+	c.Check(counting.opened, DeepEquals, []string{"/pki/index.txt"})
+
+	// The name can be reused once revoked.
+	requestForStore(c, store, "www.example.com")
+	renewed, err := store.SignRequest(name, SigningParameters{
+		NotBefore: CertificateNotBefore(),
+		NotAfter:  CertificateNotAfter(0),
+	})
+	c.Assert(err, IsNil)
+	c.Check(renewed.SerialNumber.Int64(), Equals, int64(2))
+
+	crl, err := store.GenerateCRL(CRLParameters{})
+	c.Assert(err, IsNil)
+	c.Check(crl.Number.Int64(), Equals, int64(1))
+	c.Assert(crl.RevokedCertificateEntries, HasLen, 1)
+	c.Check(crl.RevokedCertificateEntries[0].SerialNumber.Int64(), Equals, int64(1))
+	exists, err := afero.Exists(fs, "/pki/crl.pem")
+	c.Assert(err, IsNil)
+	c.Check(exists, Equals, true)
+
+	certificates, err := store.Certificates()
+	c.Assert(err, IsNil)
+	c.Assert(certificates, HasLen, 2)
+	c.Check(certificates[0].Revoked, Equals, true)
+	c.Check(certificates[0].Name, Equals, name)
+	c.Check(certificates[1].Revoked, Equals, false)
+	c.Check(certificates[1].Name, Equals, name)
+
+	// The database is an OpenSSL index.txt.
+	index, err := afero.ReadFile(fs, "/pki/index.txt")
+	c.Assert(err, IsNil)
+	lines := strings.Split(strings.TrimSuffix(string(index), "\n"), "\n")
+	c.Assert(lines, HasLen, 2)
+	c.Check(lines[0], Equals, strings.Join([]string{
+		"R", formatOpenSSLTime(certificate.NotAfter), formatOpenSSLTime(revokedAt) + ",superseded", "01", "unknown", "/CN=www.example.com",
+	}, "\t"))
+	c.Check(strings.HasPrefix(lines[1], "V\t"), Equals, true)
+	exists, err = afero.Exists(fs, "/pki/index.txt.attr")
+	c.Assert(err, IsNil)
+	c.Check(exists, Equals, true)
+
+	updated, err := store.UpdateExpired(renewed.NotAfter)
+	c.Assert(err, IsNil)
+	c.Check(updated, Equals, 1)
+	status, err = store.OCSPStatus(renewed.SerialNumber)
+	c.Assert(err, IsNil)
+	c.Check(status.Status, Equals, CertificateStatusGood)
+}
+
+func (s *FileCAStoreSuite) TestNamesStayInsideStore(c *C) {
+	fs, store := newTestCAStore(c, nil)
+	key, err := GeneratePrivateKey(PrivateKeyTypeEcp256)
+	c.Assert(err, IsNil)
+	csr, err := GenerateCSR(pkix.Name{CommonName: `../../evil/x\y`}, CSRParameters{}, key)
+	c.Assert(err, IsNil)
+
+	name, err := store.SaveRequest(csr)
+	c.Assert(err, IsNil)
+	c.Check(name, Equals, "______evil_x_y")
+	_, err = fs.Stat("/pki/reqs/______evil_x_y.req")
+	c.Check(err, IsNil)
+	c.Assert(store.SaveKey(name, key), IsNil)
+
+	certificate, err := store.SignRequest(name, SigningParameters{
+		NotBefore: CertificateNotBefore(),
+		NotAfter:  CertificateNotAfter(0),
+	})
+	c.Assert(err, IsNil)
+	_, err = store.LoadIssued(name)
+	c.Check(err, IsNil)
+	c.Check(store.Revoke(certificate.SerialNumber, RevocationReasonUnspecified, time.Time{}), IsNil)
+	_, err = fs.Stat("/evil")
+	c.Check(os.IsNotExist(err), Equals, true)
+
+	// Names given by the caller must also be a single path element.
+	for _, invalid := range []string{"", "../ca", `..\ca`, "issued/x"} {
+		c.Check(store.SaveKey(invalid, key), Equals, ErrInvalidStoreName)
+		_, err = store.LoadKey(invalid)
+		c.Check(err, Equals, ErrInvalidStoreName)
+		_, err = store.LoadRequest(invalid)
+		c.Check(err, Equals, ErrInvalidStoreName)
+		_, err = store.LoadIssued(invalid)
+		c.Check(err, Equals, ErrInvalidStoreName)
+	}
+}
+
+func (s *FileCAStoreSuite) TestCANameIsReserved(c *C) {
+	fs, store := newTestCAStore(c, nil)
+	key, err := GeneratePrivateKey(PrivateKeyTypeEcp256)
+	c.Assert(err, IsNil)
+	csr, err := GenerateCSR(pkix.Name{CommonName: "ca"}, CSRParameters{}, key)
+	c.Assert(err, IsNil)
+
+	_, err = store.SaveRequest(csr)
+	c.Check(err, Equals, ErrInvalidStoreName)
+	c.Check(store.SaveKey("ca", key), Equals, ErrInvalidStoreName)
+	_, err = store.LoadKey("CA")
+	c.Check(err, Equals, ErrInvalidStoreName)
+
+	// A certificate for "ca" issued outside the store, with a serial which also spells the
+	// name, is stored and revoked without touching the CA key.
+	authority, err := store.LoadCA()
+	c.Assert(err, IsNil)
+	certificate, err := authority.Sign(csr, SigningParameters{
+		SerialNumber: big.NewInt(0xCA),
+		NotBefore:    CertificateNotBefore(),
+		NotAfter:     CertificateNotAfter(0),
+	})
+	c.Assert(err, IsNil)
+	c.Assert(store.SaveIssued(certificate), IsNil)
+	stored, err := store.Certificates()
+	c.Assert(err, IsNil)
+	c.Assert(stored, HasLen, 1)
+	c.Check(stored[0].Name, Equals, "00CA")
+
+	c.Assert(store.Revoke(certificate.SerialNumber, RevocationReasonKeyCompromise, time.Time{}), IsNil)
+	_, err = fs.Stat("/pki/revoked/certs_by_serial/CA.crt")
+	c.Check(err, IsNil)
+	_, err = store.LoadCA()
+	c.Check(err, IsNil)
+}
+
+func (s *FileCAStoreSuite) TestReadsOpenSSLDatabase(c *C) {
+	fs, store := newTestCAStore(c, nil)
+	authority, err := store.LoadCA()
+	c.Assert(err, IsNil)
+
+	// A certificate issued and revoked by openssl ca only has an index entry and its
+	// certificate in new_certs_dir.
+	certificate := RequestTLSCertificate(authority.Certificate, authority.Key, SigningParameters{
+		SerialNumber: big.NewInt(0x1F),
+		NotBefore:    CertificateNotBefore(),
+		NotAfter:     CertificateNotAfter(0),
+	}, PrivateKeyTypeEcp256, "openssl.example.com")
+	c.Assert(certificate, NotNil)
+	certPEM, err := EncodeCertificates(certificate.Leaf)
+	c.Assert(err, IsNil)
+	c.Assert(afero.WriteFile(fs, "/pki/certs_by_serial/1F.pem", certPEM, 0644), IsNil)
+	line := "R\t" + formatOpenSSLTime(certificate.Leaf.NotAfter) + "\t250601120000Z,keyTime,20250530000000Z\t1F\tunknown\t/CN=openssl.example.com\n"
+	c.Assert(afero.WriteFile(fs, "/pki/index.txt", []byte(line), 0644), IsNil)
+
+	certificates, err := store.Certificates()
+	c.Assert(err, IsNil)
+	c.Assert(certificates, HasLen, 1)
+	c.Check(certificates[0].Name, Equals, "openssl_example_com")
+	c.Check(certificates[0].Reason, Equals, RevocationReasonKeyCompromise)
+	c.Check(certificates[0].InvalidityDate, Equals, time.Date(2025, 5, 30, 0, 0, 0, 0, time.UTC))
+
+	crl, err := store.GenerateCRL(CRLParameters{})
+	c.Assert(err, IsNil)
+	c.Assert(crl.RevokedCertificateEntries, HasLen, 1)
+	c.Check(crl.RevokedCertificateEntries[0].SerialNumber.Int64(), Equals, int64(0x1F))
+	c.Check(crl.RevokedCertificateEntries[0].ReasonCode, Equals, int(RevocationReasonKeyCompromise))
+}
@@ -1,13 +1,14 @@
 package certutils
 
 import (
+	"context"
+	"crypto"
 	"crypto/rand"
 	"crypto/tls"
 	"crypto/x509"
 	"crypto/x509/pkix"
 	"encoding/asn1"
 	"errors"
-	extasn1 "github.com/paulgriffiths/pki/asn1"
 	"github.com/paulgriffiths/pki/extensions"
 	"math/big"
 	"net"
@@ -59,20 +60,30 @@ func CACertificateNotAfter(duration time.Duration) time.Time {
 
 // GenerateCSR generates a certificate for the given hosts.
 // Parameters are common template parameters, key is the private key associated with the certificate.
-func GenerateCSR(subject pkix.Name, parameters CSRParameters, key interface{}, hosts ...string) (*x509.CertificateRequest, error) {
+// Any crypto.Signer may be used, so the key does not need to be held in process memory.
+func GenerateCSR(subject pkix.Name, parameters CSRParameters, key crypto.Signer, hosts ...string) (*x509.CertificateRequest, error) {
 	// Put the correct
+	maxPathLen := parameters.MaxPathLen
+	if maxPathLen < 0 || (maxPathLen == 0 && !parameters.MaxPathLenZero) {
+		// -1 omits the path length constraint from the extension.
+		maxPathLen = -1
+	}
 	basicConstraints, _ := extensions.BasicConstraints{
 		Critical:   true,
 		IsCA:       parameters.IsCA,
-		MaxPathLen: parameters.MaxPathLen,
+		MaxPathLen: maxPathLen,
 	}.Marshal()
 
-	keyUsage, _ := extensions.KeyUsage{
-		Critical: true,
-		Value:    parameters.KeyUsage,
-	}.Marshal()
+	extraExtensions := []pkix.Extension{basicConstraints}
 
-	extraExtensions := []pkix.Extension{basicConstraints, keyUsage}
+	// An empty key usage cannot be encoded, so the extension is omitted.
+	if parameters.KeyUsage != 0 {
+		keyUsage, _ := extensions.KeyUsage{
+			Critical: true,
+			Value:    parameters.KeyUsage,
+		}.Marshal()
+		extraExtensions = append(extraExtensions, keyUsage)
+	}
 
 	if len(parameters.ExtKeyUsage) > 0 {
 		oids := make([]asn1.ObjectIdentifier, 0, len(parameters.ExtKeyUsage))
@@ -125,7 +136,7 @@ func GenerateCSR(subject pkix.Name, parameters CSRParameters, key interface{}, h
 	if len(emails) > 0 {
 		csr.EmailAddresses = append(csr.EmailAddresses, emails...)
 	}
-	csr.PublicKey = PublicKey(key)
+	csr.PublicKey = key.Public()
 
 	signedCSRBytes, err := x509.CreateCertificateRequest(rand.Reader, &csr, key)
 	if err != nil {
@@ -145,7 +156,10 @@ type CSRParameters struct {
 	KeyUsage    x509.KeyUsage
 	ExtKeyUsage []x509.ExtKeyUsage
 	IsCA        bool
-	MaxPathLen  int
+	// MaxPathLen and MaxPathLenZero have the same meaning as in x509.Certificate: a MaxPathLen
+	// of 0 is treated as unset unless MaxPathLenZero is true.
+	MaxPathLen     int
+	MaxPathLenZero bool
 	// This parameter is used by some CAs (i.e. ADCS) to determine which template
 	// to apply. So we should support it.
 	CertificateTemplate string
@@ -153,69 +167,108 @@ type CSRParameters struct {
 
 // SigningParameters sets parameters determined by the authority signing
 type SigningParameters struct {
-	SerialNumber int64
+	// SerialNumber is the serial number to issue with. If nil, one is taken from SerialSource.
+	SerialNumber *big.Int
+	// SerialSource supplies serial numbers when SerialNumber is nil. If it is also nil, a
+	// RandomSerialSource is used.
+	SerialSource SerialSource
 	NotBefore    time.Time
 	NotAfter     time.Time
+	// SignatureAlgorithm overrides the algorithm the authority signs with. If unset, the
+	// default for the authority's key is used (see DefaultSignatureAlgorithm).
+	SignatureAlgorithm x509.SignatureAlgorithm
+	// Policy filters the extensions requested by the CSR. If unset, DefaultIssuancePolicy is
+	// used, except when self-signing where the requester holds the authority key and
+	// PermissiveIssuancePolicy is used.
+	Policy *IssuancePolicy
+	// Endpoints are the revocation and issuer URLs stamped on the issued certificate.
+	Endpoints AuthorityEndpoints
+	// KeyIdentifierMethod selects how the subject key identifier is computed. The authority
+	// key identifier is always taken from the authority.
+	KeyIdentifierMethod KeyIdentifierMethod
+	// SkipRequestVerification disables checking the CSR signature and public key before
+	// signing. It should only be set if the request has already been verified.
+	SkipRequestVerification bool
 }
 
 // CsrToCertificateTemplate converts a certificate signing request to a certificate template ready to be signed.
+// Requested extensions are filtered through parameters.Policy, or DefaultIssuancePolicy if it is unset.
 func CsrToCertificateTemplate(csr *x509.CertificateRequest, parameters SigningParameters) *x509.Certificate {
 	certificate := &x509.Certificate{
-		SerialNumber:       big.NewInt(parameters.SerialNumber),
-		SignatureAlgorithm: csr.SignatureAlgorithm,
+		SerialNumber:       parameters.SerialNumber,
+		SignatureAlgorithm: parameters.SignatureAlgorithm,
 		PublicKeyAlgorithm: csr.PublicKeyAlgorithm,
 		PublicKey:          csr.PublicKey,
 		Subject:            csr.Subject,
-		ExtraExtensions:    csr.Extensions,
-		DNSNames:           csr.DNSNames,
-		EmailAddresses:     csr.EmailAddresses,
-		IPAddresses:        csr.IPAddresses,
-		URIs:               csr.URIs,
 		NotBefore:          parameters.NotBefore,
 		NotAfter:           parameters.NotAfter,
-		ExtKeyUsage:        []x509.ExtKeyUsage{},
 	}
 
-	// We need to parse the extensions to regenerate x509.Certificate objects
-	// so signing works properly.
-	for _, ext := range csr.Extensions {
-		if ext.Id.Equal(extasn1.OIDBasicConstraints) {
-			r := extensions.BasicConstraints{}
-			if err := r.Unmarshal(ext); err != nil {
-				continue
-			}
-			certificate.IsCA = r.IsCA
-			certificate.MaxPathLen = r.MaxPathLen
-		} else if ext.Id.Equal(extasn1.OIDKeyUsage) {
-			r := extensions.KeyUsage{}
-			r.Unmarshal(ext)
-			if err := r.Unmarshal(ext); err != nil {
-				continue
-			}
-			certificate.KeyUsage = r.Value
-		} else if ext.Id.Equal(extasn1.OIDExtendedKeyUsage) {
-			r := extensions.ExtendedKeyUsage{}
-			r.Unmarshal(ext)
-			for _, oid := range r.OIDs {
-				usage, found := OIDToExtKeyUsage(oid)
-				if found {
-					certificate.ExtKeyUsage = append(certificate.ExtKeyUsage, usage)
-				}
-			}
-		}
+	policy := parameters.Policy
+	if policy == nil {
+		policy = DefaultIssuancePolicy()
 	}
+	policy.Apply(csr, certificate)
 
 	return certificate
 }
 
-// SignCertificate signs a CSR for use as a TLS server certificate
-func SignCertificate(csr *x509.CertificateRequest, authority *x509.Certificate, authorityKey interface{}, parameters SigningParameters) (*x509.Certificate, error) {
+// SignCertificate signs a CSR for use as a TLS server certificate. authorityKey may be any
+// crypto.Signer, such as a key held in an HSM or KMS.
+func SignCertificate(csr *x509.CertificateRequest, authority *x509.Certificate, authorityKey crypto.Signer, parameters SigningParameters) (*x509.Certificate, error) {
+	if !parameters.SkipRequestVerification {
+		if err := VerifyCertificateRequest(csr); err != nil {
+			return nil, err
+		}
+	}
+
+	if authority == nil && parameters.Policy == nil {
+		parameters.Policy = PermissiveIssuancePolicy()
+	}
+
+	if parameters.SerialNumber == nil {
+		source := parameters.SerialSource
+		if source == nil {
+			source = RandomSerialSource{}
+		}
+		serial, err := source.NextSerial()
+		if err != nil {
+			return nil, err
+		}
+		parameters.SerialNumber = serial
+	}
+
 	certificate := CsrToCertificateTemplate(csr, parameters)
 
+	// The signature algorithm is a property of the issuer's key, not the requester's.
+	signatureAlgorithm, err := SelectSignatureAlgorithm(authorityKey.Public(), parameters.SignatureAlgorithm)
+	if err != nil {
+		return nil, err
+	}
+	certificate.SignatureAlgorithm = signatureAlgorithm
+	if err := parameters.Endpoints.apply(certificate); err != nil {
+		return nil, err
+	}
+
+	subjectKeyID, err := ComputeSubjectKeyID(certificate.PublicKey, parameters.KeyIdentifierMethod)
+	if err != nil {
+		return nil, err
+	}
+	certificate.SubjectKeyId = subjectKeyID
+
 	if authority == nil {
 		authority = certificate
 	}
 
+	certificate.AuthorityKeyId = authority.SubjectKeyId
+	if len(certificate.AuthorityKeyId) == 0 {
+		// Authorities without a subject key identifier are identified by the same method.
+		certificate.AuthorityKeyId, err = ComputeSubjectKeyID(authority.PublicKey, parameters.KeyIdentifierMethod)
+		if err != nil {
+			return nil, err
+		}
+	}
+
 	certificateBytes, err := x509.CreateCertificate(rand.Reader, certificate, authority, certificate.PublicKey, authorityKey)
 	if err != nil {
 		return nil, err
@@ -231,7 +284,7 @@ func SignCertificate(csr *x509.CertificateRequest, authority *x509.Certificate,
 
 // RequestTLSCertificate generates and signs a certificate for the given hostname using defaults derived from the
 // CA certificate. The returns *tls.Certificate contains the private key of the generated certificate.
-func RequestTLSCertificateWithUsages(authority *x509.Certificate, authorityKey interface{},
+func RequestTLSCertificateWithUsages(authority *x509.Certificate, authorityKey crypto.Signer,
 	parameters SigningParameters, keyType PrivateKeyType, usage x509.KeyUsage, extUsage []x509.ExtKeyUsage, isCA bool, hosts ...string) *tls.Certificate {
 	if len(hosts) == 0 {
 		return nil
@@ -244,38 +297,30 @@ func RequestTLSCertificateWithUsages(authority *x509.Certificate, authorityKey i
 	subject.SerialNumber = ""
 	subject.CommonName = hosts[0]
 
-	key, err := GeneratePrivateKey(keyType)
-	if err != nil {
-		return nil
+	// The CSR is generated from the caller's arguments, so it can be trusted.
+	if parameters.Policy == nil {
+		parameters.Policy = PermissiveIssuancePolicy()
+	}
+	issuer := &LocalIssuer{
+		Authority:  &CertificateAuthority{Certificate: authority, Key: authorityKey},
+		Parameters: parameters,
 	}
 
-	// Request the CSR
-	csrParams := CSRParameters{
+	certificate, err := IssueTLSCertificate(context.Background(), issuer, IssuanceProfile{}, keyType, subject, CSRParameters{
 		KeyUsage:    usage,
 		ExtKeyUsage: extUsage,
 		IsCA:        isCA,
-	}
-	csr, err := GenerateCSR(subject, csrParams, key, hosts...)
-	if err != nil {
-		return nil
-	}
-
-	// Sign the CSR
-	certificate, err := SignCertificate(csr, authority, authorityKey, parameters)
+	}, hosts...)
 	if err != nil {
 		return nil
 	}
-	return &tls.Certificate{
-		Certificate: [][]byte{certificate.Raw, authority.Raw},
-		PrivateKey:  key,
-		Leaf:        certificate,
-	}
+	return certificate
 }
 
 // RequestTLSCertificate generates and signs a certificate for the given hostname using defaults derived from the
 // CA certificate. The returns *tls.Certificate contains the private key of the generated certificate. This will be a
 // server certificate suitable for typical host verification.
-func RequestTLSCertificate(authority *x509.Certificate, authorityKey interface{},
+func RequestTLSCertificate(authority *x509.Certificate, authorityKey crypto.Signer,
 	parameters SigningParameters, keyType PrivateKeyType, hosts ...string) *tls.Certificate {
 
 	if len(hosts) == 0 {
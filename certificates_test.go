@@ -0,0 +1,183 @@
+package certutils
+
+import (
+	"crypto"
+	"crypto/ecdsa"
+	"crypto/elliptic"
+	"crypto/rand"
+	"crypto/rsa"
+	"crypto/tls"
+	"crypto/x509"
+	"crypto/x509/pkix"
+	"io"
+	"math/big"
+
+	. "gopkg.in/check.v1"
+)
+
+type CertificateSuite struct {
+}
+
+var _ = Suite(&CertificateSuite{})
+
+// newTestCA creates a self-signed CA certificate for use in tests.
+func newTestCA(c *C, keyType PrivateKeyType) (*x509.Certificate, crypto.Signer) {
+	key, err := GeneratePrivateKey(keyType)
+	c.Assert(err, IsNil)
+
+	csr, err := GenerateCSR(pkix.Name{CommonName: "Test CA " + keyType.String()}, CSRParameters{
+		KeyUsage: x509.KeyUsageCertSign | x509.KeyUsageCRLSign,
+		IsCA:     true,
+	}, key)
+	c.Assert(err, IsNil)
+
+	ca, err := SignCertificate(csr, nil, key, SigningParameters{
+		NotBefore: CertificateNotBefore(),
+		NotAfter:  CACertificateNotAfter(0),
+	})
+	c.Assert(err, IsNil)
+	return ca, key
+}
+
+// newTestIntermediateCA creates a root CA and an intermediate CA issued by it, returning the
+// intermediate, its key and the root.
+func newTestIntermediateCA(c *C) (*x509.Certificate, crypto.Signer, *x509.Certificate) {
+	root, rootKey := newTestCA(c, PrivateKeyTypeEcp256)
+	intermediateKey, err := GeneratePrivateKey(PrivateKeyTypeEcp256)
+	c.Assert(err, IsNil)
+	intermediate, err := CreateIntermediateCA(pkix.Name{CommonName: "Issuing CA"}, intermediateKey, root, rootKey, CAParameters{})
+	c.Assert(err, IsNil)
+	return intermediate, intermediateKey, root
+}
+
+// newTestLeaf issues a certificate for host from the issuer, for server authentication unless
+// other extended key usages are given.
+func newTestLeaf(c *C, issuer *x509.Certificate, issuerKey crypto.Signer, keyType PrivateKeyType, host string,
+	extUsages ...x509.ExtKeyUsage) *tls.Certificate {
+	if len(extUsages) == 0 {
+		extUsages = []x509.ExtKeyUsage{x509.ExtKeyUsageServerAuth}
+	}
+	certificate := RequestTLSCertificateWithUsages(issuer, issuerKey, SigningParameters{
+		NotBefore: CertificateNotBefore(),
+		NotAfter:  CertificateNotAfter(0, issuer),
+	}, keyType, x509.KeyUsageDigitalSignature, extUsages, false, host)
+	c.Assert(certificate, NotNil)
+	return certificate
+}
+
+// opaqueSigner hides the concrete key type in the same way a PKCS#11 or KMS backed
+// crypto.Signer would.
+type opaqueSigner struct {
+	signer crypto.Signer
+}
+
+func (o opaqueSigner) Public() crypto.PublicKey {
+	return o.signer.Public()
+}
+
+func (o opaqueSigner) Sign(rand io.Reader, digest []byte, opts crypto.SignerOpts) ([]byte, error) {
+	return o.signer.Sign(rand, digest, opts)
+}
+
+func (s *CertificateSuite) TestSignWithOpaqueSigner(c *C) {
+	for _, keyType := range []PrivateKeyType{PrivateKeyTypeRsa2048, PrivateKeyTypeEcp384, PrivateKeyTypeEd25519} {
+		key, err := GeneratePrivateKey(keyType)
+		c.Assert(err, IsNil)
+		signer := opaqueSigner{key}
+
+		detected, err := GetPrivateKeyType(signer)
+		c.Assert(err, IsNil)
+		c.Check(detected, Equals, keyType)
+
+		csr, err := GenerateCSR(pkix.Name{CommonName: "Opaque CA"}, CSRParameters{
+			KeyUsage: x509.KeyUsageCertSign | x509.KeyUsageCRLSign,
+			IsCA:     true,
+		}, signer)
+		c.Assert(err, IsNil)
+
+		ca, err := SignCertificate(csr, nil, signer, SigningParameters{
+			NotBefore: CertificateNotBefore(),
+			NotAfter:  CACertificateNotAfter(0),
+		})
+		c.Assert(err, IsNil)
+
+		leaf := RequestTLSCertificate(ca, signer, SigningParameters{
+			NotBefore: CertificateNotBefore(),
+			NotAfter:  CertificateNotAfter(0, ca),
+		}, keyType, "opaque.example.com")
+		c.Assert(leaf, NotNil)
+		c.Assert(leaf.Leaf.CheckSignatureFrom(ca), IsNil)
+	}
+}
+
+func (s *CertificateSuite) TestSignatureAlgorithmFollowsIssuer(c *C) {
+	rsaCA, rsaKey := newTestCA(c, PrivateKeyTypeRsa2048)
+	ecCA, ecKey := newTestCA(c, PrivateKeyTypeEcp384)
+
+	requestKey, err := GeneratePrivateKey(PrivateKeyTypeEcp384)
+	c.Assert(err, IsNil)
+	csr, err := GenerateCSR(pkix.Name{}, CSRParameters{KeyUsage: x509.KeyUsageDigitalSignature}, requestKey, "algo.example.com")
+	c.Assert(err, IsNil)
+	c.Assert(csr.SignatureAlgorithm, Equals, x509.ECDSAWithSHA384)
+
+	parameters := SigningParameters{
+		NotBefore: CertificateNotBefore(),
+		NotAfter:  CertificateNotAfter(0),
+	}
+
+	cert, err := SignCertificate(csr, rsaCA, rsaKey, parameters)
+	c.Assert(err, IsNil)
+	c.Check(cert.SignatureAlgorithm, Equals, x509.SHA256WithRSA)
+
+	cert, err = SignCertificate(csr, ecCA, ecKey, parameters)
+	c.Assert(err, IsNil)
+	c.Check(cert.SignatureAlgorithm, Equals, x509.ECDSAWithSHA384)
+
+	parameters.SignatureAlgorithm = x509.SHA384WithRSAPSS
+	cert, err = SignCertificate(csr, rsaCA, rsaKey, parameters)
+	c.Assert(err, IsNil)
+	c.Check(cert.SignatureAlgorithm, Equals, x509.SHA384WithRSAPSS)
+	c.Check(cert.CheckSignatureFrom(rsaCA), IsNil)
+
+	_, err = SignCertificate(csr, ecCA, ecKey, parameters)
+	c.Assert(err, FitsTypeOf, &ErrIncompatibleSignatureAlgorithm{})
+}
+
+func (s *CertificateSuite) TestRejectTamperedRequest(c *C) {
+	ca, caKey := newTestCA(c, PrivateKeyTypeEcp256)
+
+	key, err := GeneratePrivateKey(PrivateKeyTypeEcp256)
+	c.Assert(err, IsNil)
+	csr, err := GenerateCSR(pkix.Name{}, CSRParameters{KeyUsage: x509.KeyUsageDigitalSignature}, key, "tamper.example.com")
+	c.Assert(err, IsNil)
+
+	// Swap in a different public key without re-signing the request.
+	otherKey, err := GeneratePrivateKey(PrivateKeyTypeEcp256)
+	c.Assert(err, IsNil)
+	csr.PublicKey = otherKey.Public()
+
+	parameters := SigningParameters{
+		NotBefore: CertificateNotBefore(),
+		NotAfter:  CertificateNotAfter(0, ca),
+	}
+	_, err = SignCertificate(csr, ca, caKey, parameters)
+	c.Assert(err, FitsTypeOf, &ErrInvalidRequestSignature{})
+}
+
+func (s *CertificateSuite) TestRejectWeakKeys(c *C) {
+	ca, caKey := newTestCA(c, PrivateKeyTypeEcp256)
+
+	weakKey, err := rsa.GenerateKey(rand.Reader, 1024)
+	c.Assert(err, IsNil)
+	csr, err := GenerateCSR(pkix.Name{}, CSRParameters{KeyUsage: x509.KeyUsageDigitalSignature}, weakKey, "weak.example.com")
+	c.Assert(err, IsNil)
+
+	_, err = SignCertificate(csr, ca, caKey, SigningParameters{
+		NotBefore: CertificateNotBefore(),
+		NotAfter:  CertificateNotAfter(0, ca),
+	})
+	c.Assert(err, FitsTypeOf, &ErrWeakPublicKey{})
+
+	invalidPoint := &ecdsa.PublicKey{Curve: elliptic.P256(), X: big.NewInt(1), Y: big.NewInt(1)}
+	c.Assert(ValidatePublicKey(invalidPoint), FitsTypeOf, &ErrWeakPublicKey{})
+}
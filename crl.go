@@ -0,0 +1,259 @@
+package certutils
+
+import (
+	"crypto"
+	"crypto/rand"
+	"crypto/x509"
+	"crypto/x509/pkix"
+	"encoding/asn1"
+	"errors"
+	"fmt"
+	"math/big"
+	"strings"
+	"time"
+)
+
+// DefaultCRLValidity is the time between thisUpdate and nextUpdate when none is specified.
+const DefaultCRLValidity = 7 * 24 * time.Hour
+
+var ErrCRLNumberRequired = errors.New("a CRL number or CRL number source is required")
+
+var oidExtensionInvalidityDate = asn1.ObjectIdentifier{2, 5, 29, 24}
+
+// RevocationReason is a CRL entry reason code as defined in RFC 5280 section 5.3.1.
+type RevocationReason int
+
+const (
+	RevocationReasonUnspecified          RevocationReason = 0
+	RevocationReasonKeyCompromise        RevocationReason = 1
+	RevocationReasonCACompromise         RevocationReason = 2
+	RevocationReasonAffiliationChanged   RevocationReason = 3
+	RevocationReasonSuperseded           RevocationReason = 4
+	RevocationReasonCessationOfOperation RevocationReason = 5
+	RevocationReasonCertificateHold      RevocationReason = 6
+	RevocationReasonRemoveFromCRL        RevocationReason = 8
+	RevocationReasonPrivilegeWithdrawn   RevocationReason = 9
+	RevocationReasonAACompromise         RevocationReason = 10
+)
+
+// revocationReasonNames uses the spelling OpenSSL uses in its CA database.
+var revocationReasonNames = map[RevocationReason]string{
+	RevocationReasonUnspecified:          "unspecified",
+	RevocationReasonKeyCompromise:        "keyCompromise",
+	RevocationReasonCACompromise:         "CACompromise",
+	RevocationReasonAffiliationChanged:   "affiliationChanged",
+	RevocationReasonSuperseded:           "superseded",
+	RevocationReasonCessationOfOperation: "cessationOfOperation",
+	RevocationReasonCertificateHold:      "certificateHold",
+	RevocationReasonRemoveFromCRL:        "removeFromCRL",
+	RevocationReasonPrivilegeWithdrawn:   "privilegeWithdrawn",
+	RevocationReasonAACompromise:         "AACompromise",
+}
+
+// String implements the Stringer interface.
+func (r RevocationReason) String() string {
+	if name, found := revocationReasonNames[r]; found {
+		return name
+	}
+	return fmt.Sprintf("RevocationReason(%d)", int(r))
+}
+
+// ParseRevocationReason parses a revocation reason name as returned by String. Matching is
+// case-insensitive.
+func ParseRevocationReason(s string) (RevocationReason, error) {
+	for reason, name := range revocationReasonNames {
+		if strings.EqualFold(name, strings.TrimSpace(s)) {
+			return reason, nil
+		}
+	}
+	return RevocationReasonUnspecified, fmt.Errorf("unknown revocation reason: %s", s)
+}
+
+// RevokedCertificate describes a single revoked certificate.
+type RevokedCertificate struct {
+	SerialNumber   *big.Int
+	RevocationTime time.Time
+	// Reason is the revocation reason. RevocationReasonUnspecified is omitted from the CRL
+	// entry as RFC 5280 recommends.
+	Reason RevocationReason
+	// InvalidityDate is when the key is known or suspected to have been compromised. The
+	// zero value omits it.
+	InvalidityDate time.Time
+}
+
+// CRLParameters sets parameters for generating a CRL.
+type CRLParameters struct {
+	// Number is the CRL number. If nil, one is taken from NumberSource, which should be a
+	// monotonic source such as a FileSerialSource backed by a "crlnumber" file.
+	Number       *big.Int
+	NumberSource SerialSource
+	// ThisUpdate defaults to the current time.
+	ThisUpdate time.Time
+	// NextUpdate defaults to ThisUpdate plus DefaultCRLValidity.
+	NextUpdate time.Time
+	// SignatureAlgorithm overrides the algorithm the authority signs with.
+	SignatureAlgorithm x509.SignatureAlgorithm
+	// BaseCRLNumber makes this a delta CRL listing changes since the complete CRL with
+	// that number.
+	BaseCRLNumber *big.Int
+	// FreshestCRLURLs advertises where delta CRLs for this complete CRL can be found.
+	FreshestCRLURLs []string
+	// IssuingDistributionPoint restricts the scope of the CRL, for example to a single
+	// partition (see CRLPartitioner).
+	IssuingDistributionPoint *IssuingDistributionPointExtension
+	// ExtraExtensions are added to the CRL.
+	ExtraExtensions []pkix.Extension
+}
+
+// CreateCRL generates a CRL listing the revoked certificates, signed by the authority.
+func CreateCRL(revoked []RevokedCertificate, authority *x509.Certificate, authorityKey crypto.Signer,
+	parameters CRLParameters) (*x509.RevocationList, error) {
+	number := parameters.Number
+	if number == nil {
+		if parameters.NumberSource == nil {
+			return nil, ErrCRLNumberRequired
+		}
+		var err error
+		if number, err = parameters.NumberSource.NextSerial(); err != nil {
+			return nil, err
+		}
+	}
+	if number.Sign() < 0 {
+		return nil, fmt.Errorf("CRL number must not be negative: %v", number)
+	}
+
+	extraExtensions := append([]pkix.Extension{}, parameters.ExtraExtensions...)
+	if parameters.BaseCRLNumber != nil {
+		if len(parameters.FreshestCRLURLs) > 0 {
+			return nil, errors.New("a delta CRL cannot advertise freshest CRLs")
+		}
+		if number.Cmp(parameters.BaseCRLNumber) <= 0 {
+			return nil, fmt.Errorf("delta CRL number %v must be greater than base CRL number %v",
+				number, parameters.BaseCRLNumber)
+		}
+		ext, err := DeltaCRLIndicatorExtension{BaseCRLNumber: parameters.BaseCRLNumber}.Marshal()
+		if err != nil {
+			return nil, err
+		}
+		extraExtensions = append(extraExtensions, ext)
+	}
+	if len(parameters.FreshestCRLURLs) > 0 {
+		ext, err := FreshestCRLExtension{URLs: parameters.FreshestCRLURLs}.Marshal()
+		if err != nil {
+			return nil, err
+		}
+		extraExtensions = append(extraExtensions, ext)
+	}
+	if parameters.IssuingDistributionPoint != nil {
+		ext, err := parameters.IssuingDistributionPoint.Marshal()
+		if err != nil {
+			return nil, err
+		}
+		extraExtensions = append(extraExtensions, ext)
+	}
+
+	thisUpdate := parameters.ThisUpdate
+	if thisUpdate.IsZero() {
+		thisUpdate = time.Now()
+	}
+	nextUpdate := parameters.NextUpdate
+	if nextUpdate.IsZero() {
+		nextUpdate = thisUpdate.Add(DefaultCRLValidity)
+	}
+	if !nextUpdate.After(thisUpdate) {
+		return nil, fmt.Errorf("CRL nextUpdate %v is not after thisUpdate %v", nextUpdate, thisUpdate)
+	}
+
+	signatureAlgorithm, err := SelectSignatureAlgorithm(authorityKey.Public(), parameters.SignatureAlgorithm)
+	if err != nil {
+		return nil, err
+	}
+
+	entries := make([]x509.RevocationListEntry, 0, len(revoked))
+	for _, r := range revoked {
+		entry := x509.RevocationListEntry{
+			SerialNumber:   r.SerialNumber,
+			RevocationTime: r.RevocationTime,
+			ReasonCode:     int(r.Reason),
+		}
+		if !r.InvalidityDate.IsZero() {
+			value, err := asn1.MarshalWithParams(r.InvalidityDate.UTC(), "generalized")
+			if err != nil {
+				return nil, err
+			}
+			entry.ExtraExtensions = append(entry.ExtraExtensions, pkix.Extension{
+				Id:    oidExtensionInvalidityDate,
+				Value: value,
+			})
+		}
+		entries = append(entries, entry)
+	}
+
+	template := &x509.RevocationList{
+		SignatureAlgorithm:        signatureAlgorithm,
+		RevokedCertificateEntries: entries,
+		Number:                    number,
+		ThisUpdate:                thisUpdate,
+		NextUpdate:                nextUpdate,
+		ExtraExtensions:           extraExtensions,
+	}
+
+	crlBytes, err := x509.CreateRevocationList(rand.Reader, template, authority, authorityKey)
+	if err != nil {
+		return nil, err
+	}
+
+	return x509.ParseRevocationList(crlBytes)
+}
+
+// RevokedCertificatesFromCRL returns the entries of a parsed CRL, including the invalidity
+// date extension which the standard library does not decode.
+func RevokedCertificatesFromCRL(crl *x509.RevocationList) []RevokedCertificate {
+	revoked := make([]RevokedCertificate, 0, len(crl.RevokedCertificateEntries))
+	for _, entry := range crl.RevokedCertificateEntries {
+		r := RevokedCertificate{
+			SerialNumber:   entry.SerialNumber,
+			RevocationTime: entry.RevocationTime,
+			Reason:         RevocationReason(entry.ReasonCode),
+		}
+		for _, ext := range entry.Extensions {
+			if ext.Id.Equal(oidExtensionInvalidityDate) {
+				var invalidityDate time.Time
+				if _, err := asn1.UnmarshalWithParams(ext.Value, &invalidityDate, "generalized"); err == nil {
+					r.InvalidityDate = invalidityDate
+				}
+			}
+		}
+		revoked = append(revoked, r)
+	}
+	return revoked
+}
+
+// DeltaRevokedCertificates returns the entries for a delta CRL: those revoked in current
+// but not in base, and a removeFromCRL entry for each certificate on hold in base which is
+// no longer revoked.
+func DeltaRevokedCertificates(base []RevokedCertificate, current []RevokedCertificate) []RevokedCertificate {
+	inBase := make(map[string]RevokedCertificate, len(base))
+	for _, r := range base {
+		inBase[r.SerialNumber.String()] = r
+	}
+	inCurrent := make(map[string]bool, len(current))
+
+	delta := []RevokedCertificate{}
+	for _, r := range current {
+		inCurrent[r.SerialNumber.String()] = true
+		if previous, found := inBase[r.SerialNumber.String()]; !found || previous.Reason != r.Reason {
+			delta = append(delta, r)
+		}
+	}
+	for _, r := range base {
+		if !inCurrent[r.SerialNumber.String()] && r.Reason == RevocationReasonCertificateHold {
+			delta = append(delta, RevokedCertificate{
+				SerialNumber:   r.SerialNumber,
+				RevocationTime: r.RevocationTime,
+				Reason:         RevocationReasonRemoveFromCRL,
+			})
+		}
+	}
+	return delta
+}
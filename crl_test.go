@@ -0,0 +1,172 @@
+package certutils
+
+import (
+	"crypto/x509"
+	"crypto/x509/pkix"
+	"encoding/asn1"
+	"math/big"
+	"time"
+
+	. "gopkg.in/check.v1"
+)
+
+type CRLSuite struct {
+}
+
+var _ = Suite(&CRLSuite{})
+
+func (s *CRLSuite) TestCreateCRL(c *C) {
+	key, err := GeneratePrivateKey(PrivateKeyTypeEcp256)
+	c.Assert(err, IsNil)
+	ca, err := CreateRootCA(pkix.Name{CommonName: "CRL CA"}, key, CAParameters{})
+	c.Assert(err, IsNil)
+
+	revokedAt := time.Now().Add(-time.Hour).UTC().Truncate(time.Second)
+	compromisedAt := revokedAt.Add(-24 * time.Hour)
+	revoked := []RevokedCertificate{
+		{SerialNumber: big.NewInt(100), RevocationTime: revokedAt, Reason: RevocationReasonKeyCompromise, InvalidityDate: compromisedAt},
+		{SerialNumber: big.NewInt(101), RevocationTime: revokedAt},
+	}
+
+	numbers, err := NewCounterSerialSource(nil)
+	c.Assert(err, IsNil)
+	thisUpdate := time.Now().UTC().Truncate(time.Second)
+	crl, err := CreateCRL(revoked, ca, key, CRLParameters{
+		NumberSource: numbers,
+		ThisUpdate:   thisUpdate,
+		NextUpdate:   thisUpdate.Add(time.Hour),
+	})
+	c.Assert(err, IsNil)
+	c.Assert(crl.CheckSignatureFrom(ca), IsNil)
+	c.Check(crl.Number.Int64(), Equals, int64(1))
+	c.Check(crl.NextUpdate.Equal(thisUpdate.Add(time.Hour)), Equals, true)
+	c.Check(RevokedCertificatesFromCRL(crl), DeepEquals, revoked)
+
+	encoded, err := EncodeCRLs(crl)
+	c.Assert(err, IsNil)
+	decoded, err := LoadCRLsFromPem(encoded)
+	c.Assert(err, IsNil)
+	c.Assert(decoded, HasLen, 1)
+	c.Check(decoded[0].Raw, DeepEquals, crl.Raw)
+
+	next, err := CreateCRL(nil, ca, key, CRLParameters{NumberSource: numbers})
+	c.Assert(err, IsNil)
+	c.Check(next.Number.Int64(), Equals, int64(2))
+
+	_, err = CreateCRL(nil, ca, key, CRLParameters{})
+	c.Check(err, Equals, ErrCRLNumberRequired)
+}
+
+func (s *CRLSuite) TestRevocationReasonNames(c *C) {
+	for reason := range revocationReasonNames {
+		parsed, err := ParseRevocationReason(reason.String())
+		c.Assert(err, IsNil)
+		c.Check(parsed, Equals, reason)
+	}
+	_, err := ParseRevocationReason("bogus")
+	c.Check(err, NotNil)
+}
+
+func crlExtension(crl *x509.RevocationList, oid asn1.ObjectIdentifier) *pkix.Extension {
+	for _, ext := range crl.Extensions {
+		if ext.Id.Equal(oid) {
+			return &ext
+		}
+	}
+	return nil
+}
+
+func (s *CRLSuite) TestDeltaCRL(c *C) {
+	key, err := GeneratePrivateKey(PrivateKeyTypeEcp256)
+	c.Assert(err, IsNil)
+	ca, err := CreateRootCA(pkix.Name{CommonName: "Delta CA"}, key, CAParameters{})
+	c.Assert(err, IsNil)
+
+	now := time.Now().UTC().Truncate(time.Second)
+	base := []RevokedCertificate{
+		{SerialNumber: big.NewInt(1), RevocationTime: now, Reason: RevocationReasonSuperseded},
+		{SerialNumber: big.NewInt(2), RevocationTime: now, Reason: RevocationReasonCertificateHold},
+	}
+	current := []RevokedCertificate{
+		base[0],
+		{SerialNumber: big.NewInt(3), RevocationTime: now, Reason: RevocationReasonKeyCompromise},
+	}
+
+	numbers, err := NewCounterSerialSource(big.NewInt(10))
+	c.Assert(err, IsNil)
+	complete, err := CreateCRL(base, ca, key, CRLParameters{
+		NumberSource:    numbers,
+		FreshestCRLURLs: []string{"http://pki.example.com/delta.crl"},
+	})
+	c.Assert(err, IsNil)
+	freshest := FreshestCRLExtension{}
+	c.Assert(freshest.Unmarshal(*crlExtension(complete, oidExtensionFreshestCRL)), IsNil)
+	c.Check(freshest.URLs, DeepEquals, []string{"http://pki.example.com/delta.crl"})
+
+	delta, err := CreateCRL(DeltaRevokedCertificates(base, current), ca, key, CRLParameters{
+		NumberSource:  numbers,
+		BaseCRLNumber: complete.Number,
+	})
+	c.Assert(err, IsNil)
+	indicator := DeltaCRLIndicatorExtension{}
+	c.Assert(indicator.Unmarshal(*crlExtension(delta, oidExtensionDeltaCRLIndicator)), IsNil)
+	c.Check(indicator.BaseCRLNumber.Cmp(complete.Number), Equals, 0)
+
+	entries := RevokedCertificatesFromCRL(delta)
+	c.Assert(entries, HasLen, 2)
+	c.Check(entries[0].SerialNumber.Int64(), Equals, int64(3))
+	c.Check(entries[1].SerialNumber.Int64(), Equals, int64(2))
+	c.Check(entries[1].Reason, Equals, RevocationReasonRemoveFromCRL)
+
+	_, err = CreateCRL(nil, ca, key, CRLParameters{Number: big.NewInt(5), BaseCRLNumber: complete.Number})
+	c.Check(err, NotNil)
+}
+
+func (s *CRLSuite) TestPartitionedCRLs(c *C) {
+	caCert, caKey := newTestCA(c, PrivateKeyTypeEcp256)
+	partitioner := &CRLPartitioner{Partitions: 4, URLFormat: "http://pki.example.com/ca-%d.crl"}
+	ca := &CertificateAuthority{
+		Certificate: caCert,
+		Key:         caKey,
+		Endpoints:   AuthorityEndpoints{CRLPartitioner: partitioner},
+	}
+
+	key, err := GeneratePrivateKey(PrivateKeyTypeEcp256)
+	c.Assert(err, IsNil)
+	csr, err := GenerateCSR(pkix.Name{}, CSRParameters{KeyUsage: x509.KeyUsageDigitalSignature}, key, "shard.example.com")
+	c.Assert(err, IsNil)
+
+	revoked := []RevokedCertificate{}
+	for i := 0; i < 8; i++ {
+		cert, err := ca.Sign(csr, SigningParameters{NotBefore: CertificateNotBefore(), NotAfter: CertificateNotAfter(0, caCert)})
+		c.Assert(err, IsNil)
+		shard := partitioner.Partition(cert.SerialNumber)
+		c.Assert(cert.CRLDistributionPoints, DeepEquals, []string{partitioner.URL(shard)})
+		revoked = append(revoked, RevokedCertificate{SerialNumber: cert.SerialNumber, RevocationTime: time.Now()})
+	}
+
+	crls, err := partitioner.CreateCRLs(revoked, caCert, caKey, CRLParameters{Number: big.NewInt(1)})
+	c.Assert(err, IsNil)
+	c.Assert(crls, HasLen, 4)
+
+	total := 0
+	for shard, crl := range crls {
+		idp := IssuingDistributionPointExtension{}
+		c.Assert(idp.Unmarshal(*crlExtension(crl, oidExtensionIssuingDistributionPoint)), IsNil)
+		c.Check(idp.URL, Equals, partitioner.URL(shard))
+		for _, entry := range crl.RevokedCertificateEntries {
+			c.Check(partitioner.Partition(entry.SerialNumber), Equals, shard)
+		}
+		total += len(crl.RevokedCertificateEntries)
+	}
+	c.Check(total, Equals, len(revoked))
+
+	// Every partition needs its own URL.
+	for _, format := range []string{"", "http://pki.example.com/ca.crl", "http://pki.example.com/ca-%d-%d.crl", "http://pki.example.com/ca-%s.crl"} {
+		partitioner.URLFormat = format
+		_, err = partitioner.CreateCRLs(revoked, caCert, caKey, CRLParameters{Number: big.NewInt(2)})
+		c.Check(err, NotNil, Commentf("%q", format))
+		_, err = ca.Sign(csr, SigningParameters{NotBefore: CertificateNotBefore(), NotAfter: CertificateNotAfter(0, caCert)})
+		c.Check(err, NotNil, Commentf("%q", format))
+	}
+}
@@ -0,0 +1,102 @@
+package certutils
+
+import (
+	"crypto"
+	"crypto/x509"
+	"errors"
+	"fmt"
+	"math/big"
+	"strings"
+)
+
+// CRLPartitioner shards revocations across several CRLs by serial number, so that relying
+// parties only download the partition relevant to the certificate being checked. Each
+// partition is published at its own URL and carries an Issuing Distribution Point naming it.
+type CRLPartitioner struct {
+	// Partitions is the number of CRL partitions.
+	Partitions int
+	// URLFormat is a format string with a single %d verb for the partition number, for
+	// example "http://pki.example.com/ca-%d.crl".
+	URLFormat string
+}
+
+// Partition returns the partition the serial number is listed in.
+func (p CRLPartitioner) Partition(serial *big.Int) int {
+	if p.Partitions <= 1 {
+		return 0
+	}
+	return int(new(big.Int).Mod(serial, big.NewInt(int64(p.Partitions))).Int64())
+}
+
+// URL returns the distribution point of a partition.
+func (p CRLPartitioner) URL(partition int) string {
+	return fmt.Sprintf(p.URLFormat, partition)
+}
+
+// checkURLFormat checks URLFormat gives each partition its own well formed URL.
+func (p CRLPartitioner) checkURLFormat() error {
+	if p.URLFormat == "" {
+		return errors.New("CRL partitioner has no URL format")
+	}
+	if url := p.URL(0); url == p.URL(1) || strings.Contains(url, "%!") {
+		return fmt.Errorf("CRL partitioner URL format %q must have a single %%d verb", p.URLFormat)
+	}
+	return nil
+}
+
+// Split sorts revoked certificates into their partitions. The result always has one
+// element per partition.
+func (p CRLPartitioner) Split(revoked []RevokedCertificate) [][]RevokedCertificate {
+	partitions := p.Partitions
+	if partitions < 1 {
+		partitions = 1
+	}
+	split := make([][]RevokedCertificate, partitions)
+	for i := range split {
+		split[i] = []RevokedCertificate{}
+	}
+	for _, r := range revoked {
+		partition := p.Partition(r.SerialNumber)
+		split[partition] = append(split[partition], r)
+	}
+	return split
+}
+
+// CreateCRLs generates one CRL per partition, each scoped by an Issuing Distribution Point.
+// All partitions share a single CRL number, which is taken from parameters as for CreateCRL.
+// Set parameters.BaseCRLNumber to generate partitioned delta CRLs.
+func (p CRLPartitioner) CreateCRLs(revoked []RevokedCertificate, authority *x509.Certificate,
+	authorityKey crypto.Signer, parameters CRLParameters) ([]*x509.RevocationList, error) {
+	if err := p.checkURLFormat(); err != nil {
+		return nil, err
+	}
+
+	if parameters.Number == nil {
+		if parameters.NumberSource == nil {
+			return nil, ErrCRLNumberRequired
+		}
+		number, err := parameters.NumberSource.NextSerial()
+		if err != nil {
+			return nil, err
+		}
+		parameters.Number = number
+	}
+
+	crls := []*x509.RevocationList{}
+	for partition, entries := range p.Split(revoked) {
+		partitionParameters := parameters
+		idp := IssuingDistributionPointExtension{URL: p.URL(partition)}
+		if parameters.IssuingDistributionPoint != nil {
+			idp.OnlyContainsUserCerts = parameters.IssuingDistributionPoint.OnlyContainsUserCerts
+			idp.OnlyContainsCACerts = parameters.IssuingDistributionPoint.OnlyContainsCACerts
+		}
+		partitionParameters.IssuingDistributionPoint = &idp
+
+		crl, err := CreateCRL(entries, authority, authorityKey, partitionParameters)
+		if err != nil {
+			return nil, err
+		}
+		crls = append(crls, crl)
+	}
+	return crls, nil
+}
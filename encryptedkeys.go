@@ -0,0 +1,405 @@
+package certutils
+
+import (
+	"bytes"
+	"crypto/aes"
+	"crypto/cipher"
+	"crypto/pbkdf2"
+	"crypto/rand"
+	"crypto/sha1"
+	"crypto/sha256"
+	"crypto/sha512"
+	"crypto/x509"
+	"crypto/x509/pkix"
+	"encoding/asn1"
+	"encoding/pem"
+	"hash"
+
+	"github.com/pkg/errors"
+	"golang.org/x/crypto/scrypt"
+)
+
+var ErrPassphraseRequired = errors.New("private key is encrypted and no passphrase was supplied")
+var ErrIncorrectPassphrase = errors.New("could not decrypt private key: incorrect passphrase or corrupt data")
+var ErrUnsupportedKeyEncryption = errors.New("unsupported private key encryption scheme")
+
+const (
+	// DefaultPBKDF2Iterations is the iteration count used for PBKDF2 when none is specified.
+	DefaultPBKDF2Iterations = 600000
+	// DefaultScryptN is the scrypt cost parameter used when none is specified. It matches
+	// the OpenSSL default, as larger values exceed the OpenSSL scrypt memory limit.
+	DefaultScryptN = 1 << 14
+	// DefaultScryptR is the scrypt block size used when none is specified.
+	DefaultScryptR = 8
+	// DefaultScryptP is the scrypt parallelization parameter used when none is specified.
+	DefaultScryptP = 1
+
+	pbes2SaltLength = 16
+
+	// MaxPBKDF2Iterations, MaxScryptMemory and MaxScryptP bound the key derivation parameters
+	// accepted, so a crafted key cannot use unbounded CPU or memory, and keys are never written
+	// which could not be read back. Scrypt uses 128*N*r bytes of memory, and repeats that
+	// work p times.
+	MaxPBKDF2Iterations = 10 * DefaultPBKDF2Iterations
+	MaxScryptMemory     = 256 << 20
+	MaxScryptP          = 16
+)
+
+var (
+	oidPBES2  = asn1.ObjectIdentifier{1, 2, 840, 113549, 1, 5, 13}
+	oidPBKDF2 = asn1.ObjectIdentifier{1, 2, 840, 113549, 1, 5, 12}
+	oidScrypt = asn1.ObjectIdentifier{1, 3, 6, 1, 4, 1, 11591, 4, 11}
+
+	oidHMACWithSHA1   = asn1.ObjectIdentifier{1, 2, 840, 113549, 2, 7}
+	oidHMACWithSHA256 = asn1.ObjectIdentifier{1, 2, 840, 113549, 2, 9}
+	oidHMACWithSHA384 = asn1.ObjectIdentifier{1, 2, 840, 113549, 2, 10}
+	oidHMACWithSHA512 = asn1.ObjectIdentifier{1, 2, 840, 113549, 2, 11}
+
+	oidAES128CBC = asn1.ObjectIdentifier{2, 16, 840, 1, 101, 3, 4, 1, 2}
+	oidAES192CBC = asn1.ObjectIdentifier{2, 16, 840, 1, 101, 3, 4, 1, 22}
+	oidAES256CBC = asn1.ObjectIdentifier{2, 16, 840, 1, 101, 3, 4, 1, 42}
+)
+
+// PassphraseFunc supplies the passphrase for encrypted private keys. It is only
+// invoked if an encrypted key is actually encountered.
+type PassphraseFunc func() ([]byte, error)
+
+// StaticPassphrase returns a PassphraseFunc which always supplies the given passphrase.
+func StaticPassphrase(passphrase []byte) PassphraseFunc {
+	return func() ([]byte, error) {
+		return passphrase, nil
+	}
+}
+
+// KeyDerivationFunction selects the algorithm used to derive an encryption key
+// from a passphrase.
+type KeyDerivationFunction int
+
+const (
+	// KeyDerivationPBKDF2 uses PBKDF2 with HMAC-SHA256.
+	KeyDerivationPBKDF2 KeyDerivationFunction = iota
+	// KeyDerivationScrypt uses scrypt.
+	KeyDerivationScrypt
+)
+
+// KeyEncryptionParameters sets parameters for encrypting a private key with PBES2.
+// The cipher is always AES-256-CBC. Zero values select the package defaults.
+type KeyEncryptionParameters struct {
+	KeyDerivation KeyDerivationFunction
+	// Iterations is the PBKDF2 iteration count.
+	Iterations int
+	// ScryptN, ScryptR and ScryptP are the scrypt cost, block size and parallelization parameters.
+	ScryptN int
+	ScryptR int
+	ScryptP int
+}
+
+type encryptedPrivateKeyInfo struct {
+	Algorithm     pkix.AlgorithmIdentifier
+	EncryptedData []byte
+}
+
+type pbes2Params struct {
+	KeyDerivationFunc pkix.AlgorithmIdentifier
+	EncryptionScheme  pkix.AlgorithmIdentifier
+}
+
+type pbkdf2Params struct {
+	Salt           []byte
+	IterationCount int
+	KeyLength      int                      `asn1:"optional"`
+	PRF            pkix.AlgorithmIdentifier `asn1:"optional"`
+}
+
+type scryptParams struct {
+	Salt                     []byte
+	CostParameter            int
+	BlockSize                int
+	ParallelizationParameter int
+	KeyLength                int `asn1:"optional"`
+}
+
+// EncryptPrivateKey encrypts the given private key as a PKCS#8 EncryptedPrivateKeyInfo
+// using PBES2 and returns it as an "ENCRYPTED PRIVATE KEY" PEM block.
+func EncryptPrivateKey(key interface{}, passphrase []byte, parameters KeyEncryptionParameters) (*pem.Block, error) {
+	plaintext, err := x509.MarshalPKCS8PrivateKey(key)
+	if err != nil {
+		return nil, errors.Wrap(ErrUnknownTypeForKey, err.Error())
+	}
+
+	salt := make([]byte, pbes2SaltLength)
+	if _, err := rand.Read(salt); err != nil {
+		return nil, err
+	}
+	iv := make([]byte, aes.BlockSize)
+	if _, err := rand.Read(iv); err != nil {
+		return nil, err
+	}
+
+	const keyLength = 32
+	var kdf pkix.AlgorithmIdentifier
+	var derivedKey []byte
+	switch parameters.KeyDerivation {
+	case KeyDerivationPBKDF2:
+		iterations := parameters.Iterations
+		if iterations == 0 {
+			iterations = DefaultPBKDF2Iterations
+		}
+		if err := checkPBKDF2Iterations(iterations); err != nil {
+			return nil, err
+		}
+		derivedKey, err = pbkdf2.Key(sha256.New, string(passphrase), salt, iterations, keyLength)
+		if err != nil {
+			return nil, err
+		}
+		kdfParams, err := asn1.Marshal(pbkdf2Params{
+			Salt:           salt,
+			IterationCount: iterations,
+			PRF:            pkix.AlgorithmIdentifier{Algorithm: oidHMACWithSHA256, Parameters: asn1.NullRawValue},
+		})
+		if err != nil {
+			return nil, err
+		}
+		kdf = pkix.AlgorithmIdentifier{Algorithm: oidPBKDF2, Parameters: asn1.RawValue{FullBytes: kdfParams}}
+	case KeyDerivationScrypt:
+		n, r, p := parameters.ScryptN, parameters.ScryptR, parameters.ScryptP
+		if n == 0 {
+			n = DefaultScryptN
+		}
+		if r == 0 {
+			r = DefaultScryptR
+		}
+		if p == 0 {
+			p = DefaultScryptP
+		}
+		if err := checkScryptParameters(n, r, p); err != nil {
+			return nil, err
+		}
+		derivedKey, err = scrypt.Key(passphrase, salt, n, r, p, keyLength)
+		if err != nil {
+			return nil, err
+		}
+		kdfParams, err := asn1.Marshal(scryptParams{
+			Salt:                     salt,
+			CostParameter:            n,
+			BlockSize:                r,
+			ParallelizationParameter: p,
+		})
+		if err != nil {
+			return nil, err
+		}
+		kdf = pkix.AlgorithmIdentifier{Algorithm: oidScrypt, Parameters: asn1.RawValue{FullBytes: kdfParams}}
+	default:
+		return nil, ErrUnsupportedKeyEncryption
+	}
+
+	block, err := aes.NewCipher(derivedKey)
+	if err != nil {
+		return nil, err
+	}
+	padding := aes.BlockSize - len(plaintext)%aes.BlockSize
+	ciphertext := append(plaintext, bytes.Repeat([]byte{byte(padding)}, padding)...)
+	cipher.NewCBCEncrypter(block, iv).CryptBlocks(ciphertext, ciphertext)
+
+	ivBytes, err := asn1.Marshal(iv)
+	if err != nil {
+		return nil, err
+	}
+	schemeParams, err := asn1.Marshal(pbes2Params{
+		KeyDerivationFunc: kdf,
+		EncryptionScheme:  pkix.AlgorithmIdentifier{Algorithm: oidAES256CBC, Parameters: asn1.RawValue{FullBytes: ivBytes}},
+	})
+	if err != nil {
+		return nil, err
+	}
+
+	der, err := asn1.Marshal(encryptedPrivateKeyInfo{
+		Algorithm:     pkix.AlgorithmIdentifier{Algorithm: oidPBES2, Parameters: asn1.RawValue{FullBytes: schemeParams}},
+		EncryptedData: ciphertext,
+	})
+	if err != nil {
+		return nil, err
+	}
+
+	return &pem.Block{Type: EncryptedPrivateKeyBlockType, Bytes: der}, nil
+}
+
+// DecryptPrivateKey decrypts an "ENCRYPTED PRIVATE KEY" PEM block, or a legacy OpenSSL
+// block carrying a "Proc-Type: 4,ENCRYPTED" header, and returns the parsed private key.
+func DecryptPrivateKey(block *pem.Block, passphrase []byte) (interface{}, error) {
+	if block.Type != EncryptedPrivateKeyBlockType {
+		return decryptLegacyPrivateKey(block, passphrase)
+	}
+
+	var info encryptedPrivateKeyInfo
+	if rest, err := asn1.Unmarshal(block.Bytes, &info); err != nil || len(rest) > 0 {
+		return nil, errors.Wrap(ErrUnsupportedKeyEncryption, "malformed EncryptedPrivateKeyInfo")
+	}
+	if !info.Algorithm.Algorithm.Equal(oidPBES2) {
+		return nil, errors.Wrapf(ErrUnsupportedKeyEncryption, "encryption algorithm %v", info.Algorithm.Algorithm)
+	}
+
+	plaintext, err := decryptPBES2(info.Algorithm, info.EncryptedData, passphrase)
+	if err != nil {
+		return nil, err
+	}
+
+	key, err := x509.ParsePKCS8PrivateKey(plaintext)
+	if err != nil {
+		return nil, ErrIncorrectPassphrase
+	}
+	return key, nil
+}
+
+// decryptPBES2 decrypts ciphertext encrypted with the PBES2 scheme described by algorithm
+// and removes the padding.
+func decryptPBES2(algorithm pkix.AlgorithmIdentifier, ciphertext, passphrase []byte) ([]byte, error) {
+	var params pbes2Params
+	if _, err := asn1.Unmarshal(algorithm.Parameters.FullBytes, &params); err != nil {
+		return nil, errors.Wrap(ErrUnsupportedKeyEncryption, "malformed PBES2 parameters")
+	}
+
+	var keyLength int
+	switch {
+	case params.EncryptionScheme.Algorithm.Equal(oidAES128CBC):
+		keyLength = 16
+	case params.EncryptionScheme.Algorithm.Equal(oidAES192CBC):
+		keyLength = 24
+	case params.EncryptionScheme.Algorithm.Equal(oidAES256CBC):
+		keyLength = 32
+	default:
+		return nil, errors.Wrapf(ErrUnsupportedKeyEncryption, "cipher %v", params.EncryptionScheme.Algorithm)
+	}
+
+	var iv []byte
+	if _, err := asn1.Unmarshal(params.EncryptionScheme.Parameters.FullBytes, &iv); err != nil || len(iv) != aes.BlockSize {
+		return nil, errors.Wrap(ErrUnsupportedKeyEncryption, "malformed cipher IV")
+	}
+
+	derivedKey, err := deriveKey(params.KeyDerivationFunc, passphrase, keyLength)
+	if err != nil {
+		return nil, err
+	}
+	block, err := aes.NewCipher(derivedKey)
+	if err != nil {
+		return nil, err
+	}
+	return decryptCBC(block, iv, ciphertext)
+}
+
+// decryptCBC decrypts ciphertext in CBC mode and removes the PKCS#7 padding.
+func decryptCBC(block cipher.Block, iv, ciphertext []byte) ([]byte, error) {
+	blockSize := block.BlockSize()
+	if len(ciphertext) == 0 || len(ciphertext)%blockSize != 0 {
+		return nil, ErrIncorrectPassphrase
+	}
+	plaintext := make([]byte, len(ciphertext))
+	cipher.NewCBCDecrypter(block, iv).CryptBlocks(plaintext, ciphertext)
+
+	padding := int(plaintext[len(plaintext)-1])
+	if padding == 0 || padding > blockSize ||
+		!bytes.Equal(plaintext[len(plaintext)-padding:], bytes.Repeat([]byte{byte(padding)}, padding)) {
+		return nil, ErrIncorrectPassphrase
+	}
+	return plaintext[:len(plaintext)-padding], nil
+}
+
+// deriveKey runs the PBES2 key derivation function described by kdf.
+func deriveKey(kdf pkix.AlgorithmIdentifier, passphrase []byte, keyLength int) ([]byte, error) {
+	switch {
+	case kdf.Algorithm.Equal(oidPBKDF2):
+		var params pbkdf2Params
+		if _, err := asn1.Unmarshal(kdf.Parameters.FullBytes, &params); err != nil {
+			return nil, errors.Wrap(ErrUnsupportedKeyEncryption, "malformed PBKDF2 parameters")
+		}
+		var prf func() hash.Hash
+		switch {
+		case len(params.PRF.Algorithm) == 0, params.PRF.Algorithm.Equal(oidHMACWithSHA1):
+			prf = sha1.New
+		case params.PRF.Algorithm.Equal(oidHMACWithSHA256):
+			prf = sha256.New
+		case params.PRF.Algorithm.Equal(oidHMACWithSHA384):
+			prf = sha512.New384
+		case params.PRF.Algorithm.Equal(oidHMACWithSHA512):
+			prf = sha512.New
+		default:
+			return nil, errors.Wrapf(ErrUnsupportedKeyEncryption, "PBKDF2 PRF %v", params.PRF.Algorithm)
+		}
+		if err := checkPBKDF2Iterations(params.IterationCount); err != nil {
+			return nil, err
+		}
+		return pbkdf2.Key(prf, string(passphrase), params.Salt, params.IterationCount, keyLength)
+	case kdf.Algorithm.Equal(oidScrypt):
+		var params scryptParams
+		if _, err := asn1.Unmarshal(kdf.Parameters.FullBytes, &params); err != nil {
+			return nil, errors.Wrap(ErrUnsupportedKeyEncryption, "malformed scrypt parameters")
+		}
+		if err := checkScryptParameters(params.CostParameter, params.BlockSize, params.ParallelizationParameter); err != nil {
+			return nil, err
+		}
+		return scrypt.Key(passphrase, params.Salt, params.CostParameter, params.BlockSize,
+			params.ParallelizationParameter, keyLength)
+	default:
+		return nil, errors.Wrapf(ErrUnsupportedKeyEncryption, "key derivation function %v", kdf.Algorithm)
+	}
+}
+
+// checkPBKDF2Iterations rejects iteration counts outside 1 to MaxPBKDF2Iterations.
+func checkPBKDF2Iterations(iterations int) error {
+	if iterations < 1 || iterations > MaxPBKDF2Iterations {
+		return errors.Wrapf(ErrUnsupportedKeyEncryption, "PBKDF2 iteration count %d", iterations)
+	}
+	return nil
+}
+
+// checkScryptParameters rejects scrypt parameters beyond MaxScryptMemory and MaxScryptP.
+func checkScryptParameters(n, r, p int) error {
+	if n < 2 || r < 1 || p < 1 || p > MaxScryptP || n > MaxScryptMemory/128/r {
+		return errors.Wrapf(ErrUnsupportedKeyEncryption, "scrypt parameters N=%d r=%d p=%d", n, r, p)
+	}
+	return nil
+}
+
+// decryptLegacyPrivateKey handles the OpenSSL "traditional" encrypted PEM format. The
+// format is insecure and only supported for reading existing keys.
+func decryptLegacyPrivateKey(block *pem.Block, passphrase []byte) (interface{}, error) {
+	if !x509.IsEncryptedPEMBlock(block) {
+		return nil, errors.Wrapf(ErrUnsupportedKeyEncryption, "block %q is not encrypted", block.Type)
+	}
+	der, err := x509.DecryptPEMBlock(block, passphrase)
+	if err != nil {
+		return nil, ErrIncorrectPassphrase
+	}
+
+	var key interface{}
+	switch block.Type {
+	case RSAKeyBlockType:
+		key, err = x509.ParsePKCS1PrivateKey(der)
+	case ECKeyBlockType:
+		key, err = x509.ParseECPrivateKey(der)
+	case PrivateKeyBlockType:
+		key, err = x509.ParsePKCS8PrivateKey(der)
+	default:
+		return nil, errors.Wrapf(ErrUnsupportedKeyEncryption, "block type %q", block.Type)
+	}
+	if err != nil {
+		return nil, ErrIncorrectPassphrase
+	}
+	return key, nil
+}
+
+// EncodeEncryptedKeys returns the PEM-encoded byte array of the specified keys, each
+// encrypted with the passphrase as an "ENCRYPTED PRIVATE KEY" block.
+func EncodeEncryptedKeys(passphrase []byte, parameters KeyEncryptionParameters, keys ...interface{}) ([]byte, error) {
+	b := bytes.NewBuffer(nil)
+	for _, key := range keys {
+		block, err := EncryptPrivateKey(key, passphrase, parameters)
+		if err != nil {
+			return nil, err
+		}
+		if err := pem.Encode(b, block); err != nil {
+			return nil, err
+		}
+	}
+	return b.Bytes(), nil
+}
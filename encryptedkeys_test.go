@@ -0,0 +1,131 @@
+package certutils
+
+import (
+	"crypto/ecdsa"
+	"crypto/rand"
+	"crypto/x509"
+	"crypto/x509/pkix"
+	"encoding/asn1"
+	"encoding/pem"
+
+	"github.com/pkg/errors"
+	. "gopkg.in/check.v1"
+)
+
+type EncryptedKeySuite struct {
+}
+
+var _ = Suite(&EncryptedKeySuite{})
+
+func (s *EncryptedKeySuite) TestPBES2RoundTrip(c *C) {
+	passphrase := []byte("correct horse battery staple")
+	for _, parameters := range []KeyEncryptionParameters{
+		{KeyDerivation: KeyDerivationPBKDF2, Iterations: 1000},
+		{KeyDerivation: KeyDerivationScrypt, ScryptN: 1024},
+	} {
+		for _, keyType := range []PrivateKeyType{PrivateKeyTypeEcp256, PrivateKeyTypeEd25519} {
+			key, err := GeneratePrivateKey(keyType)
+			c.Assert(err, IsNil)
+
+			encoded, err := EncodeEncryptedKeys(passphrase, parameters, key)
+			c.Assert(err, IsNil)
+
+			keys, err := LoadEncryptedPrivateKeysFromPem(encoded, StaticPassphrase(passphrase))
+			c.Assert(err, IsNil)
+			c.Assert(keys, HasLen, 1)
+			c.Assert(keys[0], DeepEquals, key)
+
+			_, err = LoadEncryptedPrivateKeysFromPem(encoded, StaticPassphrase([]byte("wrong")))
+			c.Assert(errors.Is(err, ErrIncorrectPassphrase), Equals, true)
+
+			_, err = LoadPrivateKeysFromPem(encoded)
+			c.Assert(errors.Is(err, ErrPassphraseRequired), Equals, true)
+		}
+	}
+}
+
+// pbes2TestBlock returns an "ENCRYPTED PRIVATE KEY" block using the key derivation function,
+// with AES-256-CBC and a dummy ciphertext.
+func pbes2TestBlock(c *C, kdf asn1.ObjectIdentifier, kdfParams interface{}) *pem.Block {
+	kdfDER, err := asn1.Marshal(kdfParams)
+	c.Assert(err, IsNil)
+	ivDER, err := asn1.Marshal(make([]byte, 16))
+	c.Assert(err, IsNil)
+	params, err := asn1.Marshal(pbes2Params{
+		KeyDerivationFunc: pkix.AlgorithmIdentifier{Algorithm: kdf, Parameters: asn1.RawValue{FullBytes: kdfDER}},
+		EncryptionScheme:  pkix.AlgorithmIdentifier{Algorithm: oidAES256CBC, Parameters: asn1.RawValue{FullBytes: ivDER}},
+	})
+	c.Assert(err, IsNil)
+	der, err := asn1.Marshal(encryptedPrivateKeyInfo{
+		Algorithm:     pkix.AlgorithmIdentifier{Algorithm: oidPBES2, Parameters: asn1.RawValue{FullBytes: params}},
+		EncryptedData: make([]byte, 32),
+	})
+	c.Assert(err, IsNil)
+	return &pem.Block{Type: EncryptedPrivateKeyBlockType, Bytes: der}
+}
+
+func (s *EncryptedKeySuite) TestKeyDerivationLimits(c *C) {
+	salt := make([]byte, 16)
+	for _, block := range []*pem.Block{
+		pbes2TestBlock(c, oidPBKDF2, pbkdf2Params{Salt: salt, IterationCount: MaxPBKDF2Iterations + 1}),
+		pbes2TestBlock(c, oidPBKDF2, pbkdf2Params{Salt: salt, IterationCount: 0}),
+		pbes2TestBlock(c, oidScrypt, scryptParams{Salt: salt, CostParameter: 1 << 30, BlockSize: 8, ParallelizationParameter: 1}),
+		pbes2TestBlock(c, oidScrypt, scryptParams{Salt: salt, CostParameter: 1 << 14, BlockSize: 1 << 20, ParallelizationParameter: 1}),
+		pbes2TestBlock(c, oidScrypt, scryptParams{Salt: salt, CostParameter: 1 << 14, BlockSize: 8, ParallelizationParameter: 1 << 20}),
+	} {
+		_, err := DecryptPrivateKey(block, []byte("secret"))
+		c.Check(errors.Is(err, ErrUnsupportedKeyEncryption), Equals, true, Commentf("%v", err))
+	}
+
+	// Parameters within the limits get as far as decryption.
+	block := pbes2TestBlock(c, oidPBKDF2, pbkdf2Params{Salt: salt, IterationCount: 1000})
+	_, err := DecryptPrivateKey(block, []byte("secret"))
+	c.Check(errors.Is(err, ErrIncorrectPassphrase), Equals, true)
+
+	// Keys which could not be decrypted are never written.
+	key, err := GeneratePrivateKey(PrivateKeyTypeEcp256)
+	c.Assert(err, IsNil)
+	for _, parameters := range []KeyEncryptionParameters{
+		{Iterations: MaxPBKDF2Iterations + 1},
+		{Iterations: -1},
+		{KeyDerivation: KeyDerivationScrypt, ScryptN: 1 << 30},
+		{KeyDerivation: KeyDerivationScrypt, ScryptR: 1 << 20},
+		{KeyDerivation: KeyDerivationScrypt, ScryptP: MaxScryptP + 1},
+	} {
+		_, err := EncryptPrivateKey(key, []byte("secret"), parameters)
+		c.Check(errors.Is(err, ErrUnsupportedKeyEncryption), Equals, true, Commentf("%+v: %v", parameters, err))
+	}
+}
+
+func (s *EncryptedKeySuite) TestPassphraseCallbackCalledOnce(c *C) {
+	passphrase := []byte("hunter2")
+	key1, _ := GeneratePrivateKey(PrivateKeyTypeEcp256)
+	key2, _ := GeneratePrivateKey(PrivateKeyTypeEcp256)
+	encoded, err := EncodeEncryptedKeys(passphrase, KeyEncryptionParameters{Iterations: 1000}, key1, key2)
+	c.Assert(err, IsNil)
+
+	calls := 0
+	keys, err := LoadEncryptedPrivateKeysFromPem(encoded, func() ([]byte, error) {
+		calls++
+		return passphrase, nil
+	})
+	c.Assert(err, IsNil)
+	c.Assert(keys, HasLen, 2)
+	c.Assert(calls, Equals, 1)
+}
+
+func (s *EncryptedKeySuite) TestLegacyEncryptedPem(c *C) {
+	passphrase := []byte("legacy")
+	key, err := GeneratePrivateKey(PrivateKeyTypeEcp256)
+	c.Assert(err, IsNil)
+	der, err := x509.MarshalECPrivateKey(key.(*ecdsa.PrivateKey))
+	c.Assert(err, IsNil)
+
+	block, err := x509.EncryptPEMBlock(rand.Reader, ECKeyBlockType, der, passphrase, x509.PEMCipherAES256)
+	c.Assert(err, IsNil)
+
+	keys, err := LoadEncryptedPrivateKeysFromPem(pem.EncodeToMemory(block), StaticPassphrase(passphrase))
+	c.Assert(err, IsNil)
+	c.Assert(keys, HasLen, 1)
+	c.Assert(keys[0], DeepEquals, key)
+}
@@ -7,6 +7,7 @@ import (
 	"errors"
 	"fmt"
 	"github.com/paulgriffiths/pki/extensions"
+	"math/big"
 )
 
 var oidExtensionCertificateType goasn1.ObjectIdentifier = []int{1, 3, 6, 1, 4, 1, 311, 20, 2}
@@ -46,3 +47,199 @@ func (e *CertificateTypeExtension) Unmarshal(ext pkix.Extension) error {
 
 	return nil
 }
+
+var (
+	oidExtensionDeltaCRLIndicator        goasn1.ObjectIdentifier = []int{2, 5, 29, 27}
+	oidExtensionIssuingDistributionPoint goasn1.ObjectIdentifier = []int{2, 5, 29, 28}
+	oidExtensionFreshestCRL              goasn1.ObjectIdentifier = []int{2, 5, 29, 46}
+)
+
+// distributionPointName mirrors the RFC 5280 DistributionPointName CHOICE. Only the
+// fullName alternative is used.
+type distributionPointName struct {
+	FullName     []asn1.RawValue  `asn1:"optional,tag:0"`
+	RelativeName pkix.RDNSequence `asn1:"optional,tag:1"`
+}
+
+type distributionPoint struct {
+	DistributionPoint distributionPointName `asn1:"optional,tag:0"`
+	Reason            asn1.BitString        `asn1:"optional,tag:1"`
+	CRLIssuer         asn1.RawValue         `asn1:"optional,tag:2"`
+}
+
+type issuingDistributionPoint struct {
+	DistributionPoint          distributionPointName `asn1:"optional,tag:0"`
+	OnlyContainsUserCerts      bool                  `asn1:"optional,tag:1"`
+	OnlyContainsCACerts        bool                  `asn1:"optional,tag:2"`
+	OnlySomeReasons            asn1.BitString        `asn1:"optional,tag:3"`
+	IndirectCRL                bool                  `asn1:"optional,tag:4"`
+	OnlyContainsAttributeCerts bool                  `asn1:"optional,tag:5"`
+}
+
+// uriGeneralNames encodes URLs as uniformResourceIdentifier GeneralNames.
+func uriGeneralNames(urls []string) []asn1.RawValue {
+	names := make([]asn1.RawValue, 0, len(urls))
+	for _, u := range urls {
+		names = append(names, asn1.RawValue{Tag: 6, Class: asn1.ClassContextSpecific, Bytes: []byte(u)})
+	}
+	return names
+}
+
+// generalNameURIs returns the uniformResourceIdentifier entries of a GeneralNames.
+func generalNameURIs(names []asn1.RawValue) []string {
+	urls := []string{}
+	for _, name := range names {
+		if name.Class == asn1.ClassContextSpecific && name.Tag == 6 {
+			urls = append(urls, string(name.Bytes))
+		}
+	}
+	return urls
+}
+
+// DeltaCRLIndicatorExtension marks a CRL as a delta CRL relative to the complete CRL with
+// BaseCRLNumber (RFC 5280 section 5.2.4).
+type DeltaCRLIndicatorExtension struct {
+	BaseCRLNumber *big.Int
+}
+
+// Marshal returns a pkix.Extension.
+func (e DeltaCRLIndicatorExtension) Marshal() (pkix.Extension, error) {
+	if e.BaseCRLNumber == nil {
+		return pkix.Extension{}, errors.New("no base CRL number specified")
+	}
+
+	der, err := asn1.Marshal(e.BaseCRLNumber)
+	if err != nil {
+		return pkix.Extension{}, err
+	}
+
+	return pkix.Extension{
+		Id:       oidExtensionDeltaCRLIndicator,
+		Critical: true,
+		Value:    der,
+	}, nil
+}
+
+// Unmarshal parses a pkix.Extension and stores the result in the object.
+func (e *DeltaCRLIndicatorExtension) Unmarshal(ext pkix.Extension) error {
+	if !ext.Id.Equal(oidExtensionDeltaCRLIndicator) {
+		return fmt.Errorf("unexpected OID: %v", ext.Id)
+	}
+
+	e.BaseCRLNumber = new(big.Int)
+	if rest, err := asn1.Unmarshal(ext.Value, &e.BaseCRLNumber); err != nil {
+		return err
+	} else if len(rest) > 0 {
+		return extensions.ErrTrailingBytes
+	}
+
+	return nil
+}
+
+// FreshestCRLExtension lists where delta CRLs can be found (RFC 5280 section 4.2.1.15). It
+// appears in certificates and complete CRLs.
+type FreshestCRLExtension struct {
+	URLs []string
+}
+
+// Marshal returns a pkix.Extension.
+func (e FreshestCRLExtension) Marshal() (pkix.Extension, error) {
+	if len(e.URLs) == 0 {
+		return pkix.Extension{}, errors.New("no freshest CRL URLs specified")
+	}
+
+	points := make([]distributionPoint, 0, len(e.URLs))
+	for _, u := range e.URLs {
+		points = append(points, distributionPoint{
+			DistributionPoint: distributionPointName{FullName: uriGeneralNames([]string{u})},
+		})
+	}
+
+	der, err := asn1.Marshal(points)
+	if err != nil {
+		return pkix.Extension{}, err
+	}
+
+	return pkix.Extension{
+		Id:    oidExtensionFreshestCRL,
+		Value: der,
+	}, nil
+}
+
+// Unmarshal parses a pkix.Extension and stores the result in the object.
+func (e *FreshestCRLExtension) Unmarshal(ext pkix.Extension) error {
+	if !ext.Id.Equal(oidExtensionFreshestCRL) {
+		return fmt.Errorf("unexpected OID: %v", ext.Id)
+	}
+
+	var points []distributionPoint
+	if rest, err := asn1.Unmarshal(ext.Value, &points); err != nil {
+		return err
+	} else if len(rest) > 0 {
+		return extensions.ErrTrailingBytes
+	}
+
+	e.URLs = []string{}
+	for _, point := range points {
+		e.URLs = append(e.URLs, generalNameURIs(point.DistributionPoint.FullName)...)
+	}
+
+	return nil
+}
+
+// IssuingDistributionPointExtension identifies the scope of a CRL (RFC 5280 section 5.2.5).
+// It is used to partition revocations across several CRLs.
+type IssuingDistributionPointExtension struct {
+	URL                   string
+	OnlyContainsUserCerts bool
+	OnlyContainsCACerts   bool
+	IndirectCRL           bool
+}
+
+// Marshal returns a pkix.Extension.
+func (e IssuingDistributionPointExtension) Marshal() (pkix.Extension, error) {
+	idp := issuingDistributionPoint{
+		OnlyContainsUserCerts: e.OnlyContainsUserCerts,
+		OnlyContainsCACerts:   e.OnlyContainsCACerts,
+		IndirectCRL:           e.IndirectCRL,
+	}
+	if e.URL != "" {
+		idp.DistributionPoint.FullName = uriGeneralNames([]string{e.URL})
+	}
+
+	der, err := asn1.Marshal(idp)
+	if err != nil {
+		return pkix.Extension{}, err
+	}
+
+	return pkix.Extension{
+		Id:       oidExtensionIssuingDistributionPoint,
+		Critical: true,
+		Value:    der,
+	}, nil
+}
+
+// Unmarshal parses a pkix.Extension and stores the result in the object.
+func (e *IssuingDistributionPointExtension) Unmarshal(ext pkix.Extension) error {
+	if !ext.Id.Equal(oidExtensionIssuingDistributionPoint) {
+		return fmt.Errorf("unexpected OID: %v", ext.Id)
+	}
+
+	var idp issuingDistributionPoint
+	if rest, err := asn1.Unmarshal(ext.Value, &idp); err != nil {
+		return err
+	} else if len(rest) > 0 {
+		return extensions.ErrTrailingBytes
+	}
+
+	*e = IssuingDistributionPointExtension{
+		OnlyContainsUserCerts: idp.OnlyContainsUserCerts,
+		OnlyContainsCACerts:   idp.OnlyContainsCACerts,
+		IndirectCRL:           idp.IndirectCRL,
+	}
+	if urls := generalNameURIs(idp.DistributionPoint.FullName); len(urls) > 0 {
+		e.URL = urls[0]
+	}
+
+	return nil
+}
@@ -1,6 +1,8 @@
 package certutils
 
 import (
+	"math/big"
+
 	. "gopkg.in/check.v1"
 )
 
@@ -21,3 +23,24 @@ func (s *ExtSuite) TestCertificateTemplateExtension(c *C) {
 	c.Assert(err, IsNil)
 	c.Assert(b.Name, Equals, a.Name)
 }
+
+func (s *ExtSuite) TestCRLExtensions(c *C) {
+	delta, err := DeltaCRLIndicatorExtension{BaseCRLNumber: big.NewInt(42)}.Marshal()
+	c.Assert(err, IsNil)
+	c.Assert(delta.Critical, Equals, true)
+	parsedDelta := DeltaCRLIndicatorExtension{}
+	c.Assert(parsedDelta.Unmarshal(delta), IsNil)
+	c.Assert(parsedDelta.BaseCRLNumber.Int64(), Equals, int64(42))
+
+	freshest, err := FreshestCRLExtension{URLs: []string{"http://a/delta.crl", "http://b/delta.crl"}}.Marshal()
+	c.Assert(err, IsNil)
+	parsedFreshest := FreshestCRLExtension{}
+	c.Assert(parsedFreshest.Unmarshal(freshest), IsNil)
+	c.Assert(parsedFreshest.URLs, DeepEquals, []string{"http://a/delta.crl", "http://b/delta.crl"})
+
+	idp, err := IssuingDistributionPointExtension{URL: "http://a/ca-1.crl", OnlyContainsUserCerts: true}.Marshal()
+	c.Assert(err, IsNil)
+	parsedIDP := IssuingDistributionPointExtension{}
+	c.Assert(parsedIDP.Unmarshal(idp), IsNil)
+	c.Assert(parsedIDP, DeepEquals, IssuingDistributionPointExtension{URL: "http://a/ca-1.crl", OnlyContainsUserCerts: true})
+}
@@ -3,11 +3,13 @@ package certutils
 import "strings"
 
 // CommonNameToFileName converts an FQDN into a more unambiguous form for representation
-// as a filename.
+// as a filename. Path separators are replaced so the result is always a single path element.
 func CommonNameToFileName(cn string) string {
 	outstr := cn
 	outstr = strings.ReplaceAll(outstr, ".", "_")
 	outstr = strings.ReplaceAll(outstr, " ", "")
 	outstr = strings.ReplaceAll(outstr, "*", "STAR")
+	outstr = strings.ReplaceAll(outstr, "/", "_")
+	outstr = strings.ReplaceAll(outstr, "\\", "_")
 	return outstr
 }
@@ -3,10 +3,13 @@ module github.com/wrouesnel/certutils
 go 1.24.3
 
 require (
+	github.com/fsnotify/fsnotify v1.10.1
 	github.com/paulgriffiths/pki v0.0.0-20200320011419-a59892a7d247
 	github.com/pkg/errors v0.9.1
 	github.com/spf13/afero v1.14.0
+	golang.org/x/crypto v0.45.0
 	gopkg.in/check.v1 v1.0.0-20201130134442-10cb98267c6c
+	software.sslmate.com/src/go-pkcs12 v0.7.3
 )
 
 require (
@@ -33,11 +36,12 @@ require (
 	github.com/spf13/cast v1.3.1 // indirect
 	github.com/urfave/cli/v2 v2.27.2 // indirect
 	github.com/xrash/smetrics v0.0.0-20240312152122-5f08fbb34913 // indirect
-	golang.org/x/crypto v0.17.0 // indirect
-	golang.org/x/mod v0.17.0 // indirect
-	golang.org/x/sys v0.20.0 // indirect
-	golang.org/x/text v0.23.0 // indirect
-	golang.org/x/tools v0.21.1-0.20240508182429-e35e4ccd0d2d // indirect
+	golang.org/x/mod v0.29.0 // indirect
+	golang.org/x/sync v0.18.0 // indirect
+	golang.org/x/sys v0.38.0 // indirect
+	golang.org/x/telemetry v0.0.0-20251008203120-078029d740a8 // indirect
+	golang.org/x/text v0.31.0 // indirect
+	golang.org/x/tools v0.38.0 // indirect
 	golang.org/x/tools/cmd/cover v0.1.0-deprecated // indirect
 )
 
@@ -0,0 +1,226 @@
+package certutils
+
+import (
+	"crypto/x509"
+	"crypto/x509/pkix"
+	"encoding/asn1"
+	"net"
+	"net/url"
+
+	extasn1 "github.com/paulgriffiths/pki/asn1"
+	"github.com/paulgriffiths/pki/extensions"
+)
+
+var oidExtensionSubjectAltName = asn1.ObjectIdentifier{2, 5, 29, 17}
+
+// ExtensionPolicy declares how an issuer treats a value requested in a CSR.
+type ExtensionPolicy int
+
+const (
+	// ExtensionPolicyDrop discards the requested value. This is the zero value, so
+	// anything a policy does not mention is not issued.
+	ExtensionPolicyDrop ExtensionPolicy = iota
+	// ExtensionPolicyAllow copies the requested value into the certificate, subject to
+	// any restrictions the policy declares.
+	ExtensionPolicyAllow
+	// ExtensionPolicyOverride ignores the requested value and uses the value declared
+	// by the issuer.
+	ExtensionPolicyOverride
+)
+
+// IssuancePolicy declares which parts of an untrusted CSR the issuer will honour.
+type IssuancePolicy struct {
+	// BasicConstraints governs the requested CA flag and path length. When overridden,
+	// IsCA, MaxPathLen and MaxPathLenZero are used with the same meaning as in x509.Certificate.
+	BasicConstraints ExtensionPolicy
+	IsCA             bool
+	MaxPathLen       int
+	MaxPathLenZero   bool
+
+	// KeyUsage governs the requested key usage. When allowed, the request is masked by
+	// PermittedKeyUsage if it is non-zero, and certificate and CRL signing are removed unless
+	// the certificate is issued as a CA. When overridden, KeyUsageValue is used.
+	KeyUsage          ExtensionPolicy
+	PermittedKeyUsage x509.KeyUsage
+	KeyUsageValue     x509.KeyUsage
+
+	// ExtKeyUsage governs the requested extended key usages. When allowed, only usages in
+	// PermittedExtKeyUsage are kept if it is non-empty. When overridden, ExtKeyUsageValue is used.
+	ExtKeyUsage          ExtensionPolicy
+	PermittedExtKeyUsage []x509.ExtKeyUsage
+	ExtKeyUsageValue     []x509.ExtKeyUsage
+
+	// SubjectAltNames governs the requested DNS, email, IP and URI names. When overridden,
+	// the names below are used instead.
+	SubjectAltNames ExtensionPolicy
+	DNSNames        []string
+	EmailAddresses  []string
+	IPAddresses     []net.IP
+	URIs            []*url.URL
+
+	// UnknownExtensions governs requested extensions which are not handled above and are
+	// not listed in Extensions. ExtensionPolicyOverride is treated as a drop.
+	UnknownExtensions ExtensionPolicy
+	// Extensions sets the policy for individual extensions, keyed by dotted OID string.
+	Extensions map[string]ExtensionPolicy
+	// ExtraExtensions are always added by the issuer, replacing any requested extension
+	// with the same OID.
+	ExtraExtensions []pkix.Extension
+}
+
+// DefaultIssuancePolicy returns the policy used when signing an untrusted CSR. Key usages
+// and subject alternative names are honoured, the certificate is always issued as a
+// non-CA without certificate or CRL signing, and any other requested extension is dropped.
+func DefaultIssuancePolicy() *IssuancePolicy {
+	return &IssuancePolicy{
+		BasicConstraints:  ExtensionPolicyOverride,
+		IsCA:              false,
+		KeyUsage:          ExtensionPolicyAllow,
+		ExtKeyUsage:       ExtensionPolicyAllow,
+		SubjectAltNames:   ExtensionPolicyAllow,
+		UnknownExtensions: ExtensionPolicyDrop,
+	}
+}
+
+// PermissiveIssuancePolicy returns a policy which honours everything in the CSR. It should
+// only be used when the CSR was generated by the issuer itself.
+func PermissiveIssuancePolicy() *IssuancePolicy {
+	return &IssuancePolicy{
+		BasicConstraints:  ExtensionPolicyAllow,
+		KeyUsage:          ExtensionPolicyAllow,
+		ExtKeyUsage:       ExtensionPolicyAllow,
+		SubjectAltNames:   ExtensionPolicyAllow,
+		UnknownExtensions: ExtensionPolicyAllow,
+	}
+}
+
+// extensionPolicy returns the policy for an extension not otherwise handled by the policy.
+func (p *IssuancePolicy) extensionPolicy(oid asn1.ObjectIdentifier) ExtensionPolicy {
+	for _, ext := range p.ExtraExtensions {
+		if ext.Id.Equal(oid) {
+			return ExtensionPolicyOverride
+		}
+	}
+	if policy, found := p.Extensions[oid.String()]; found {
+		return policy
+	}
+	return p.UnknownExtensions
+}
+
+// extKeyUsagePermitted returns true if usage may be issued under an allow policy.
+func (p *IssuancePolicy) extKeyUsagePermitted(usage x509.ExtKeyUsage) bool {
+	if len(p.PermittedExtKeyUsage) == 0 {
+		return true
+	}
+	for _, permitted := range p.PermittedExtKeyUsage {
+		if permitted == usage {
+			return true
+		}
+	}
+	return false
+}
+
+// Apply fills in the certificate template from the CSR according to the policy. Only the
+// extension-derived fields of the template are modified.
+func (p *IssuancePolicy) Apply(csr *x509.CertificateRequest, certificate *x509.Certificate) {
+	var requestedBasicConstraints *extensions.BasicConstraints
+	var requestedKeyUsage x509.KeyUsage
+	var requestedExtKeyUsage []asn1.ObjectIdentifier
+	extraExtensions := []pkix.Extension{}
+
+	// We need to parse the extensions to regenerate x509.Certificate objects
+	// so signing works properly.
+	for _, ext := range csr.Extensions {
+		switch {
+		case ext.Id.Equal(extasn1.OIDBasicConstraints):
+			r := extensions.BasicConstraints{}
+			if err := r.Unmarshal(ext); err != nil {
+				continue
+			}
+			requestedBasicConstraints = &r
+		case ext.Id.Equal(extasn1.OIDKeyUsage):
+			r := extensions.KeyUsage{}
+			if err := r.Unmarshal(ext); err != nil {
+				continue
+			}
+			requestedKeyUsage = r.Value
+		case ext.Id.Equal(extasn1.OIDExtendedKeyUsage):
+			r := extensions.ExtendedKeyUsage{}
+			if err := r.Unmarshal(ext); err != nil {
+				continue
+			}
+			requestedExtKeyUsage = r.OIDs
+		case ext.Id.Equal(oidExtensionSubjectAltName):
+			// Already parsed into the CSR name fields.
+		default:
+			if p.extensionPolicy(ext.Id) == ExtensionPolicyAllow {
+				extraExtensions = append(extraExtensions, ext)
+			}
+		}
+	}
+
+	switch p.BasicConstraints {
+	case ExtensionPolicyAllow:
+		if requestedBasicConstraints != nil {
+			certificate.BasicConstraintsValid = true
+			certificate.IsCA = requestedBasicConstraints.IsCA
+			certificate.MaxPathLen = requestedBasicConstraints.MaxPathLen
+			// An explicit zero path length must be distinguished from an absent one.
+			certificate.MaxPathLenZero = requestedBasicConstraints.IsCA && requestedBasicConstraints.MaxPathLen == 0
+		}
+	case ExtensionPolicyOverride:
+		certificate.BasicConstraintsValid = true
+		certificate.IsCA = p.IsCA
+		certificate.MaxPathLen = p.MaxPathLen
+		certificate.MaxPathLenZero = p.MaxPathLenZero
+	}
+
+	switch p.KeyUsage {
+	case ExtensionPolicyAllow:
+		certificate.KeyUsage = requestedKeyUsage
+		if p.PermittedKeyUsage != 0 {
+			certificate.KeyUsage &= p.PermittedKeyUsage
+		}
+		// RFC 5280 section 4.2.1.9 only allows these on CA certificates.
+		if !certificate.BasicConstraintsValid || !certificate.IsCA {
+			certificate.KeyUsage &^= x509.KeyUsageCertSign | x509.KeyUsageCRLSign
+		}
+	case ExtensionPolicyOverride:
+		certificate.KeyUsage = p.KeyUsageValue
+	}
+
+	certificate.ExtKeyUsage = []x509.ExtKeyUsage{}
+	switch p.ExtKeyUsage {
+	case ExtensionPolicyAllow:
+		for _, oid := range requestedExtKeyUsage {
+			usage, found := OIDToExtKeyUsage(oid)
+			if !found {
+				// Usages we cannot name are only passed through when unrestricted.
+				if len(p.PermittedExtKeyUsage) == 0 {
+					certificate.UnknownExtKeyUsage = append(certificate.UnknownExtKeyUsage, oid)
+				}
+				continue
+			}
+			if p.extKeyUsagePermitted(usage) {
+				certificate.ExtKeyUsage = append(certificate.ExtKeyUsage, usage)
+			}
+		}
+	case ExtensionPolicyOverride:
+		certificate.ExtKeyUsage = append(certificate.ExtKeyUsage, p.ExtKeyUsageValue...)
+	}
+
+	switch p.SubjectAltNames {
+	case ExtensionPolicyAllow:
+		certificate.DNSNames = csr.DNSNames
+		certificate.EmailAddresses = csr.EmailAddresses
+		certificate.IPAddresses = csr.IPAddresses
+		certificate.URIs = csr.URIs
+	case ExtensionPolicyOverride:
+		certificate.DNSNames = p.DNSNames
+		certificate.EmailAddresses = p.EmailAddresses
+		certificate.IPAddresses = p.IPAddresses
+		certificate.URIs = p.URIs
+	}
+
+	certificate.ExtraExtensions = append(extraExtensions, p.ExtraExtensions...)
+}
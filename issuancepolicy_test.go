@@ -0,0 +1,112 @@
+package certutils
+
+import (
+	"crypto"
+	"crypto/x509"
+	"crypto/x509/pkix"
+	"encoding/asn1"
+
+	. "gopkg.in/check.v1"
+)
+
+type IssuancePolicySuite struct {
+	ca    *x509.Certificate
+	caKey crypto.Signer
+	csr   *x509.CertificateRequest
+}
+
+var _ = Suite(&IssuancePolicySuite{})
+
+func (s *IssuancePolicySuite) SetUpSuite(c *C) {
+	s.ca, s.caKey = newTestCA(c, PrivateKeyTypeEcp256)
+
+	key, err := GeneratePrivateKey(PrivateKeyTypeEcp256)
+	c.Assert(err, IsNil)
+	// An untrusted request asking for more than it should get.
+	s.csr, err = GenerateCSR(pkix.Name{}, CSRParameters{
+		KeyUsage:            x509.KeyUsageDigitalSignature | x509.KeyUsageCertSign,
+		ExtKeyUsage:         []x509.ExtKeyUsage{x509.ExtKeyUsageServerAuth, x509.ExtKeyUsageCodeSigning},
+		IsCA:                true,
+		CertificateTemplate: "SubCA",
+	}, key, "policy.example.com", "10.0.0.1")
+	c.Assert(err, IsNil)
+}
+
+func (s *IssuancePolicySuite) sign(c *C, policy *IssuancePolicy) *x509.Certificate {
+	cert, err := SignCertificate(s.csr, s.ca, s.caKey, SigningParameters{
+		NotBefore: CertificateNotBefore(),
+		NotAfter:  CertificateNotAfter(0, s.ca),
+		Policy:    policy,
+	})
+	c.Assert(err, IsNil)
+	return cert
+}
+
+func hasExtension(cert *x509.Certificate, oid asn1.ObjectIdentifier) bool {
+	for _, ext := range cert.Extensions {
+		if ext.Id.Equal(oid) {
+			return true
+		}
+	}
+	return false
+}
+
+func (s *IssuancePolicySuite) TestDefaultPolicy(c *C) {
+	cert := s.sign(c, nil)
+	c.Check(cert.IsCA, Equals, false)
+	c.Check(cert.BasicConstraintsValid, Equals, true)
+	// Certificate signing is removed from a certificate which is not a CA.
+	c.Check(cert.KeyUsage, Equals, x509.KeyUsageDigitalSignature)
+	c.Check(cert.ExtKeyUsage, DeepEquals, []x509.ExtKeyUsage{x509.ExtKeyUsageServerAuth, x509.ExtKeyUsageCodeSigning})
+	c.Check(cert.DNSNames, DeepEquals, []string{"policy.example.com"})
+	c.Check(cert.IPAddresses, HasLen, 1)
+	c.Check(hasExtension(cert, oidExtensionCertificateType), Equals, false)
+}
+
+func (s *IssuancePolicySuite) TestPermissivePolicy(c *C) {
+	cert := s.sign(c, PermissiveIssuancePolicy())
+	c.Check(cert.IsCA, Equals, true)
+	c.Check(cert.KeyUsage, Equals, x509.KeyUsageDigitalSignature|x509.KeyUsageCertSign)
+	c.Check(hasExtension(cert, oidExtensionCertificateType), Equals, true)
+}
+
+func (s *IssuancePolicySuite) TestRestrictAndOverride(c *C) {
+	templateExt, err := CertificateTypeExtension{Name: "WebServer"}.Marshal()
+	c.Assert(err, IsNil)
+
+	cert := s.sign(c, &IssuancePolicy{
+		BasicConstraints:     ExtensionPolicyDrop,
+		KeyUsage:             ExtensionPolicyAllow,
+		PermittedKeyUsage:    x509.KeyUsageDigitalSignature | x509.KeyUsageKeyEncipherment,
+		ExtKeyUsage:          ExtensionPolicyAllow,
+		PermittedExtKeyUsage: []x509.ExtKeyUsage{x509.ExtKeyUsageServerAuth, x509.ExtKeyUsageClientAuth},
+		SubjectAltNames:      ExtensionPolicyOverride,
+		DNSNames:             []string{"issuer-chosen.example.com"},
+		UnknownExtensions:    ExtensionPolicyAllow,
+		ExtraExtensions:      []pkix.Extension{templateExt},
+	})
+	c.Check(cert.BasicConstraintsValid, Equals, false)
+	c.Check(cert.KeyUsage, Equals, x509.KeyUsageDigitalSignature)
+	c.Check(cert.ExtKeyUsage, DeepEquals, []x509.ExtKeyUsage{x509.ExtKeyUsageServerAuth})
+	c.Check(cert.DNSNames, DeepEquals, []string{"issuer-chosen.example.com"})
+	c.Check(cert.IPAddresses, HasLen, 0)
+
+	var found int
+	for _, ext := range cert.Extensions {
+		if ext.Id.Equal(oidExtensionCertificateType) {
+			found++
+			r := CertificateTypeExtension{}
+			c.Assert(r.Unmarshal(ext), IsNil)
+			c.Check(r.Name, Equals, "WebServer")
+		}
+	}
+	c.Check(found, Equals, 1)
+}
+
+func (s *IssuancePolicySuite) TestPerExtensionPolicy(c *C) {
+	policy := DefaultIssuancePolicy()
+	policy.Extensions = map[string]ExtensionPolicy{oidExtensionCertificateType.String(): ExtensionPolicyAllow}
+	cert := s.sign(c, policy)
+	c.Check(hasExtension(cert, oidExtensionCertificateType), Equals, true)
+	c.Check(cert.IsCA, Equals, false)
+}
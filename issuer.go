@@ -0,0 +1,162 @@
+package certutils
+
+import (
+	"context"
+	"crypto/tls"
+	"crypto/x509"
+	"crypto/x509/pkix"
+	"errors"
+	"fmt"
+	"time"
+)
+
+// ErrUnknownIssuanceProfile is returned by an Issuer asked for a profile it does not have.
+type ErrUnknownIssuanceProfile struct {
+	Name string
+}
+
+func (e ErrUnknownIssuanceProfile) Error() string {
+	return fmt.Sprintf("unknown issuance profile: %q", e.Name)
+}
+
+// IssuanceProfile is what the requester asks of an Issuer beyond the CSR itself.
+type IssuanceProfile struct {
+	// Name selects a profile configured at the issuer, such as an ADCS certificate template.
+	// Empty selects the issuer's default.
+	Name string
+	// NotBefore and NotAfter request a validity period. Zero values leave it to the issuer.
+	NotBefore time.Time
+	NotAfter  time.Time
+}
+
+// Issuer signs certificate requests, whether with a key held in process, by a remote CA, or
+// through an enrollment protocol.
+type Issuer interface {
+	// Issue returns the issued certificate followed by the certificates of its issuers.
+	Issue(ctx context.Context, csr *x509.CertificateRequest, profile IssuanceProfile) ([]*x509.Certificate, error)
+}
+
+// IssuerFunc adapts a function to an Issuer.
+type IssuerFunc func(ctx context.Context, csr *x509.CertificateRequest, profile IssuanceProfile) ([]*x509.Certificate, error)
+
+// Issue implements Issuer.
+func (f IssuerFunc) Issue(ctx context.Context, csr *x509.CertificateRequest, profile IssuanceProfile) ([]*x509.Certificate, error) {
+	return f(ctx, csr, profile)
+}
+
+// LocalIssuer is an Issuer which signs with a CertificateAuthority held in process.
+type LocalIssuer struct {
+	Authority *CertificateAuthority
+	// Chain follows the authority's certificate in issued chains, for an intermediate
+	// authority whose issuers should be sent to clients.
+	Chain []*x509.Certificate
+	// Parameters are used for the default profile. A zero NotBefore or NotAfter is replaced
+	// with CertificateNotBefore and CertificateNotAfter constrained to the authority.
+	Parameters SigningParameters
+	// Profiles are used for named profiles.
+	Profiles map[string]SigningParameters
+}
+
+// NewLocalIssuer returns a LocalIssuer for the authority with default parameters.
+func NewLocalIssuer(authority *CertificateAuthority) *LocalIssuer {
+	return &LocalIssuer{Authority: authority}
+}
+
+// Issue implements Issuer.
+func (i *LocalIssuer) Issue(ctx context.Context, csr *x509.CertificateRequest, profile IssuanceProfile) ([]*x509.Certificate, error) {
+	if err := ctx.Err(); err != nil {
+		return nil, err
+	}
+
+	parameters := i.Parameters
+	if profile.Name != "" {
+		var found bool
+		if parameters, found = i.Profiles[profile.Name]; !found {
+			return nil, &ErrUnknownIssuanceProfile{Name: profile.Name}
+		}
+	}
+	if !profile.NotBefore.IsZero() {
+		parameters.NotBefore = profile.NotBefore
+	}
+	if !profile.NotAfter.IsZero() {
+		parameters.NotAfter = profile.NotAfter
+	}
+	if parameters.NotBefore.IsZero() {
+		parameters.NotBefore = CertificateNotBefore()
+	}
+	if parameters.NotAfter.IsZero() {
+		parameters.NotAfter = CertificateNotAfter(0, i.Authority.Certificate)
+	}
+
+	certificate, err := i.Authority.Sign(csr, parameters)
+	if err != nil {
+		return nil, err
+	}
+	if err := VerifyKeyIdentifierChain(certificate, i.Authority.Certificate); err != nil {
+		return nil, err
+	}
+	return append([]*x509.Certificate{certificate, i.Authority.Certificate}, i.Chain...), nil
+}
+
+// IssueTLSCertificate generates a key and a request for the given hosts and has it signed by
+// issuer. If subject has no common name the first host is used.
+func IssueTLSCertificate(ctx context.Context, issuer Issuer, profile IssuanceProfile, keyType PrivateKeyType,
+	subject pkix.Name, parameters CSRParameters, hosts ...string) (*tls.Certificate, error) {
+	key, err := GeneratePrivateKey(keyType)
+	if err != nil {
+		return nil, err
+	}
+	csr, err := GenerateCSR(subject, parameters, key, hosts...)
+	if err != nil {
+		return nil, err
+	}
+
+	chain, err := issuer.Issue(ctx, csr, profile)
+	if err != nil {
+		return nil, err
+	}
+	if len(chain) == 0 {
+		return nil, errors.New("issuer returned no certificate")
+	}
+	if err := CheckKeyMatchesCertificate(key, chain[0]); err != nil {
+		return nil, err
+	}
+
+	certificate := &tls.Certificate{PrivateKey: key, Leaf: chain[0]}
+	for _, issued := range chain {
+		certificate.Certificate = append(certificate.Certificate, issued.Raw)
+	}
+	return certificate, nil
+}
+
+// IssuerRenewFunc returns a RenewFunc which requests a certificate like the current one, with
+// a new key of the same type, from issuer.
+func IssuerRenewFunc(issuer Issuer, profile IssuanceProfile) RenewFunc {
+	return func(ctx context.Context, current *tls.Certificate) (*tls.Certificate, error) {
+		current, err := withLeaf(current)
+		if err != nil {
+			return nil, err
+		}
+		keyType, err := GetPrivateKeyType(current.PrivateKey)
+		if err != nil {
+			return nil, err
+		}
+
+		leaf := current.Leaf
+		hosts := append([]string{}, leaf.DNSNames...)
+		for _, ip := range leaf.IPAddresses {
+			hosts = append(hosts, ip.String())
+		}
+		for _, uri := range leaf.URIs {
+			hosts = append(hosts, uri.String())
+		}
+		hosts = append(hosts, leaf.EmailAddresses...)
+
+		subject := leaf.Subject
+		subject.Names = nil
+		return IssueTLSCertificate(ctx, issuer, profile, keyType, subject, CSRParameters{
+			KeyUsage:    leaf.KeyUsage,
+			ExtKeyUsage: leaf.ExtKeyUsage,
+		}, hosts...)
+	}
+}
@@ -0,0 +1,140 @@
+package certutils
+
+import (
+	"context"
+	"crypto/x509"
+	"crypto/x509/pkix"
+	"errors"
+	"time"
+
+	. "gopkg.in/check.v1"
+)
+
+type IssuerSuite struct {
+}
+
+var _ = Suite(&IssuerSuite{})
+
+func newTestLocalIssuer(c *C) *LocalIssuer {
+	ca, caKey := newTestCA(c, PrivateKeyTypeEcp256)
+	return NewLocalIssuer(&CertificateAuthority{
+		Certificate: ca,
+		Key:         caKey,
+		Endpoints:   AuthorityEndpoints{OCSPServers: []string{"http://ocsp.example.com"}},
+	})
+}
+
+func (s *IssuerSuite) TestLocalIssuerProfiles(c *C) {
+	issuer := newTestLocalIssuer(c)
+	shortLived := CertificateNotBefore().Add(24 * time.Hour).UTC().Truncate(time.Second)
+	issuer.Profiles = map[string]SigningParameters{
+		"short": {NotAfter: shortLived},
+	}
+	ctx := context.Background()
+
+	certificate, err := IssueTLSCertificate(ctx, issuer, IssuanceProfile{}, PrivateKeyTypeEcp256, pkix.Name{},
+		CSRParameters{ExtKeyUsage: []x509.ExtKeyUsage{x509.ExtKeyUsageServerAuth}}, "www.example.com", "192.0.2.1")
+	c.Assert(err, IsNil)
+	c.Check(certificate.Leaf.Subject.CommonName, Equals, "www.example.com")
+	c.Check(certificate.Leaf.OCSPServer, DeepEquals, []string{"http://ocsp.example.com"})
+	c.Check(certificate.Leaf.NotAfter.After(shortLived.Add(24*time.Hour)), Equals, true)
+	c.Check(certificate.Certificate, DeepEquals, [][]byte{certificate.Leaf.Raw, issuer.Authority.Certificate.Raw})
+
+	certificate, err = IssueTLSCertificate(ctx, issuer, IssuanceProfile{Name: "short"}, PrivateKeyTypeEcp256, pkix.Name{},
+		CSRParameters{}, "www.example.com")
+	c.Assert(err, IsNil)
+	c.Check(certificate.Leaf.NotAfter, Equals, shortLived)
+
+	// The requested validity overrides the profile.
+	requested := shortLived.Add(-time.Hour)
+	certificate, err = IssueTLSCertificate(ctx, issuer, IssuanceProfile{Name: "short", NotAfter: requested}, PrivateKeyTypeEcp256,
+		pkix.Name{}, CSRParameters{}, "www.example.com")
+	c.Assert(err, IsNil)
+	c.Check(certificate.Leaf.NotAfter, Equals, requested)
+
+	_, err = IssueTLSCertificate(ctx, issuer, IssuanceProfile{Name: "missing"}, PrivateKeyTypeEcp256, pkix.Name{},
+		CSRParameters{}, "www.example.com")
+	c.Check(err, DeepEquals, &ErrUnknownIssuanceProfile{Name: "missing"})
+
+	cancelled, cancel := context.WithCancel(ctx)
+	cancel()
+	_, err = IssueTLSCertificate(cancelled, issuer, IssuanceProfile{}, PrivateKeyTypeEcp256, pkix.Name{},
+		CSRParameters{}, "www.example.com")
+	c.Check(err, Equals, context.Canceled)
+}
+
+func (s *IssuerSuite) TestIntermediateChain(c *C) {
+	root := newTestLocalIssuer(c)
+	intermediateKey, err := GeneratePrivateKey(PrivateKeyTypeEcp256)
+	c.Assert(err, IsNil)
+	intermediate, err := CreateIntermediateCA(pkix.Name{CommonName: "Issuing CA"}, intermediateKey,
+		root.Authority.Certificate, root.Authority.Key, CAParameters{})
+	c.Assert(err, IsNil)
+
+	issuer := NewLocalIssuer(&CertificateAuthority{Certificate: intermediate, Key: intermediateKey})
+	issuer.Chain = []*x509.Certificate{root.Authority.Certificate}
+	certificate, err := IssueTLSCertificate(context.Background(), issuer, IssuanceProfile{}, PrivateKeyTypeEcp256,
+		pkix.Name{}, CSRParameters{}, "www.example.com")
+	c.Assert(err, IsNil)
+	c.Check(certificate.Certificate, DeepEquals, [][]byte{certificate.Leaf.Raw, intermediate.Raw, root.Authority.Certificate.Raw})
+}
+
+func (s *IssuerSuite) TestRemoteIssuer(c *C) {
+	local := newTestLocalIssuer(c)
+	var requests []*x509.CertificateRequest
+	remote := IssuerFunc(func(ctx context.Context, csr *x509.CertificateRequest, profile IssuanceProfile) ([]*x509.Certificate, error) {
+		requests = append(requests, csr)
+		if profile.Name == "offline" {
+			return nil, errors.New("enrollment server unavailable")
+		}
+		return local.Issue(ctx, csr, profile)
+	})
+
+	certificate, err := IssueTLSCertificate(context.Background(), remote, IssuanceProfile{}, PrivateKeyTypeEcp384,
+		pkix.Name{Organization: []string{"Example"}}, CSRParameters{}, "www.example.com")
+	c.Assert(err, IsNil)
+	c.Assert(requests, HasLen, 1)
+	c.Check(requests[0].Subject.Organization, DeepEquals, []string{"Example"})
+	keyType, err := GetPrivateKeyType(certificate.PrivateKey)
+	c.Assert(err, IsNil)
+	c.Check(keyType, Equals, PrivateKeyTypeEcp384)
+
+	_, err = IssueTLSCertificate(context.Background(), remote, IssuanceProfile{Name: "offline"}, PrivateKeyTypeEcp256,
+		pkix.Name{}, CSRParameters{}, "www.example.com")
+	c.Check(err, ErrorMatches, "enrollment server unavailable")
+
+	// A certificate for some other key is refused.
+	wrong := IssuerFunc(func(ctx context.Context, _ *x509.CertificateRequest, profile IssuanceProfile) ([]*x509.Certificate, error) {
+		return []*x509.Certificate{certificate.Leaf}, nil
+	})
+	_, err = IssueTLSCertificate(context.Background(), wrong, IssuanceProfile{}, PrivateKeyTypeEcp256,
+		pkix.Name{}, CSRParameters{}, "www.example.com")
+	c.Check(err, Equals, ErrKeyMismatch)
+}
+
+func (s *IssuerSuite) TestRenewal(c *C) {
+	issuer := newTestLocalIssuer(c)
+	current, err := IssueTLSCertificate(context.Background(), issuer, IssuanceProfile{}, PrivateKeyTypeEd25519,
+		pkix.Name{}, CSRParameters{
+			KeyUsage:    x509.KeyUsageDigitalSignature,
+			ExtKeyUsage: []x509.ExtKeyUsage{x509.ExtKeyUsageClientAuth},
+		}, "client.example.com", "192.0.2.1", "spiffe://example.com/client", "client@example.com")
+	c.Assert(err, IsNil)
+
+	manager := NewRenewalManager()
+	c.Assert(manager.Add("client", current, IssuerRenewFunc(issuer, IssuanceProfile{})), IsNil)
+	c.Assert(manager.Renew(context.Background(), "client"), IsNil)
+
+	renewed := manager.Certificate("client")
+	c.Check(renewed.Leaf.SerialNumber.Cmp(current.Leaf.SerialNumber), Not(Equals), 0)
+	c.Check(publicKeysEqual(renewed.Leaf.PublicKey, current.Leaf.PublicKey), Equals, false)
+	keyType, err := GetPrivateKeyType(renewed.PrivateKey)
+	c.Assert(err, IsNil)
+	c.Check(keyType, Equals, PrivateKeyTypeEd25519)
+	c.Check(renewed.Leaf.Subject.String(), Equals, current.Leaf.Subject.String())
+	c.Check(renewed.Leaf.DNSNames, DeepEquals, current.Leaf.DNSNames)
+	c.Check(renewed.Leaf.IPAddresses, DeepEquals, current.Leaf.IPAddresses)
+	c.Check(renewed.Leaf.URIs, DeepEquals, current.Leaf.URIs)
+	c.Check(renewed.Leaf.EmailAddresses, DeepEquals, current.Leaf.EmailAddresses)
+	c.Check(renewed.Leaf.ExtKeyUsage, DeepEquals, []x509.ExtKeyUsage{x509.ExtKeyUsageClientAuth})
+}
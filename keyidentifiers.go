@@ -0,0 +1,95 @@
+package certutils
+
+import (
+	"bytes"
+	"crypto"
+	"crypto/sha1"
+	"crypto/sha256"
+	"crypto/x509"
+	"crypto/x509/pkix"
+	"encoding/asn1"
+	"fmt"
+)
+
+// KeyIdentifierMethod selects how a subject key identifier is derived from a public key.
+type KeyIdentifierMethod int
+
+const (
+	// KeyIdentifierRFC5280SHA1 is the SHA-1 hash of the subjectPublicKey BIT STRING
+	// (RFC 5280 section 4.2.1.2, method 1).
+	KeyIdentifierRFC5280SHA1 KeyIdentifierMethod = iota
+	// KeyIdentifierRFC7093SHA256 is the leftmost 160 bits of the SHA-256 hash of the
+	// subjectPublicKey BIT STRING (RFC 7093 section 2, method 1).
+	KeyIdentifierRFC7093SHA256
+)
+
+// ErrKeyIdentifierMismatch is returned when a certificate's authority key identifier does
+// not match the subject key identifier of its issuer.
+type ErrKeyIdentifierMismatch struct {
+	Subject string
+	Issuer  string
+}
+
+func (e ErrKeyIdentifierMismatch) Error() string {
+	return fmt.Sprintf("authority key identifier of %q does not match subject key identifier of issuer %q",
+		e.Subject, e.Issuer)
+}
+
+type subjectPublicKeyInfo struct {
+	Algorithm pkix.AlgorithmIdentifier
+	PublicKey asn1.BitString
+}
+
+// ComputeSubjectKeyID derives a subject key identifier for the public key.
+func ComputeSubjectKeyID(pub crypto.PublicKey, method KeyIdentifierMethod) ([]byte, error) {
+	der, err := x509.MarshalPKIXPublicKey(pub)
+	if err != nil {
+		return nil, err
+	}
+	var spki subjectPublicKeyInfo
+	if _, err := asn1.Unmarshal(der, &spki); err != nil {
+		return nil, err
+	}
+
+	switch method {
+	case KeyIdentifierRFC5280SHA1:
+		digest := sha1.Sum(spki.PublicKey.Bytes)
+		return digest[:], nil
+	case KeyIdentifierRFC7093SHA256:
+		digest := sha256.Sum256(spki.PublicKey.Bytes)
+		return digest[:20], nil
+	default:
+		return nil, fmt.Errorf("unknown key identifier method: %d", method)
+	}
+}
+
+// VerifyKeyIdentifierChain checks that each certificate's authority key identifier matches
+// the subject key identifier of the certificate following it. An issuer without a subject key
+// identifier is matched against those computed from its public key, as SignCertificate does.
+// The chain must be ordered from leaf to root.
+func VerifyKeyIdentifierChain(chain ...*x509.Certificate) error {
+	for i := 0; i+1 < len(chain); i++ {
+		subject, issuer := chain[i], chain[i+1]
+		if len(subject.AuthorityKeyId) == 0 || !keyIdentifierMatches(subject.AuthorityKeyId, issuer) {
+			return &ErrKeyIdentifierMismatch{
+				Subject: subject.Subject.String(),
+				Issuer:  issuer.Subject.String(),
+			}
+		}
+	}
+	return nil
+}
+
+// keyIdentifierMatches returns true if authorityKeyID identifies the issuer.
+func keyIdentifierMatches(authorityKeyID []byte, issuer *x509.Certificate) bool {
+	if len(issuer.SubjectKeyId) > 0 {
+		return bytes.Equal(authorityKeyID, issuer.SubjectKeyId)
+	}
+	for _, method := range []KeyIdentifierMethod{KeyIdentifierRFC5280SHA1, KeyIdentifierRFC7093SHA256} {
+		computed, err := ComputeSubjectKeyID(issuer.PublicKey, method)
+		if err == nil && bytes.Equal(authorityKeyID, computed) {
+			return true
+		}
+	}
+	return false
+}
@@ -0,0 +1,74 @@
+package certutils
+
+import (
+	"crypto/x509"
+	"crypto/x509/pkix"
+
+	. "gopkg.in/check.v1"
+)
+
+type KeyIdentifierSuite struct {
+}
+
+var _ = Suite(&KeyIdentifierSuite{})
+
+func (s *KeyIdentifierSuite) TestSubjectAndAuthorityKeyIdentifiers(c *C) {
+	ca, caKey := newTestCA(c, PrivateKeyTypeEcp256)
+	otherCA, _ := newTestCA(c, PrivateKeyTypeEcp256)
+
+	caKeyID, err := ComputeSubjectKeyID(caKey.Public(), KeyIdentifierRFC5280SHA1)
+	c.Assert(err, IsNil)
+	c.Assert(ca.SubjectKeyId, DeepEquals, caKeyID)
+	c.Assert(ca.AuthorityKeyId, DeepEquals, caKeyID)
+
+	key, err := GeneratePrivateKey(PrivateKeyTypeEcp256)
+	c.Assert(err, IsNil)
+	csr, err := GenerateCSR(pkix.Name{}, CSRParameters{KeyUsage: x509.KeyUsageDigitalSignature}, key, "ski.example.com")
+	c.Assert(err, IsNil)
+
+	leaf, err := SignCertificate(csr, ca, caKey, SigningParameters{
+		NotBefore:           CertificateNotBefore(),
+		NotAfter:            CertificateNotAfter(0, ca),
+		KeyIdentifierMethod: KeyIdentifierRFC7093SHA256,
+	})
+	c.Assert(err, IsNil)
+
+	leafKeyID, err := ComputeSubjectKeyID(key.Public(), KeyIdentifierRFC7093SHA256)
+	c.Assert(err, IsNil)
+	c.Check(leafKeyID, HasLen, 20)
+	c.Check(leaf.SubjectKeyId, DeepEquals, leafKeyID)
+	c.Check(leaf.AuthorityKeyId, DeepEquals, ca.SubjectKeyId)
+
+	c.Check(VerifyKeyIdentifierChain(leaf, ca), IsNil)
+	c.Check(VerifyKeyIdentifierChain(leaf, otherCA), FitsTypeOf, &ErrKeyIdentifierMismatch{})
+}
+
+func (s *KeyIdentifierSuite) TestIssuerWithoutSubjectKeyIdentifier(c *C) {
+	// Older CA certificates may have no subject key identifier.
+	ca, caKey := newTestCA(c, PrivateKeyTypeEcp256)
+	legacyCA := *ca
+	legacyCA.SubjectKeyId = nil
+
+	for _, method := range []KeyIdentifierMethod{KeyIdentifierRFC5280SHA1, KeyIdentifierRFC7093SHA256} {
+		key, err := GeneratePrivateKey(PrivateKeyTypeEcp256)
+		c.Assert(err, IsNil)
+		csr, err := GenerateCSR(pkix.Name{}, CSRParameters{KeyUsage: x509.KeyUsageDigitalSignature}, key, "ski.example.com")
+		c.Assert(err, IsNil)
+		leaf, err := SignCertificate(csr, &legacyCA, caKey, SigningParameters{
+			NotBefore:           CertificateNotBefore(),
+			NotAfter:            CertificateNotAfter(0, ca),
+			KeyIdentifierMethod: method,
+		})
+		c.Assert(err, IsNil)
+		c.Check(VerifyKeyIdentifierChain(leaf, &legacyCA), IsNil)
+	}
+
+	otherCA, _ := newTestCA(c, PrivateKeyTypeEcp256)
+	otherCA.SubjectKeyId = nil
+	leaf := RequestTLSCertificate(&legacyCA, caKey, SigningParameters{
+		NotBefore: CertificateNotBefore(),
+		NotAfter:  CertificateNotAfter(0, ca),
+	}, PrivateKeyTypeEcp256, "ski.example.com")
+	c.Assert(leaf, NotNil)
+	c.Check(VerifyKeyIdentifierChain(leaf.Leaf, otherCA), FitsTypeOf, &ErrKeyIdentifierMismatch{})
+}
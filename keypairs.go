@@ -0,0 +1,283 @@
+package certutils
+
+import (
+	"bytes"
+	"crypto/tls"
+	"crypto/x509"
+	"encoding/asn1"
+	"encoding/pem"
+	"errors"
+	"fmt"
+	"path/filepath"
+	"strings"
+
+	"github.com/spf13/afero"
+)
+
+// The file names of a Kubernetes TLS secret, as read by LoadKeyPair from a directory.
+const (
+	KubernetesTLSCertFile = "tls.crt"
+	KubernetesTLSKeyFile  = "tls.key"
+	KubernetesCAFile      = "ca.crt"
+)
+
+var ErrNoCertificate = errors.New("no certificate found")
+var ErrNoPrivateKey = errors.New("no private key found")
+var ErrMultiplePrivateKeys = errors.New("more than one private key found")
+
+// ErrKeyPairMismatch is returned when the private key matches none of the certificates it
+// was loaded with. It wraps ErrKeyMismatch.
+type ErrKeyPairMismatch struct {
+	CertFile string
+	KeyFile  string
+	// Subjects are the subjects of the certificates which were checked.
+	Subjects []string
+}
+
+func (e ErrKeyPairMismatch) Error() string {
+	return fmt.Sprintf("private key in %s does not match any certificate in %s: %s",
+		e.KeyFile, e.CertFile, strings.Join(e.Subjects, "; "))
+}
+
+func (e ErrKeyPairMismatch) Unwrap() error {
+	return ErrKeyMismatch
+}
+
+// KeyPair is a certificate and private key with the rest of the certificates loaded with
+// them sorted into intermediates and trust anchors.
+type KeyPair struct {
+	// Certificate holds the leaf, the intermediates and the private key, ready for use in a
+	// tls.Config. Trust anchors are not included.
+	Certificate tls.Certificate
+	Leaf        *x509.Certificate
+	// Intermediates are ordered from the leaf's issuer upwards where they form a chain.
+	Intermediates []*x509.Certificate
+	// Roots are the self-signed certificates found alongside the leaf and every certificate
+	// in a separate CA file.
+	Roots []*x509.Certificate
+}
+
+// RootPool returns the trust anchors as a CertPool, or nil if there are none.
+func (k *KeyPair) RootPool() *x509.CertPool {
+	if len(k.Roots) == 0 {
+		return nil
+	}
+	pool := x509.NewCertPool()
+	for _, root := range k.Roots {
+		pool.AddCert(root)
+	}
+	return pool
+}
+
+// LoadKeyPair loads a key pair from path, which may be a directory laid out like a Kubernetes
+// TLS secret (tls.crt, tls.key and an optional ca.crt) or a single file holding both the
+// certificates and the key, such as the combined PEM files used by HAProxy. See ParseKeyPair
+// for the supported encodings.
+func LoadKeyPair(fs afero.Fs, path string, passphrase PassphraseFunc) (*KeyPair, error) {
+	info, err := fs.Stat(path)
+	if err != nil {
+		return nil, err
+	}
+	if !info.IsDir() {
+		return LoadKeyPairFiles(fs, path, "", "", passphrase)
+	}
+
+	caFile := filepath.Join(path, KubernetesCAFile)
+	if exists, err := afero.Exists(fs, caFile); err != nil {
+		return nil, err
+	} else if !exists {
+		caFile = ""
+	}
+	return LoadKeyPairFiles(fs, filepath.Join(path, KubernetesTLSCertFile), filepath.Join(path, KubernetesTLSKeyFile), caFile, passphrase)
+}
+
+// LoadKeyPairFiles loads a key pair from separate files. If keyFile is empty the key is read
+// from certFile. caFile is optional and holds trust anchors.
+func LoadKeyPairFiles(fs afero.Fs, certFile, keyFile, caFile string, passphrase PassphraseFunc) (*KeyPair, error) {
+	certData, err := afero.ReadFile(fs, certFile)
+	if err != nil {
+		return nil, err
+	}
+	var keyData, caData []byte
+	if keyFile != "" && keyFile != certFile {
+		if keyData, err = afero.ReadFile(fs, keyFile); err != nil {
+			return nil, err
+		}
+	} else {
+		keyFile = certFile
+	}
+	if caFile != "" {
+		if caData, err = afero.ReadFile(fs, caFile); err != nil {
+			return nil, err
+		}
+	}
+
+	keyPair, err := ParseKeyPair(certData, keyData, caData, passphrase)
+	var mismatch *ErrKeyPairMismatch
+	if errors.As(err, &mismatch) {
+		mismatch.CertFile = certFile
+		mismatch.KeyFile = keyFile
+	}
+	return keyPair, err
+}
+
+// ParseKeyPair parses a key pair. Each of certData, keyData and caData may be PEM, holding any
+// mix of certificates, PKCS#7 certificate bundles and plain or encrypted private keys, or DER,
+// holding one or more certificates, a PKCS#7 certificate bundle or a single PKCS#1, SEC 1,
+// PKCS#8 or encrypted PKCS#8 key. If keyData is nil the key is taken from certData. The leaf
+// is the certificate matching the key.
+func ParseKeyPair(certData, keyData, caData []byte, passphrase PassphraseFunc) (*KeyPair, error) {
+	certificates, keys, err := parseCertificatesAndKeys(certData, passphrase)
+	if err != nil {
+		return nil, err
+	}
+	if keyData != nil {
+		if _, keys, err = parseCertificatesAndKeys(keyData, passphrase); err != nil {
+			return nil, err
+		}
+	}
+	if len(certificates) == 0 {
+		return nil, ErrNoCertificate
+	}
+	switch {
+	case len(keys) == 0:
+		return nil, ErrNoPrivateKey
+	case len(keys) > 1:
+		return nil, ErrMultiplePrivateKeys
+	}
+	key := keys[0]
+
+	var leaf *x509.Certificate
+	others := []*x509.Certificate{}
+	for _, certificate := range certificates {
+		if leaf == nil && CheckKeyMatchesCertificate(key, certificate) == nil {
+			leaf = certificate
+			continue
+		}
+		others = append(others, certificate)
+	}
+	if leaf == nil {
+		mismatch := &ErrKeyPairMismatch{CertFile: "certificate data", KeyFile: "key data"}
+		for _, certificate := range certificates {
+			mismatch.Subjects = append(mismatch.Subjects, certificate.Subject.String())
+		}
+		return nil, mismatch
+	}
+
+	keyPair := newKeyPair(key, leaf, others)
+
+	if caData != nil {
+		roots, _, err := parseCertificatesAndKeys(caData, nil)
+		if err != nil {
+			return nil, err
+		}
+		keyPair.Roots = append(keyPair.Roots, roots...)
+	}
+	return keyPair, nil
+}
+
+// newKeyPair returns the key pair for key and leaf, sorting others into intermediates and roots.
+func newKeyPair(key interface{}, leaf *x509.Certificate, others []*x509.Certificate) *KeyPair {
+	keyPair := &KeyPair{Leaf: leaf}
+	intermediates := []*x509.Certificate{}
+	for _, certificate := range others {
+		if isSelfSigned(certificate) {
+			keyPair.Roots = append(keyPair.Roots, certificate)
+		} else {
+			intermediates = append(intermediates, certificate)
+		}
+	}
+	keyPair.Intermediates = orderChain(leaf, intermediates)
+
+	keyPair.Certificate = tls.Certificate{
+		Certificate: [][]byte{leaf.Raw},
+		PrivateKey:  key,
+		Leaf:        leaf,
+	}
+	for _, intermediate := range keyPair.Intermediates {
+		keyPair.Certificate.Certificate = append(keyPair.Certificate.Certificate, intermediate.Raw)
+	}
+	return keyPair
+}
+
+// parseCertificatesAndKeys parses PEM or DER data.
+func parseCertificatesAndKeys(data []byte, passphrase PassphraseFunc) ([]*x509.Certificate, []interface{}, error) {
+	if bytes.Contains(data, []byte("-----BEGIN ")) {
+		certificates, err := LoadCertificatesFromPem(data)
+		if err != nil {
+			return nil, nil, err
+		}
+		keys, err := LoadEncryptedPrivateKeysFromPem(data, passphrase)
+		if err != nil {
+			return nil, nil, err
+		}
+		return certificates, keys, nil
+	}
+
+	if certificates, err := x509.ParseCertificates(data); err == nil && len(certificates) > 0 {
+		return certificates, nil, nil
+	}
+	if certificates, err := ParsePKCS7Certificates(data); err == nil {
+		return certificates, nil, nil
+	}
+	key, err := parseDERPrivateKey(data, passphrase)
+	if err != nil {
+		return nil, nil, err
+	}
+	return nil, []interface{}{key}, nil
+}
+
+// parseDERPrivateKey parses a DER encoded private key in any of the formats OpenSSL writes.
+func parseDERPrivateKey(der []byte, passphrase PassphraseFunc) (interface{}, error) {
+	if key, err := x509.ParsePKCS8PrivateKey(der); err == nil {
+		return key, nil
+	}
+	if key, err := x509.ParsePKCS1PrivateKey(der); err == nil {
+		return key, nil
+	}
+	if key, err := x509.ParseECPrivateKey(der); err == nil {
+		return key, nil
+	}
+
+	var info encryptedPrivateKeyInfo
+	if rest, err := asn1.Unmarshal(der, &info); err != nil || len(rest) > 0 {
+		return nil, errors.New("data is not a PEM or DER encoded certificate or private key")
+	}
+	if passphrase == nil {
+		return nil, ErrPassphraseRequired
+	}
+	password, err := passphrase()
+	if err != nil {
+		return nil, err
+	}
+	return DecryptPrivateKey(&pem.Block{Type: EncryptedPrivateKeyBlockType, Bytes: der}, password)
+}
+
+// isSelfSigned reports whether the certificate is signed by its own key.
+func isSelfSigned(certificate *x509.Certificate) bool {
+	return bytes.Equal(certificate.RawIssuer, certificate.RawSubject) &&
+		certificate.CheckSignatureFrom(certificate) == nil
+}
+
+// orderChain orders certificates from the issuer of leaf upwards. Certificates which are not
+// part of the chain follow in their original order.
+func orderChain(leaf *x509.Certificate, certificates []*x509.Certificate) []*x509.Certificate {
+	remaining := append([]*x509.Certificate{}, certificates...)
+	ordered := []*x509.Certificate{}
+	for current := leaf; ; {
+		next := -1
+		for i, candidate := range remaining {
+			if bytes.Equal(current.RawIssuer, candidate.RawSubject) && current.CheckSignatureFrom(candidate) == nil {
+				next = i
+				break
+			}
+		}
+		if next < 0 {
+			break
+		}
+		current = remaining[next]
+		ordered = append(ordered, current)
+		remaining = append(remaining[:next], remaining[next+1:]...)
+	}
+	return append(ordered, remaining...)
+}
@@ -0,0 +1,168 @@
+package certutils
+
+import (
+	"crypto"
+	"crypto/x509"
+	"errors"
+
+	"github.com/spf13/afero"
+	. "gopkg.in/check.v1"
+)
+
+type KeyPairSuite struct {
+}
+
+var _ = Suite(&KeyPairSuite{})
+
+type keyPairTestChain struct {
+	root         *x509.Certificate
+	intermediate *x509.Certificate
+	leaf         *x509.Certificate
+	key          crypto.Signer
+}
+
+func newKeyPairTestChain(c *C) keyPairTestChain {
+	intermediate, intermediateKey, root := newTestIntermediateCA(c)
+	certificate := newTestLeaf(c, intermediate, intermediateKey, PrivateKeyTypeEcp256, "www.example.com")
+	return keyPairTestChain{
+		root:         root,
+		intermediate: intermediate,
+		leaf:         certificate.Leaf,
+		key:          certificate.PrivateKey.(crypto.Signer),
+	}
+}
+
+func (chain keyPairTestChain) check(c *C, keyPair *KeyPair) {
+	c.Check(keyPair.Leaf.Equal(chain.leaf), Equals, true)
+	c.Assert(keyPair.Intermediates, HasLen, 1)
+	c.Check(keyPair.Intermediates[0].Equal(chain.intermediate), Equals, true)
+	c.Assert(keyPair.Roots, HasLen, 1)
+	c.Check(keyPair.Roots[0].Equal(chain.root), Equals, true)
+	c.Check(keyPair.Certificate.Certificate, DeepEquals, [][]byte{chain.leaf.Raw, chain.intermediate.Raw})
+	c.Check(keyPair.Certificate.Leaf, Equals, keyPair.Leaf)
+	c.Check(CheckKeyMatchesCertificate(keyPair.Certificate.PrivateKey, chain.leaf), IsNil)
+}
+
+func (s *KeyPairSuite) TestCombinedPEM(c *C) {
+	fs := afero.NewMemMapFs()
+	chain := newKeyPairTestChain(c)
+
+	// The order of a combined file does not matter.
+	certificates, err := EncodeCertificates(chain.root, chain.leaf)
+	c.Assert(err, IsNil)
+	key, err := EncodeKeys(chain.key)
+	c.Assert(err, IsNil)
+	intermediate, err := EncodeCertificates(chain.intermediate)
+	c.Assert(err, IsNil)
+	combined := append(append(certificates, key...), intermediate...)
+	c.Assert(afero.WriteFile(fs, "/etc/haproxy/site.pem", combined, 0600), IsNil)
+
+	keyPair, err := LoadKeyPair(fs, "/etc/haproxy/site.pem", nil)
+	c.Assert(err, IsNil)
+	chain.check(c, keyPair)
+}
+
+func (s *KeyPairSuite) TestKubernetesDirectory(c *C) {
+	fs := afero.NewMemMapFs()
+	chain := newKeyPairTestChain(c)
+	passphrase := []byte("key pair passphrase")
+
+	certificates, err := EncodeCertificates(chain.leaf, chain.intermediate)
+	c.Assert(err, IsNil)
+	c.Assert(afero.WriteFile(fs, "/secret/tls.crt", certificates, 0644), IsNil)
+	key, err := EncodeEncryptedKeys(passphrase, KeyEncryptionParameters{Iterations: 1000}, chain.key)
+	c.Assert(err, IsNil)
+	c.Assert(afero.WriteFile(fs, "/secret/tls.key", key, 0600), IsNil)
+	root, err := EncodeCertificates(chain.root)
+	c.Assert(err, IsNil)
+	c.Assert(afero.WriteFile(fs, "/secret/ca.crt", root, 0644), IsNil)
+
+	_, err = LoadKeyPair(fs, "/secret", nil)
+	c.Check(errors.Is(err, ErrPassphraseRequired), Equals, true)
+
+	keyPair, err := LoadKeyPair(fs, "/secret", StaticPassphrase(passphrase))
+	c.Assert(err, IsNil)
+	chain.check(c, keyPair)
+
+	verified, err := keyPair.Leaf.Verify(x509.VerifyOptions{
+		Roots:         keyPair.RootPool(),
+		Intermediates: intermediatePool(keyPair.Intermediates),
+	})
+	c.Assert(err, IsNil)
+	c.Check(verified, HasLen, 1)
+
+	// ca.crt is optional.
+	c.Assert(fs.Remove("/secret/ca.crt"), IsNil)
+	keyPair, err = LoadKeyPair(fs, "/secret", StaticPassphrase(passphrase))
+	c.Assert(err, IsNil)
+	c.Check(keyPair.Roots, HasLen, 0)
+	c.Check(keyPair.RootPool(), IsNil)
+}
+
+func (s *KeyPairSuite) TestDER(c *C) {
+	fs := afero.NewMemMapFs()
+	chain := newKeyPairTestChain(c)
+	passphrase := []byte("key pair passphrase")
+
+	certificates := append(append([]byte{}, chain.leaf.Raw...), chain.intermediate.Raw...)
+	c.Assert(afero.WriteFile(fs, "/tls/chain.der", certificates, 0644), IsNil)
+	c.Assert(afero.WriteFile(fs, "/tls/root.der", chain.root.Raw, 0644), IsNil)
+
+	pkcs8, err := x509.MarshalPKCS8PrivateKey(chain.key)
+	c.Assert(err, IsNil)
+	c.Assert(afero.WriteFile(fs, "/tls/key.der", pkcs8, 0600), IsNil)
+	keyPair, err := LoadKeyPairFiles(fs, "/tls/chain.der", "/tls/key.der", "/tls/root.der", nil)
+	c.Assert(err, IsNil)
+	chain.check(c, keyPair)
+
+	encrypted, err := EncryptPrivateKey(chain.key, passphrase, KeyEncryptionParameters{Iterations: 1000})
+	c.Assert(err, IsNil)
+	c.Assert(afero.WriteFile(fs, "/tls/key.der", encrypted.Bytes, 0600), IsNil)
+	_, err = LoadKeyPairFiles(fs, "/tls/chain.der", "/tls/key.der", "/tls/root.der", nil)
+	c.Check(err, Equals, ErrPassphraseRequired)
+	keyPair, err = LoadKeyPairFiles(fs, "/tls/chain.der", "/tls/key.der", "/tls/root.der", StaticPassphrase(passphrase))
+	c.Assert(err, IsNil)
+	chain.check(c, keyPair)
+
+	c.Assert(afero.WriteFile(fs, "/tls/key.der", []byte("not a key"), 0600), IsNil)
+	_, err = LoadKeyPairFiles(fs, "/tls/chain.der", "/tls/key.der", "", nil)
+	c.Check(err, ErrorMatches, "data is not a PEM or DER encoded certificate or private key")
+}
+
+func (s *KeyPairSuite) TestErrors(c *C) {
+	fs := afero.NewMemMapFs()
+	chain := newKeyPairTestChain(c)
+	other, err := GeneratePrivateKey(PrivateKeyTypeEcp256)
+	c.Assert(err, IsNil)
+
+	certificates, err := EncodeCertificates(chain.leaf, chain.intermediate)
+	c.Assert(err, IsNil)
+	c.Assert(afero.WriteFile(fs, "/tls/tls.crt", certificates, 0644), IsNil)
+	otherPEM, err := EncodeKeys(other)
+	c.Assert(err, IsNil)
+	c.Assert(afero.WriteFile(fs, "/tls/tls.key", otherPEM, 0600), IsNil)
+
+	_, err = LoadKeyPairFiles(fs, "/tls/tls.crt", "/tls/tls.key", "", nil)
+	c.Assert(err, FitsTypeOf, &ErrKeyPairMismatch{})
+	c.Check(errors.Is(err, ErrKeyMismatch), Equals, true)
+	c.Check(err, ErrorMatches, "private key in /tls/tls.key does not match any certificate in /tls/tls.crt: CN=www.example.com; CN=Issuing CA")
+
+	_, err = LoadKeyPair(fs, "/tls/tls.crt", nil)
+	c.Check(err, Equals, ErrNoPrivateKey)
+
+	bothKeys, err := EncodeKeys(chain.key, other)
+	c.Assert(err, IsNil)
+	_, err = ParseKeyPair(certificates, bothKeys, nil, nil)
+	c.Check(err, Equals, ErrMultiplePrivateKeys)
+
+	_, err = ParseKeyPair(otherPEM, nil, nil, nil)
+	c.Check(err, Equals, ErrNoCertificate)
+}
+
+func intermediatePool(certificates []*x509.Certificate) *x509.CertPool {
+	pool := x509.NewCertPool()
+	for _, certificate := range certificates {
+		pool.AddCert(certificate)
+	}
+	return pool
+}
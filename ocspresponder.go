@@ -0,0 +1,642 @@
+package certutils
+
+import (
+	"bytes"
+	"context"
+	"crypto"
+	"crypto/sha256"
+	"crypto/x509"
+	"crypto/x509/pkix"
+	"encoding/asn1"
+	"encoding/base64"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"io"
+	"math/big"
+	"net/http"
+	"net/url"
+	"strings"
+	"sync"
+	"time"
+
+	"golang.org/x/crypto/ocsp"
+)
+
+const (
+	// DefaultOCSPValidity is the time between thisUpdate and nextUpdate of an OCSP response.
+	DefaultOCSPValidity = 24 * time.Hour
+	// DefaultOCSPResponderCertificateValidity is the lifetime of a delegated responder
+	// certificate. As it cannot be revoked it is kept short.
+	DefaultOCSPResponderCertificateValidity = 7 * 24 * time.Hour
+	// OCSPRequestContentType and OCSPResponseContentType are the media types of RFC 6960 appendix A.
+	OCSPRequestContentType  = "application/ocsp-request"
+	OCSPResponseContentType = "application/ocsp-response"
+	// maxOCSPRequestSize bounds the body of a POST request.
+	maxOCSPRequestSize = 10 * 1024
+	// maxOCSPNonceLength is the longest nonce RFC 8954 permits.
+	maxOCSPNonceLength = 32
+)
+
+var (
+	oidOCSPBasicResponse = asn1.ObjectIdentifier{1, 3, 6, 1, 5, 5, 7, 48, 1, 1}
+	oidOCSPNonce         = asn1.ObjectIdentifier{1, 3, 6, 1, 5, 5, 7, 48, 1, 2}
+	oidOCSPNoCheck       = asn1.ObjectIdentifier{1, 3, 6, 1, 5, 5, 7, 48, 1, 5}
+)
+
+var ErrNotOCSPResponder = errors.New("responder certificate is not authorized to sign OCSP responses")
+
+// CertificateStatus is the status of a certificate reported by OCSP.
+type CertificateStatus int
+
+const (
+	CertificateStatusGood    = CertificateStatus(ocsp.Good)
+	CertificateStatusRevoked = CertificateStatus(ocsp.Revoked)
+	CertificateStatusUnknown = CertificateStatus(ocsp.Unknown)
+)
+
+// OCSPStatus is the revocation status of a single certificate.
+type OCSPStatus struct {
+	Status CertificateStatus
+	// RevokedAt and Reason are only used when Status is CertificateStatusRevoked.
+	RevokedAt time.Time
+	Reason    RevocationReason
+}
+
+// OCSPStatusSource looks up the status of certificates issued by an authority. A serial
+// the source has no record of should be reported as CertificateStatusUnknown rather than
+// as an error.
+type OCSPStatusSource interface {
+	OCSPStatus(serial *big.Int) (OCSPStatus, error)
+}
+
+// OCSPStatusSourceFunc adapts a function to an OCSPStatusSource.
+type OCSPStatusSourceFunc func(serial *big.Int) (OCSPStatus, error)
+
+// OCSPStatus implements OCSPStatusSource.
+func (f OCSPStatusSourceFunc) OCSPStatus(serial *big.Int) (OCSPStatus, error) {
+	return f(serial)
+}
+
+// IssueOCSPResponderCertificate issues a delegated OCSP responder certificate for key. The
+// certificate carries the OCSPSigning extended key usage and id-pkix-ocsp-nocheck, so it
+// should be short-lived. NotAfter defaults to DefaultOCSPResponderCertificateValidity.
+func IssueOCSPResponderCertificate(subject pkix.Name, key crypto.Signer, authority *x509.Certificate,
+	authorityKey crypto.Signer, parameters SigningParameters) (*x509.Certificate, error) {
+	csr, err := GenerateCSR(subject, CSRParameters{
+		KeyUsage:    x509.KeyUsageDigitalSignature,
+		ExtKeyUsage: []x509.ExtKeyUsage{x509.ExtKeyUsageOCSPSigning},
+	}, key)
+	if err != nil {
+		return nil, err
+	}
+
+	if parameters.NotBefore.IsZero() {
+		parameters.NotBefore = CertificateNotBefore()
+	}
+	if parameters.NotAfter.IsZero() {
+		parameters.NotAfter = CertificateNotAfter(DefaultOCSPResponderCertificateValidity, authority)
+	}
+	parameters.Policy = &IssuancePolicy{
+		BasicConstraints: ExtensionPolicyOverride,
+		KeyUsage:         ExtensionPolicyOverride,
+		KeyUsageValue:    x509.KeyUsageDigitalSignature,
+		ExtKeyUsage:      ExtensionPolicyOverride,
+		ExtKeyUsageValue: []x509.ExtKeyUsage{x509.ExtKeyUsageOCSPSigning},
+		ExtraExtensions: []pkix.Extension{
+			{Id: oidOCSPNoCheck, Value: asn1.NullBytes},
+		},
+	}
+	// Clients do not check the revocation status of a nocheck responder certificate.
+	parameters.Endpoints.CRLDistributionPoints = nil
+	parameters.Endpoints.OCSPServers = nil
+
+	return SignCertificate(csr, authority, authorityKey, parameters)
+}
+
+// OCSPResponder answers RFC 6960 OCSP requests for certificates issued by a single
+// authority. It is an http.Handler supporting both GET and POST requests.
+//
+// Responses to requests without a nonce are cached and re-signed when they come within
+// RefreshBefore of their nextUpdate, either on demand or by Run.
+type OCSPResponder struct {
+	// Issuer is the authority whose certificates the responder reports on.
+	Issuer *x509.Certificate
+	// Certificate is a delegated responder certificate issued by Issuer. If nil, responses
+	// are signed by Issuer directly.
+	Certificate *x509.Certificate
+	// Key signs responses. It belongs to Certificate, or to Issuer if Certificate is nil.
+	Key    crypto.Signer
+	Source OCSPStatusSource
+
+	// Validity is the time between thisUpdate and nextUpdate. Defaults to DefaultOCSPValidity.
+	Validity time.Duration
+	// RefreshBefore is how long before nextUpdate a cached response is re-signed. Defaults to
+	// half of Validity.
+	RefreshBefore time.Duration
+	// SignatureAlgorithm overrides the algorithm responses are signed with.
+	SignatureAlgorithm x509.SignatureAlgorithm
+
+	mu    sync.Mutex
+	cache map[string]*ocspCachedResponse
+}
+
+type ocspCachedResponse struct {
+	der        []byte
+	serial     *big.Int
+	hash       crypto.Hash
+	thisUpdate time.Time
+	nextUpdate time.Time
+}
+
+// NewOCSPResponder returns an OCSPResponder. If responder is not nil it must be a delegated
+// responder certificate issued by issuer and key must be its private key.
+func NewOCSPResponder(issuer *x509.Certificate, responder *x509.Certificate, key crypto.Signer,
+	source OCSPStatusSource) (*OCSPResponder, error) {
+	signer := issuer
+	if responder != nil {
+		if err := responder.CheckSignatureFrom(issuer); err != nil {
+			return nil, err
+		}
+		if !hasExtKeyUsage(responder, x509.ExtKeyUsageOCSPSigning) {
+			return nil, ErrNotOCSPResponder
+		}
+		signer = responder
+	}
+	if !publicKeysEqual(key.Public(), signer.PublicKey) {
+		return nil, errors.New("responder key does not match the signing certificate")
+	}
+
+	return &OCSPResponder{
+		Issuer:      issuer,
+		Certificate: responder,
+		Key:         key,
+		Source:      source,
+	}, nil
+}
+
+func hasExtKeyUsage(certificate *x509.Certificate, usage x509.ExtKeyUsage) bool {
+	for _, u := range certificate.ExtKeyUsage {
+		if u == usage {
+			return true
+		}
+	}
+	return false
+}
+
+func (r *OCSPResponder) validity() time.Duration {
+	if r.Validity <= 0 {
+		return DefaultOCSPValidity
+	}
+	return r.Validity
+}
+
+func (r *OCSPResponder) refreshBefore() time.Duration {
+	if r.RefreshBefore <= 0 {
+		return r.validity() / 2
+	}
+	return r.RefreshBefore
+}
+
+func ocspCacheKey(serial *big.Int, hash crypto.Hash) string {
+	return fmt.Sprintf("%d:%s", hash, serial.Text(16))
+}
+
+// Response returns a DER encoded response for the serial number, identifying the certificate
+// with a SHA-1 CertID. The response comes from the cache if it is still fresh.
+func (r *OCSPResponder) Response(serial *big.Int) ([]byte, error) {
+	response, err := r.cachedResponse(serial, crypto.SHA1, time.Now())
+	if err != nil {
+		return nil, err
+	}
+	return response.der, nil
+}
+
+// Invalidate discards cached responses for the serial number, so the next request reflects
+// any change in its status.
+func (r *OCSPResponder) Invalidate(serial *big.Int) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	for key, response := range r.cache {
+		if response.serial.Cmp(serial) == 0 {
+			delete(r.cache, key)
+		}
+	}
+}
+
+// Refresh re-signs every cached response which is within RefreshBefore of its nextUpdate.
+func (r *OCSPResponder) Refresh() error {
+	now := time.Now()
+	r.mu.Lock()
+	stale := []*ocspCachedResponse{}
+	for _, response := range r.cache {
+		if r.needsRefresh(response, now) {
+			stale = append(stale, response)
+		}
+	}
+	r.mu.Unlock()
+
+	var errs []error
+	for _, response := range stale {
+		if _, err := r.cachedResponse(response.serial, response.hash, now); err != nil {
+			errs = append(errs, err)
+		}
+	}
+	return errors.Join(errs...)
+}
+
+// Run calls Refresh every interval until the context is cancelled. Errors are passed to
+// onError, which may be nil.
+func (r *OCSPResponder) Run(ctx context.Context, interval time.Duration, onError func(error)) {
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case <-ticker.C:
+			if err := r.Refresh(); err != nil && onError != nil {
+				onError(err)
+			}
+		}
+	}
+}
+
+func (r *OCSPResponder) needsRefresh(response *ocspCachedResponse, now time.Time) bool {
+	return !now.Before(response.nextUpdate.Add(-r.refreshBefore()))
+}
+
+// cachedResponse returns a fresh cached response, signing and caching a new one if needed.
+// Responses for unknown certificates are not cached, since the certificate may be issued
+// after the request.
+func (r *OCSPResponder) cachedResponse(serial *big.Int, hash crypto.Hash, now time.Time) (*ocspCachedResponse, error) {
+	key := ocspCacheKey(serial, hash)
+	r.mu.Lock()
+	response, found := r.cache[key]
+	r.mu.Unlock()
+	if found && !r.needsRefresh(response, now) {
+		return response, nil
+	}
+
+	status, err := r.Source.OCSPStatus(serial)
+	if err != nil {
+		return nil, err
+	}
+	response, err = r.sign(serial, hash, status, nil, now)
+	if err != nil {
+		return nil, err
+	}
+	if status.Status != CertificateStatusUnknown {
+		r.mu.Lock()
+		if r.cache == nil {
+			r.cache = make(map[string]*ocspCachedResponse)
+		}
+		r.cache[key] = response
+		r.mu.Unlock()
+	}
+	return response, nil
+}
+
+// Respond answers a DER encoded OCSP request. OCSP level failures are returned as the
+// corresponding OCSP error response rather than as an error.
+func (r *OCSPResponder) Respond(request []byte) []byte {
+	der, _ := r.respond(request)
+	return der
+}
+
+// respond answers the request and also returns the cached response it came from, if any.
+func (r *OCSPResponder) respond(request []byte) ([]byte, *ocspCachedResponse) {
+	req, err := ocsp.ParseRequest(request)
+	if err != nil {
+		return ocsp.MalformedRequestErrorResponse, nil
+	}
+	nonce, err := ocspRequestNonce(request)
+	if err != nil {
+		return ocsp.MalformedRequestErrorResponse, nil
+	}
+	if !r.issuedBy(req) {
+		return ocsp.UnauthorizedErrorResponse, nil
+	}
+
+	now := time.Now()
+	if nonce == nil {
+		response, err := r.cachedResponse(req.SerialNumber, req.HashAlgorithm, now)
+		if err != nil {
+			return ocsp.InternalErrorErrorResponse, nil
+		}
+		return response.der, response
+	}
+
+	status, err := r.Source.OCSPStatus(req.SerialNumber)
+	if err != nil {
+		return ocsp.InternalErrorErrorResponse, nil
+	}
+	response, err := r.sign(req.SerialNumber, req.HashAlgorithm, status, nonce, now)
+	if err != nil {
+		return ocsp.InternalErrorErrorResponse, nil
+	}
+	return response.der, nil
+}
+
+// issuedBy checks the CertID of the request names the responder's issuer.
+func (r *OCSPResponder) issuedBy(req *ocsp.Request) bool {
+	nameHash, keyHash, err := ocspIssuerHashes(r.Issuer, req.HashAlgorithm)
+	if err != nil {
+		return false
+	}
+	return bytes.Equal(nameHash, req.IssuerNameHash) && bytes.Equal(keyHash, req.IssuerKeyHash)
+}
+
+// ServeHTTP implements http.Handler. GET requests carry the base64 encoded request as the
+// final path element and receive RFC 5019 caching headers.
+func (r *OCSPResponder) ServeHTTP(w http.ResponseWriter, req *http.Request) {
+	var request []byte
+	switch req.Method {
+	case http.MethodGet:
+		decoded, err := ocspRequestFromPath(req.URL.EscapedPath())
+		if err != nil {
+			r.writeResponse(w, ocsp.MalformedRequestErrorResponse, nil)
+			return
+		}
+		request = decoded
+	case http.MethodPost:
+		body, err := io.ReadAll(io.LimitReader(req.Body, maxOCSPRequestSize+1))
+		if err != nil || len(body) > maxOCSPRequestSize {
+			r.writeResponse(w, ocsp.MalformedRequestErrorResponse, nil)
+			return
+		}
+		request = body
+	default:
+		w.Header().Set("Allow", "GET, POST")
+		http.Error(w, http.StatusText(http.StatusMethodNotAllowed), http.StatusMethodNotAllowed)
+		return
+	}
+
+	der, cached := r.respond(request)
+	if req.Method != http.MethodGet {
+		cached = nil
+	}
+	r.writeResponse(w, der, cached)
+}
+
+// ocspRequestFromPath extracts the request from the path of a GET request. The responder
+// may be mounted under any prefix, and not every client escapes '/' in the base64 encoding,
+// so successively shorter suffixes of the path are tried until one decodes.
+func ocspRequestFromPath(escapedPath string) ([]byte, error) {
+	path := strings.TrimPrefix(escapedPath, "/")
+	for {
+		encoded, err := url.PathUnescape(path)
+		if err == nil {
+			// Some clients do not escape '+', which then arrives as a space.
+			encoded = strings.ReplaceAll(encoded, " ", "+")
+			if decoded, err := base64.StdEncoding.DecodeString(encoded); err == nil {
+				if _, err := ocsp.ParseRequest(decoded); err == nil {
+					return decoded, nil
+				}
+			}
+		}
+		idx := strings.Index(path, "/")
+		if idx < 0 {
+			return nil, errors.New("no OCSP request found in path")
+		}
+		path = path[idx+1:]
+	}
+}
+
+func (r *OCSPResponder) writeResponse(w http.ResponseWriter, der []byte, cached *ocspCachedResponse) {
+	header := w.Header()
+	header.Set("Content-Type", OCSPResponseContentType)
+	if cached != nil {
+		maxAge := int(time.Until(cached.nextUpdate).Seconds())
+		if maxAge < 0 {
+			maxAge = 0
+		}
+		digest := sha256.Sum256(der)
+		header.Set("Cache-Control", fmt.Sprintf("max-age=%d, public, no-transform, must-revalidate", maxAge))
+		header.Set("Last-Modified", cached.thisUpdate.UTC().Format(http.TimeFormat))
+		header.Set("Expires", cached.nextUpdate.UTC().Format(http.TimeFormat))
+		header.Set("ETag", fmt.Sprintf("%q", hex.EncodeToString(digest[:])))
+	} else {
+		header.Set("Cache-Control", "no-store")
+	}
+	w.WriteHeader(http.StatusOK)
+	_, _ = w.Write(der)
+}
+
+// sign creates a signed response for the serial number.
+func (r *OCSPResponder) sign(serial *big.Int, hash crypto.Hash, status OCSPStatus, nonce []byte,
+	now time.Time) (*ocspCachedResponse, error) {
+	thisUpdate := now.UTC().Truncate(time.Second)
+	nextUpdate := thisUpdate.Add(r.validity())
+
+	nameHash, keyHash, err := ocspIssuerHashes(r.Issuer, hash)
+	if err != nil {
+		return nil, err
+	}
+	single := ocspSingleResponse{
+		CertID: ocspCertID{
+			HashAlgorithm: pkix.AlgorithmIdentifier{Algorithm: ocspHashOIDs[hash], Parameters: asn1Null},
+			NameHash:      nameHash,
+			IssuerKeyHash: keyHash,
+			SerialNumber:  serial,
+		},
+		ThisUpdate: thisUpdate,
+		NextUpdate: nextUpdate,
+	}
+	switch status.Status {
+	case CertificateStatusGood:
+		single.Good = true
+	case CertificateStatusRevoked:
+		single.Revoked = ocspRevokedInfo{
+			RevocationTime: status.RevokedAt.UTC(),
+			Reason:         asn1.Enumerated(status.Reason),
+		}
+	case CertificateStatusUnknown:
+		single.Unknown = true
+	default:
+		return nil, fmt.Errorf("invalid certificate status: %d", status.Status)
+	}
+
+	signer := r.Issuer
+	if r.Certificate != nil {
+		signer = r.Certificate
+	}
+	responderKeyHash, err := ComputeSubjectKeyID(signer.PublicKey, KeyIdentifierRFC5280SHA1)
+	if err != nil {
+		return nil, err
+	}
+	responderID, err := asn1.Marshal(responderKeyHash)
+	if err != nil {
+		return nil, err
+	}
+
+	data := ocspResponseData{
+		ResponderID: asn1.RawValue{Class: asn1.ClassContextSpecific, Tag: 2, IsCompound: true, Bytes: responderID},
+		ProducedAt:  thisUpdate,
+		Responses:   []ocspSingleResponse{single},
+	}
+	if nonce != nil {
+		data.ResponseExtensions = []pkix.Extension{{Id: oidOCSPNonce, Value: nonce}}
+	}
+	tbs, err := asn1.Marshal(data)
+	if err != nil {
+		return nil, err
+	}
+
+	signatureAlgorithm, err := SelectSignatureAlgorithm(r.Key.Public(), r.SignatureAlgorithm)
+	if err != nil {
+		return nil, err
+	}
+	identifier, signature, err := signData(r.Key, signatureAlgorithm, tbs)
+	if err != nil {
+		return nil, err
+	}
+
+	basic := ocspBasicResponse{
+		TBSResponseData:    asn1.RawValue{FullBytes: tbs},
+		SignatureAlgorithm: identifier,
+		Signature:          asn1.BitString{Bytes: signature, BitLength: 8 * len(signature)},
+	}
+	if r.Certificate != nil {
+		basic.Certificates = []asn1.RawValue{{FullBytes: r.Certificate.Raw}}
+	}
+	basicDER, err := asn1.Marshal(basic)
+	if err != nil {
+		return nil, err
+	}
+	der, err := asn1.Marshal(ocspResponse{
+		Status: asn1.Enumerated(ocsp.Success),
+		Bytes:  ocspResponseBytes{ResponseType: oidOCSPBasicResponse, Response: basicDER},
+	})
+	if err != nil {
+		return nil, err
+	}
+
+	return &ocspCachedResponse{
+		der:        der,
+		serial:     new(big.Int).Set(serial),
+		hash:       hash,
+		thisUpdate: thisUpdate,
+		nextUpdate: nextUpdate,
+	}, nil
+}
+
+var ocspHashOIDs = map[crypto.Hash]asn1.ObjectIdentifier{
+	crypto.SHA1:   {1, 3, 14, 3, 2, 26},
+	crypto.SHA256: {2, 16, 840, 1, 101, 3, 4, 2, 1},
+	crypto.SHA384: {2, 16, 840, 1, 101, 3, 4, 2, 2},
+	crypto.SHA512: {2, 16, 840, 1, 101, 3, 4, 2, 3},
+}
+
+// ocspIssuerHashes returns the issuerNameHash and issuerKeyHash of a CertID.
+func ocspIssuerHashes(issuer *x509.Certificate, hash crypto.Hash) ([]byte, []byte, error) {
+	if _, found := ocspHashOIDs[hash]; !found || !hash.Available() {
+		return nil, nil, fmt.Errorf("unsupported OCSP CertID hash: %v", hash)
+	}
+	var spki subjectPublicKeyInfo
+	if _, err := asn1.Unmarshal(issuer.RawSubjectPublicKeyInfo, &spki); err != nil {
+		return nil, nil, err
+	}
+
+	h := hash.New()
+	h.Write(issuer.RawSubject)
+	nameHash := h.Sum(nil)
+	h.Reset()
+	h.Write(spki.PublicKey.RightAlign())
+	return nameHash, h.Sum(nil), nil
+}
+
+// ocspRequestNonce returns the value of the nonce extension of a DER encoded request, or
+// nil if there is none. The value is echoed in the response unchanged, so clients which
+// compare the extensions byte for byte accept it. Nonces must be 1 to 32 bytes long.
+func ocspRequestNonce(request []byte) ([]byte, error) {
+	var req ocspRequest
+	if _, err := asn1.Unmarshal(request, &req); err != nil {
+		return nil, err
+	}
+	for _, ext := range req.TBSRequest.RequestExtensions {
+		if !ext.Id.Equal(oidOCSPNonce) {
+			continue
+		}
+		nonce := ext.Value
+		var wrapped []byte
+		if rest, err := asn1.Unmarshal(ext.Value, &wrapped); err == nil {
+			if len(rest) > 0 {
+				return nil, errors.New("trailing data after OCSP nonce")
+			}
+			nonce = wrapped
+		}
+		// Otherwise the client sent the nonce without the OCTET STRING wrapper.
+		if len(nonce) < 1 || len(nonce) > maxOCSPNonceLength {
+			return nil, fmt.Errorf("OCSP nonce length %d is outside 1 to %d bytes", len(nonce), maxOCSPNonceLength)
+		}
+		return ext.Value, nil
+	}
+	return nil, nil
+}
+
+// The OCSP structures of RFC 6960 section 4. golang.org/x/crypto/ocsp does not support
+// request or response extensions, which nonces require.
+type ocspRequest struct {
+	TBSRequest ocspTBSRequest
+	Signature  asn1.RawValue `asn1:"explicit,tag:0,optional"`
+}
+
+type ocspTBSRequest struct {
+	Version           int           `asn1:"explicit,tag:0,default:0,optional"`
+	RequestorName     asn1.RawValue `asn1:"explicit,tag:1,optional"`
+	RequestList       []ocspSingleRequest
+	RequestExtensions []pkix.Extension `asn1:"explicit,tag:2,optional"`
+}
+
+type ocspSingleRequest struct {
+	CertID     ocspCertID
+	Extensions []pkix.Extension `asn1:"explicit,tag:0,optional"`
+}
+
+type ocspCertID struct {
+	HashAlgorithm pkix.AlgorithmIdentifier
+	NameHash      []byte
+	IssuerKeyHash []byte
+	SerialNumber  *big.Int
+}
+
+type ocspResponse struct {
+	Status asn1.Enumerated
+	Bytes  ocspResponseBytes `asn1:"explicit,tag:0,optional"`
+}
+
+type ocspResponseBytes struct {
+	ResponseType asn1.ObjectIdentifier
+	Response     []byte
+}
+
+type ocspBasicResponse struct {
+	TBSResponseData    asn1.RawValue
+	SignatureAlgorithm pkix.AlgorithmIdentifier
+	Signature          asn1.BitString
+	Certificates       []asn1.RawValue `asn1:"explicit,tag:0,optional"`
+}
+
+type ocspResponseData struct {
+	Version            int `asn1:"explicit,tag:0,default:0,optional"`
+	ResponderID        asn1.RawValue
+	ProducedAt         time.Time `asn1:"generalized"`
+	Responses          []ocspSingleResponse
+	ResponseExtensions []pkix.Extension `asn1:"explicit,tag:1,optional"`
+}
+
+type ocspSingleResponse struct {
+	CertID           ocspCertID
+	Good             asn1.Flag        `asn1:"tag:0,optional"`
+	Revoked          ocspRevokedInfo  `asn1:"tag:1,optional"`
+	Unknown          asn1.Flag        `asn1:"tag:2,optional"`
+	ThisUpdate       time.Time        `asn1:"generalized"`
+	NextUpdate       time.Time        `asn1:"generalized,explicit,tag:0,optional"`
+	SingleExtensions []pkix.Extension `asn1:"explicit,tag:1,optional"`
+}
+
+type ocspRevokedInfo struct {
+	RevocationTime time.Time       `asn1:"generalized"`
+	Reason         asn1.Enumerated `asn1:"explicit,tag:0,optional"`
+}
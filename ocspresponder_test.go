@@ -0,0 +1,285 @@
+package certutils
+
+import (
+	"bytes"
+	"crypto"
+	"crypto/x509"
+	"crypto/x509/pkix"
+	"encoding/asn1"
+	"encoding/base64"
+	"io"
+	"math/big"
+	"net/http"
+	"net/http/httptest"
+	"sync"
+	"time"
+
+	"golang.org/x/crypto/ocsp"
+	. "gopkg.in/check.v1"
+)
+
+type OCSPResponderSuite struct {
+}
+
+var _ = Suite(&OCSPResponderSuite{})
+
+// testStatusSource is an in-memory OCSPStatusSource.
+type testStatusSource struct {
+	mu       sync.Mutex
+	statuses map[string]OCSPStatus
+	lookups  int
+}
+
+func newTestStatusSource() *testStatusSource {
+	return &testStatusSource{statuses: make(map[string]OCSPStatus)}
+}
+
+func (s *testStatusSource) set(serial *big.Int, status OCSPStatus) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.statuses[serial.String()] = status
+}
+
+func (s *testStatusSource) OCSPStatus(serial *big.Int) (OCSPStatus, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.lookups++
+	status, found := s.statuses[serial.String()]
+	if !found {
+		return OCSPStatus{Status: CertificateStatusUnknown}, nil
+	}
+	return status, nil
+}
+
+// ocspNonceRequest builds a request for the certificate carrying a nonce extension.
+func ocspNonceRequest(c *C, cert *x509.Certificate, issuer *x509.Certificate, nonce []byte) []byte {
+	value, err := asn1.Marshal(nonce)
+	c.Assert(err, IsNil)
+	return ocspNonceExtensionRequest(c, cert, issuer, value)
+}
+
+// ocspNonceExtensionRequest builds a request for the certificate whose nonce extension has
+// the given value.
+func ocspNonceExtensionRequest(c *C, cert *x509.Certificate, issuer *x509.Certificate, value []byte) []byte {
+	nameHash, keyHash, err := ocspIssuerHashes(issuer, crypto.SHA256)
+	c.Assert(err, IsNil)
+	der, err := asn1.Marshal(ocspRequest{TBSRequest: ocspTBSRequest{
+		RequestList: []ocspSingleRequest{{CertID: ocspCertID{
+			HashAlgorithm: pkix.AlgorithmIdentifier{Algorithm: ocspHashOIDs[crypto.SHA256], Parameters: asn1Null},
+			NameHash:      nameHash,
+			IssuerKeyHash: keyHash,
+			SerialNumber:  cert.SerialNumber,
+		}}},
+		RequestExtensions: []pkix.Extension{{Id: oidOCSPNonce, Value: value}},
+	}})
+	c.Assert(err, IsNil)
+	return der
+}
+
+// ocspResponseNonce extracts the nonce from the response extensions, which
+// golang.org/x/crypto/ocsp does not expose.
+func ocspResponseNonce(c *C, der []byte) []byte {
+	value := ocspResponseNonceExtension(c, der)
+	if value == nil {
+		return nil
+	}
+	var nonce []byte
+	_, err := asn1.Unmarshal(value, &nonce)
+	c.Assert(err, IsNil)
+	return nonce
+}
+
+// ocspResponseNonceExtension returns the value of the response's nonce extension.
+func ocspResponseNonceExtension(c *C, der []byte) []byte {
+	var response ocspResponse
+	_, err := asn1.Unmarshal(der, &response)
+	c.Assert(err, IsNil)
+	var basic ocspBasicResponse
+	_, err = asn1.Unmarshal(response.Bytes.Response, &basic)
+	c.Assert(err, IsNil)
+	var data ocspResponseData
+	_, err = asn1.Unmarshal(basic.TBSResponseData.FullBytes, &data)
+	c.Assert(err, IsNil)
+	for _, ext := range data.ResponseExtensions {
+		if ext.Id.Equal(oidOCSPNonce) {
+			return ext.Value
+		}
+	}
+	return nil
+}
+
+func (s *OCSPResponderSuite) TestGetSignedByIssuer(c *C) {
+	ca, caKey := newTestCA(c, PrivateKeyTypeEcp256)
+	leaf := newTestLeaf(c, ca, caKey, PrivateKeyTypeEcp256, "leaf.example.com").Leaf
+	source := newTestStatusSource()
+	source.set(leaf.SerialNumber, OCSPStatus{Status: CertificateStatusGood})
+
+	responder, err := NewOCSPResponder(ca, nil, caKey, source)
+	c.Assert(err, IsNil)
+	server := httptest.NewServer(http.StripPrefix("/ocsp", responder))
+	defer server.Close()
+
+	request, err := ocsp.CreateRequest(leaf, ca, nil)
+	c.Assert(err, IsNil)
+	// Leave the encoding unescaped, as some clients do.
+	encoded := base64.StdEncoding.EncodeToString(request)
+	httpResponse, err := http.Get(server.URL + "/ocsp/" + encoded)
+	c.Assert(err, IsNil)
+	defer httpResponse.Body.Close()
+	body, err := io.ReadAll(httpResponse.Body)
+	c.Assert(err, IsNil)
+
+	c.Check(httpResponse.Header.Get("Content-Type"), Equals, OCSPResponseContentType)
+	c.Check(httpResponse.Header.Get("Cache-Control"), Matches, "max-age=[0-9]+, public, no-transform, must-revalidate")
+	c.Check(httpResponse.Header.Get("ETag"), Not(Equals), "")
+
+	response, err := ocsp.ParseResponseForCert(body, leaf, ca)
+	c.Assert(err, IsNil)
+	c.Check(response.Status, Equals, ocsp.Good)
+	c.Check(response.SerialNumber.Cmp(leaf.SerialNumber), Equals, 0)
+	c.Check(response.NextUpdate.Sub(response.ThisUpdate), Equals, DefaultOCSPValidity)
+	c.Check(response.Certificate, IsNil)
+}
+
+func (s *OCSPResponderSuite) TestPostWithDelegatedResponderAndNonce(c *C) {
+	ca, caKey := newTestCA(c, PrivateKeyTypeRsa2048)
+	leaf := newTestLeaf(c, ca, caKey, PrivateKeyTypeEcp256, "leaf.example.com").Leaf
+	revokedAt := time.Now().UTC().Truncate(time.Second)
+	source := newTestStatusSource()
+	source.set(leaf.SerialNumber, OCSPStatus{
+		Status:    CertificateStatusRevoked,
+		RevokedAt: revokedAt,
+		Reason:    RevocationReasonKeyCompromise,
+	})
+
+	responderKey, err := GeneratePrivateKey(PrivateKeyTypeEcp256)
+	c.Assert(err, IsNil)
+	responderCert, err := IssueOCSPResponderCertificate(pkix.Name{CommonName: "OCSP Responder"},
+		responderKey, ca, caKey, SigningParameters{})
+	c.Assert(err, IsNil)
+	c.Check(responderCert.ExtKeyUsage, DeepEquals, []x509.ExtKeyUsage{x509.ExtKeyUsageOCSPSigning})
+	c.Check(hasExtension(responderCert, oidOCSPNoCheck), Equals, true)
+	// A certificate which cannot be revoked is short-lived by default.
+	c.Check(responderCert.NotAfter.After(time.Now().Add(DefaultOCSPResponderCertificateValidity)), Equals, false)
+	c.Check(responderCert.NotAfter.After(time.Now().Add(DefaultOCSPResponderCertificateValidity-time.Hour)), Equals, true)
+
+	_, err = NewOCSPResponder(ca, responderCert, caKey, source)
+	c.Check(err, NotNil)
+	_, err = NewOCSPResponder(ca, leaf, caKey, source)
+	c.Check(err, Equals, ErrNotOCSPResponder)
+
+	responder, err := NewOCSPResponder(ca, responderCert, responderKey, source)
+	c.Assert(err, IsNil)
+	server := httptest.NewServer(responder)
+	defer server.Close()
+
+	nonce := []byte("0123456789abcdef")
+	httpResponse, err := http.Post(server.URL, OCSPRequestContentType,
+		bytes.NewReader(ocspNonceRequest(c, leaf, ca, nonce)))
+	c.Assert(err, IsNil)
+	defer httpResponse.Body.Close()
+	body, err := io.ReadAll(httpResponse.Body)
+	c.Assert(err, IsNil)
+	c.Check(httpResponse.Header.Get("Cache-Control"), Equals, "no-store")
+
+	response, err := ocsp.ParseResponseForCert(body, leaf, ca)
+	c.Assert(err, IsNil)
+	c.Check(response.Status, Equals, ocsp.Revoked)
+	c.Check(response.RevokedAt.Equal(revokedAt), Equals, true)
+	c.Check(response.RevocationReason, Equals, ocsp.KeyCompromise)
+	c.Check(response.IssuerHash, Equals, crypto.SHA256)
+	c.Assert(response.Certificate, NotNil)
+	c.Check(response.Certificate.Equal(responderCert), Equals, true)
+	c.Check(ocspResponseNonce(c, body), DeepEquals, nonce)
+}
+
+func (s *OCSPResponderSuite) TestNonceEchoedUnchanged(c *C) {
+	ca, caKey := newTestCA(c, PrivateKeyTypeEcp256)
+	leaf := newTestLeaf(c, ca, caKey, PrivateKeyTypeEcp256, "leaf.example.com").Leaf
+	source := newTestStatusSource()
+	source.set(leaf.SerialNumber, OCSPStatus{Status: CertificateStatusGood})
+	responder, err := NewOCSPResponder(ca, nil, caKey, source)
+	c.Assert(err, IsNil)
+
+	// A nonce without the OCTET STRING wrapper is returned as sent.
+	unwrapped := []byte("0123456789abcdef")
+	der := responder.Respond(ocspNonceExtensionRequest(c, leaf, ca, unwrapped))
+	_, err = ocsp.ParseResponseForCert(der, leaf, ca)
+	c.Assert(err, IsNil)
+	c.Check(ocspResponseNonceExtension(c, der), DeepEquals, unwrapped)
+
+	wrapped, err := asn1.Marshal(bytes.Repeat([]byte{1}, maxOCSPNonceLength))
+	c.Assert(err, IsNil)
+	der = responder.Respond(ocspNonceExtensionRequest(c, leaf, ca, wrapped))
+	c.Check(ocspResponseNonceExtension(c, der), DeepEquals, wrapped)
+
+	for _, value := range [][]byte{
+		{},
+		append(wrapped[:len(wrapped):len(wrapped)], 0),
+		bytes.Repeat([]byte{1}, maxOCSPNonceLength+1),
+	} {
+		c.Check(responder.Respond(ocspNonceExtensionRequest(c, leaf, ca, value)), DeepEquals, ocsp.MalformedRequestErrorResponse)
+	}
+	tooLong, err := asn1.Marshal(bytes.Repeat([]byte{1}, maxOCSPNonceLength+1))
+	c.Assert(err, IsNil)
+	c.Check(responder.Respond(ocspNonceExtensionRequest(c, leaf, ca, tooLong)), DeepEquals, ocsp.MalformedRequestErrorResponse)
+}
+
+func (s *OCSPResponderSuite) TestErrorResponses(c *C) {
+	ca, caKey := newTestCA(c, PrivateKeyTypeEcp256)
+	otherCA, otherKey := newTestCA(c, PrivateKeyTypeEcp256)
+	leaf := newTestLeaf(c, otherCA, otherKey, PrivateKeyTypeEcp256, "leaf.example.com").Leaf
+
+	responder, err := NewOCSPResponder(ca, nil, caKey, newTestStatusSource())
+	c.Assert(err, IsNil)
+
+	c.Check(responder.Respond([]byte("garbage")), DeepEquals, ocsp.MalformedRequestErrorResponse)
+
+	request, err := ocsp.CreateRequest(leaf, otherCA, nil)
+	c.Assert(err, IsNil)
+	c.Check(responder.Respond(request), DeepEquals, ocsp.UnauthorizedErrorResponse)
+
+	recorder := httptest.NewRecorder()
+	responder.ServeHTTP(recorder, httptest.NewRequest(http.MethodPut, "/", nil))
+	c.Check(recorder.Code, Equals, http.StatusMethodNotAllowed)
+}
+
+func (s *OCSPResponderSuite) TestCacheAndRefresh(c *C) {
+	ca, caKey := newTestCA(c, PrivateKeyTypeEcp256)
+	leaf := newTestLeaf(c, ca, caKey, PrivateKeyTypeEcp256, "leaf.example.com").Leaf
+	source := newTestStatusSource()
+	source.set(leaf.SerialNumber, OCSPStatus{Status: CertificateStatusGood})
+
+	responder, err := NewOCSPResponder(ca, nil, caKey, source)
+	c.Assert(err, IsNil)
+
+	first, err := responder.Response(leaf.SerialNumber)
+	c.Assert(err, IsNil)
+	second, err := responder.Response(leaf.SerialNumber)
+	c.Assert(err, IsNil)
+	c.Check(second, DeepEquals, first)
+	c.Check(source.lookups, Equals, 1)
+
+	// A status change is not visible until the cache is invalidated.
+	source.set(leaf.SerialNumber, OCSPStatus{Status: CertificateStatusRevoked, RevokedAt: time.Now()})
+	responder.Invalidate(leaf.SerialNumber)
+	der, err := responder.Response(leaf.SerialNumber)
+	c.Assert(err, IsNil)
+	response, err := ocsp.ParseResponseForCert(der, leaf, ca)
+	c.Assert(err, IsNil)
+	c.Check(response.Status, Equals, ocsp.Revoked)
+
+	// A response within RefreshBefore of nextUpdate is re-signed by Refresh.
+	responder.RefreshBefore = 2 * DefaultOCSPValidity
+	lookups := source.lookups
+	c.Assert(responder.Refresh(), IsNil)
+	c.Check(source.lookups, Equals, lookups+1)
+
+	// Unknown certificates are never cached.
+	unknown := big.NewInt(12345)
+	_, err = responder.Response(unknown)
+	c.Assert(err, IsNil)
+	_, err = responder.Response(unknown)
+	c.Assert(err, IsNil)
+	c.Check(source.lookups, Equals, lookups+3)
+}
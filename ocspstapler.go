@@ -0,0 +1,216 @@
+package certutils
+
+import (
+	"context"
+	"crypto/tls"
+	"crypto/x509"
+	"errors"
+	"fmt"
+	"sync"
+	"sync/atomic"
+	"time"
+
+	"golang.org/x/crypto/ocsp"
+)
+
+// DefaultStapleRetryInterval is how long an OCSPStapler waits after a failed refresh.
+const DefaultStapleRetryInterval = time.Minute
+
+var ErrNoIssuerCertificate = errors.New("issuer certificate is required")
+
+// OCSPStapler keeps an OCSP response stapled to a tls.Certificate, such as one produced by
+// RequestTLSCertificate. Responses are fetched from the responders in the leaf certificate,
+// verified against the issuer, and refreshed halfway between their thisUpdate and nextUpdate.
+type OCSPStapler struct {
+	// Fetcher retrieves OCSP responses. Defaults to HTTPRevocationFetcher.
+	Fetcher RevocationFetcher
+	// RetryInterval is the delay after a failed refresh, and the least delay after a successful
+	// one. Defaults to DefaultStapleRetryInterval.
+	RetryInterval time.Duration
+
+	base   tls.Certificate
+	leaf   *x509.Certificate
+	issuer *x509.Certificate
+
+	certificate atomic.Pointer[tls.Certificate]
+
+	mu          sync.Mutex
+	nextRefresh time.Time
+}
+
+// NewOCSPStapler returns an OCSPStapler for the certificate. If issuer is nil the second
+// certificate in the chain is used. No response is stapled until Refresh or Run is called.
+func NewOCSPStapler(certificate *tls.Certificate, issuer *x509.Certificate) (*OCSPStapler, error) {
+	if certificate == nil || len(certificate.Certificate) == 0 {
+		return nil, errors.New("certificate has no leaf")
+	}
+	leaf := certificate.Leaf
+	if leaf == nil {
+		var err error
+		if leaf, err = x509.ParseCertificate(certificate.Certificate[0]); err != nil {
+			return nil, err
+		}
+	}
+	if issuer == nil {
+		if len(certificate.Certificate) < 2 {
+			return nil, ErrNoIssuerCertificate
+		}
+		var err error
+		if issuer, err = x509.ParseCertificate(certificate.Certificate[1]); err != nil {
+			return nil, err
+		}
+	}
+	if len(leaf.OCSPServer) == 0 {
+		return nil, errors.New("certificate does not name an OCSP responder")
+	}
+
+	s := &OCSPStapler{
+		Fetcher: HTTPRevocationFetcher{},
+		base:    *certificate,
+		leaf:    leaf,
+		issuer:  issuer,
+	}
+	s.base.Leaf = leaf
+	s.base.OCSPStaple = nil
+	unstapled := s.base
+	s.certificate.Store(&unstapled)
+	return s, nil
+}
+
+func (s *OCSPStapler) fetcher() RevocationFetcher {
+	if s.Fetcher == nil {
+		return HTTPRevocationFetcher{}
+	}
+	return s.Fetcher
+}
+
+func (s *OCSPStapler) retryInterval() time.Duration {
+	if s.RetryInterval <= 0 {
+		return DefaultStapleRetryInterval
+	}
+	return s.RetryInterval
+}
+
+// Certificate returns the certificate with the current staple, if any.
+func (s *OCSPStapler) Certificate() *tls.Certificate {
+	return s.certificate.Load()
+}
+
+// GetCertificate can be used as tls.Config.GetCertificate.
+func (s *OCSPStapler) GetCertificate(*tls.ClientHelloInfo) (*tls.Certificate, error) {
+	return s.Certificate(), nil
+}
+
+// NextRefresh returns when Run will next refresh the staple.
+func (s *OCSPStapler) NextRefresh() time.Time {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	return s.nextRefresh
+}
+
+// Refresh fetches and verifies a new OCSP response and staples it. If the response reports
+// the certificate revoked the staple is removed and an ErrCertificateRevoked is returned. On
+// any other failure the current staple is kept until it expires.
+func (s *OCSPStapler) Refresh(ctx context.Context) error {
+	now := time.Now()
+	response, err := s.fetch(ctx, now)
+	if err != nil {
+		s.mu.Lock()
+		s.nextRefresh = now.Add(s.retryInterval())
+		s.mu.Unlock()
+		s.dropExpired(now)
+		return err
+	}
+
+	status, err := ocspResponseStatus(response)
+	if err == nil && status.Status == CertificateStatusRevoked {
+		err = &ErrCertificateRevoked{
+			Subject:      s.leaf.Subject.String(),
+			SerialNumber: s.leaf.SerialNumber,
+			RevokedAt:    status.RevokedAt,
+			Reason:       status.Reason,
+		}
+	}
+	if err != nil {
+		unstapled := s.base
+		s.certificate.Store(&unstapled)
+		s.mu.Lock()
+		s.nextRefresh = now.Add(s.retryInterval())
+		s.mu.Unlock()
+		return err
+	}
+
+	stapled := s.base
+	stapled.OCSPStaple = response.Raw
+	s.certificate.Store(&stapled)
+
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if response.NextUpdate.IsZero() {
+		s.nextRefresh = now.Add(s.retryInterval())
+	} else {
+		s.nextRefresh = response.ThisUpdate.Add(response.NextUpdate.Sub(response.ThisUpdate) / 2)
+	}
+	// A cached response can already be past its midpoint; wait as after a failure rather
+	// than refreshing in a tight loop.
+	if earliest := now.Add(s.retryInterval()); s.nextRefresh.Before(earliest) {
+		s.nextRefresh = earliest
+	}
+	return nil
+}
+
+// fetch returns the first valid response from the certificate's responders.
+func (s *OCSPStapler) fetch(ctx context.Context, now time.Time) (*ocsp.Response, error) {
+	request, err := ocsp.CreateRequest(s.leaf, s.issuer, nil)
+	if err != nil {
+		return nil, err
+	}
+	var errs []error
+	for _, url := range s.leaf.OCSPServer {
+		der, err := s.fetcher().FetchOCSP(ctx, url, request)
+		if err != nil {
+			errs = append(errs, err)
+			continue
+		}
+		response, err := verifyOCSPResponse(der, s.leaf, s.issuer, now)
+		if err != nil {
+			errs = append(errs, fmt.Errorf("OCSP response from %s: %w", url, err))
+			continue
+		}
+		return response, nil
+	}
+	return nil, errors.Join(errs...)
+}
+
+// dropExpired removes the staple once it has passed its nextUpdate, since clients would
+// reject it.
+func (s *OCSPStapler) dropExpired(now time.Time) {
+	current := s.certificate.Load()
+	if current.OCSPStaple == nil {
+		return
+	}
+	response, err := ocsp.ParseResponseForCert(current.OCSPStaple, s.leaf, s.issuer)
+	if err == nil && (response.NextUpdate.IsZero() || now.Before(response.NextUpdate)) {
+		return
+	}
+	unstapled := s.base
+	s.certificate.CompareAndSwap(current, &unstapled)
+}
+
+// Run refreshes the staple immediately and then whenever NextRefresh is reached, until the
+// context is cancelled. Errors are passed to onError, which may be nil.
+func (s *OCSPStapler) Run(ctx context.Context, onError func(error)) {
+	for {
+		if err := s.Refresh(ctx); err != nil && onError != nil {
+			onError(err)
+		}
+
+		timer := time.NewTimer(time.Until(s.NextRefresh()))
+		select {
+		case <-ctx.Done():
+			timer.Stop()
+			return
+		case <-timer.C:
+		}
+	}
+}
@@ -0,0 +1,141 @@
+package certutils
+
+import (
+	"bytes"
+	"context"
+	"crypto/tls"
+	"time"
+
+	"golang.org/x/crypto/ocsp"
+	. "gopkg.in/check.v1"
+)
+
+type OCSPStaplerSuite struct {
+}
+
+var _ = Suite(&OCSPStaplerSuite{})
+
+func newStapledTestCertificate(c *C, pki *revocationTestPKI) *tls.Certificate {
+	certificate := RequestTLSCertificate(pki.ca.Certificate, pki.ca.Key, SigningParameters{
+		NotBefore: CertificateNotBefore(),
+		NotAfter:  CertificateNotAfter(0, pki.ca.Certificate),
+		Endpoints: pki.ca.Endpoints,
+	}, PrivateKeyTypeEcp256, "stapled.example.com")
+	c.Assert(certificate, NotNil)
+	pki.source.set(certificate.Leaf.SerialNumber, OCSPStatus{Status: CertificateStatusGood})
+	return certificate
+}
+
+func (s *OCSPStaplerSuite) TestRefresh(c *C) {
+	pki := newRevocationTestPKI(c)
+	defer pki.server.Close()
+	certificate := newStapledTestCertificate(c, pki)
+
+	stapler, err := NewOCSPStapler(certificate, nil)
+	c.Assert(err, IsNil)
+	stapler.Fetcher = HTTPRevocationFetcher{Client: pki.server.Client()}
+
+	served, err := stapler.GetCertificate(nil)
+	c.Assert(err, IsNil)
+	c.Check(served.OCSPStaple, IsNil)
+
+	c.Assert(stapler.Refresh(context.Background()), IsNil)
+	served, err = stapler.GetCertificate(nil)
+	c.Assert(err, IsNil)
+	c.Assert(served.OCSPStaple, NotNil)
+	c.Check(served.Certificate, DeepEquals, certificate.Certificate)
+
+	response, err := ocsp.ParseResponseForCert(served.OCSPStaple, certificate.Leaf, pki.ca.Certificate)
+	c.Assert(err, IsNil)
+	c.Check(response.Status, Equals, ocsp.Good)
+	c.Check(stapler.NextRefresh(), Equals, response.ThisUpdate.Add(DefaultOCSPValidity/2))
+
+	// A failed refresh keeps the current staple and retries sooner.
+	stapler.Fetcher = failingFetcher{}
+	c.Check(stapler.Refresh(context.Background()), NotNil)
+	c.Check(stapler.Certificate().OCSPStaple, DeepEquals, served.OCSPStaple)
+	c.Check(stapler.NextRefresh().Before(time.Now().Add(DefaultStapleRetryInterval+time.Second)), Equals, true)
+
+	// A revoked response removes the staple.
+	stapler.Fetcher = HTTPRevocationFetcher{Client: pki.server.Client()}
+	pki.revoke(certificate.Leaf, RevocationReasonKeyCompromise)
+	c.Check(stapler.Refresh(context.Background()), FitsTypeOf, &ErrCertificateRevoked{})
+	c.Check(stapler.Certificate().OCSPStaple, IsNil)
+}
+
+// staleOCSPFetcher serves a fixed OCSP response, as a caching proxy might.
+type staleOCSPFetcher struct {
+	failingFetcher
+	response []byte
+}
+
+func (f staleOCSPFetcher) FetchOCSP(context.Context, string, []byte) ([]byte, error) {
+	return f.response, nil
+}
+
+func (s *OCSPStaplerSuite) TestRefreshPastMidpoint(c *C) {
+	pki := newRevocationTestPKI(c)
+	defer pki.server.Close()
+	certificate := newStapledTestCertificate(c, pki)
+
+	now := time.Now()
+	der, err := ocsp.CreateResponse(pki.ca.Certificate, pki.ca.Certificate, ocsp.Response{
+		Status:       ocsp.Good,
+		SerialNumber: certificate.Leaf.SerialNumber,
+		ThisUpdate:   now.Add(-3 * time.Hour),
+		NextUpdate:   now.Add(time.Hour),
+	}, pki.ca.Key)
+	c.Assert(err, IsNil)
+
+	stapler, err := NewOCSPStapler(certificate, nil)
+	c.Assert(err, IsNil)
+	stapler.Fetcher = staleOCSPFetcher{response: der}
+	c.Assert(stapler.Refresh(context.Background()), IsNil)
+	c.Check(stapler.Certificate().OCSPStaple, DeepEquals, der)
+	c.Check(stapler.NextRefresh().Before(now.Add(DefaultStapleRetryInterval)), Equals, false)
+}
+
+func (s *OCSPStaplerSuite) TestRequiresIssuer(c *C) {
+	pki := newRevocationTestPKI(c)
+	defer pki.server.Close()
+	certificate := newStapledTestCertificate(c, pki)
+
+	leafOnly := *certificate
+	leafOnly.Certificate = leafOnly.Certificate[:1]
+	_, err := NewOCSPStapler(&leafOnly, nil)
+	c.Check(err, Equals, ErrNoIssuerCertificate)
+
+	_, err = NewOCSPStapler(&leafOnly, pki.ca.Certificate)
+	c.Check(err, IsNil)
+}
+
+func (s *OCSPStaplerSuite) TestRunRefreshesInBackground(c *C) {
+	pki := newRevocationTestPKI(c)
+	defer pki.server.Close()
+	pki.responder.Validity = 2 * time.Second
+	certificate := newStapledTestCertificate(c, pki)
+
+	stapler, err := NewOCSPStapler(certificate, nil)
+	c.Assert(err, IsNil)
+	stapler.Fetcher = HTTPRevocationFetcher{Client: pki.server.Client()}
+	// Refreshes are never scheduled sooner than the retry interval.
+	stapler.RetryInterval = 500 * time.Millisecond
+
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+	go stapler.Run(ctx, nil)
+
+	waitForStaple := func(previous []byte) []byte {
+		deadline := time.Now().Add(5 * time.Second)
+		for time.Now().Before(deadline) {
+			if staple := stapler.Certificate().OCSPStaple; staple != nil && !bytes.Equal(staple, previous) {
+				return staple
+			}
+			time.Sleep(20 * time.Millisecond)
+		}
+		c.Fatal("staple was not refreshed")
+		return nil
+	}
+	first := waitForStaple(nil)
+	waitForStaple(first)
+}
@@ -32,6 +32,10 @@ func init() {
 	extKeyUsageToOID[x509.ExtKeyUsageMicrosoftCommercialCodeSigning] = asn1.ObjectIdentifier{1, 3, 6, 1, 4, 1, 311, 2, 1, 22}
 	extKeyUsageToOID[x509.ExtKeyUsageMicrosoftKernelCodeSigning] = asn1.ObjectIdentifier{1, 3, 6, 1, 4, 1, 311, 61, 1, 1}
 
+	for usage, oid := range extKeyUsageToOID {
+		extKeyUsageFromOID[oid.String()] = usage
+	}
+
 	strToKeyUsage["DigitalSignature"] = x509.KeyUsageDigitalSignature
 	strToKeyUsage["ContentCommitment"] = x509.KeyUsageContentCommitment
 	strToKeyUsage["KeyEncipherment"] = x509.KeyUsageKeyEncipherment
@@ -0,0 +1,382 @@
+package certutils
+
+import (
+	"bufio"
+	"bytes"
+	"crypto/x509"
+	"crypto/x509/pkix"
+	"encoding/asn1"
+	"errors"
+	"fmt"
+	"math/big"
+	"os"
+	"strings"
+	"time"
+
+	"github.com/spf13/afero"
+)
+
+// OpenSSLIndexUnknownFileName is the file name OpenSSL records for every certificate.
+const OpenSSLIndexUnknownFileName = "unknown"
+
+// OpenSSLIndexStatus is the status column of an OpenSSL CA database entry.
+type OpenSSLIndexStatus byte
+
+const (
+	OpenSSLIndexValid   OpenSSLIndexStatus = 'V'
+	OpenSSLIndexRevoked OpenSSLIndexStatus = 'R'
+	OpenSSLIndexExpired OpenSSLIndexStatus = 'E'
+)
+
+// OpenSSL records the invalidity date of a compromised key by replacing the reason with
+// these names, and requires a hold instruction after certificateHold.
+const (
+	openSSLKeyTime           = "keyTime"
+	openSSLCAKeyTime         = "CAkeyTime"
+	openSSLDefaultHoldReason = "holdInstructionNone"
+)
+
+// OpenSSLIndexEntry is a line of an OpenSSL CA database ("index.txt").
+type OpenSSLIndexEntry struct {
+	Status OpenSSLIndexStatus
+	// Expiry is the certificate's notAfter.
+	Expiry time.Time
+	// RevokedAt, Reason and InvalidityDate are only set on revoked entries.
+	RevokedAt      time.Time
+	Reason         RevocationReason
+	InvalidityDate time.Time
+	SerialNumber   *big.Int
+	// FileName is almost always OpenSSLIndexUnknownFileName.
+	FileName string
+	// Subject is the distinguished name in the format of OpenSSLOneLineName.
+	Subject string
+}
+
+// NewOpenSSLIndexEntry returns a valid entry for a newly issued certificate.
+func NewOpenSSLIndexEntry(certificate *x509.Certificate) (OpenSSLIndexEntry, error) {
+	subject, err := OpenSSLOneLineName(certificate.RawSubject)
+	if err != nil {
+		return OpenSSLIndexEntry{}, err
+	}
+	return OpenSSLIndexEntry{
+		Status:       OpenSSLIndexValid,
+		Expiry:       certificate.NotAfter.UTC(),
+		SerialNumber: certificate.SerialNumber,
+		FileName:     OpenSSLIndexUnknownFileName,
+		Subject:      subject,
+	}, nil
+}
+
+// Revoke marks the entry revoked. invalidityDate may be zero, and is only recorded for the
+// keyCompromise and CACompromise reasons as OpenSSL cannot represent it otherwise.
+func (e *OpenSSLIndexEntry) Revoke(revokedAt time.Time, reason RevocationReason, invalidityDate time.Time) {
+	e.Status = OpenSSLIndexRevoked
+	e.RevokedAt = revokedAt.UTC()
+	e.Reason = reason
+	e.InvalidityDate = time.Time{}
+	if reason == RevocationReasonKeyCompromise || reason == RevocationReasonCACompromise {
+		e.InvalidityDate = invalidityDate.UTC()
+	}
+}
+
+// RevokedCertificate returns the entry as a CRL entry.
+func (e OpenSSLIndexEntry) RevokedCertificate() RevokedCertificate {
+	return RevokedCertificate{
+		SerialNumber:   e.SerialNumber,
+		RevocationTime: e.RevokedAt,
+		Reason:         e.Reason,
+		InvalidityDate: e.InvalidityDate,
+	}
+}
+
+// String formats the entry as a line of index.txt, without the trailing newline.
+func (e OpenSSLIndexEntry) String() string {
+	revocation := ""
+	if e.Status == OpenSSLIndexRevoked {
+		revocation = formatOpenSSLTime(e.RevokedAt)
+		switch {
+		case e.Reason == RevocationReasonKeyCompromise && !e.InvalidityDate.IsZero():
+			revocation += "," + openSSLKeyTime + "," + e.InvalidityDate.UTC().Format(generalizedTimeFormat)
+		case e.Reason == RevocationReasonCACompromise && !e.InvalidityDate.IsZero():
+			revocation += "," + openSSLCAKeyTime + "," + e.InvalidityDate.UTC().Format(generalizedTimeFormat)
+		case e.Reason == RevocationReasonCertificateHold:
+			revocation += "," + e.Reason.String() + "," + openSSLDefaultHoldReason
+		case e.Reason != RevocationReasonUnspecified:
+			revocation += "," + e.Reason.String()
+		}
+	}
+	fileName := e.FileName
+	if fileName == "" {
+		fileName = OpenSSLIndexUnknownFileName
+	}
+	return strings.Join([]string{
+		string(e.Status),
+		formatOpenSSLTime(e.Expiry),
+		revocation,
+		FormatSerialHex(e.SerialNumber),
+		fileName,
+		e.Subject,
+	}, "\t")
+}
+
+const (
+	utcTimeFormat         = "060102150405Z"
+	generalizedTimeFormat = "20060102150405Z"
+)
+
+// formatOpenSSLTime formats a time as an ASN.1 UTCTime before 2050 and a GeneralizedTime
+// from 2050, as OpenSSL does.
+func formatOpenSSLTime(t time.Time) string {
+	t = t.UTC()
+	if t.Year() >= 1950 && t.Year() < 2050 {
+		return t.Format(utcTimeFormat)
+	}
+	return t.Format(generalizedTimeFormat)
+}
+
+func parseOpenSSLTime(s string) (time.Time, error) {
+	switch len(s) {
+	case len(utcTimeFormat):
+		t, err := time.Parse(utcTimeFormat, s)
+		if err != nil {
+			return time.Time{}, err
+		}
+		// Two digit years are 1950 to 2049 (RFC 5280 section 4.1.2.5.1).
+		if t.Year() >= 2050 {
+			t = t.AddDate(-100, 0, 0)
+		}
+		return t, nil
+	case len(generalizedTimeFormat):
+		return time.Parse(generalizedTimeFormat, s)
+	default:
+		return time.Time{}, fmt.Errorf("invalid time: %q", s)
+	}
+}
+
+// ParseOpenSSLIndexEntry parses a line of index.txt.
+func ParseOpenSSLIndexEntry(line string) (OpenSSLIndexEntry, error) {
+	fields := strings.Split(line, "\t")
+	if len(fields) != 6 {
+		return OpenSSLIndexEntry{}, fmt.Errorf("expected 6 tab separated fields, found %d", len(fields))
+	}
+
+	entry := OpenSSLIndexEntry{FileName: fields[4], Subject: fields[5]}
+	if len(fields[0]) != 1 {
+		return OpenSSLIndexEntry{}, fmt.Errorf("invalid status: %q", fields[0])
+	}
+	entry.Status = OpenSSLIndexStatus(fields[0][0])
+	switch entry.Status {
+	case OpenSSLIndexValid, OpenSSLIndexRevoked, OpenSSLIndexExpired:
+	default:
+		return OpenSSLIndexEntry{}, fmt.Errorf("invalid status: %q", fields[0])
+	}
+
+	var err error
+	if entry.Expiry, err = parseOpenSSLTime(fields[1]); err != nil {
+		return OpenSSLIndexEntry{}, err
+	}
+	if entry.SerialNumber, err = ParseSerialHex(fields[3]); err != nil {
+		return OpenSSLIndexEntry{}, err
+	}
+
+	if entry.Status == OpenSSLIndexRevoked {
+		revocation := strings.Split(fields[2], ",")
+		if entry.RevokedAt, err = parseOpenSSLTime(revocation[0]); err != nil {
+			return OpenSSLIndexEntry{}, err
+		}
+		if len(revocation) > 1 {
+			switch revocation[1] {
+			case openSSLKeyTime, openSSLCAKeyTime:
+				entry.Reason = RevocationReasonKeyCompromise
+				if revocation[1] == openSSLCAKeyTime {
+					entry.Reason = RevocationReasonCACompromise
+				}
+				if len(revocation) < 3 {
+					return OpenSSLIndexEntry{}, fmt.Errorf("missing compromise time: %q", fields[2])
+				}
+				if entry.InvalidityDate, err = time.Parse(generalizedTimeFormat, revocation[2]); err != nil {
+					return OpenSSLIndexEntry{}, err
+				}
+			default:
+				if entry.Reason, err = ParseRevocationReason(revocation[1]); err != nil {
+					return OpenSSLIndexEntry{}, err
+				}
+			}
+		}
+	}
+	return entry, nil
+}
+
+// ParseOpenSSLIndex parses the contents of index.txt.
+func ParseOpenSSLIndex(data []byte) ([]OpenSSLIndexEntry, error) {
+	entries := []OpenSSLIndexEntry{}
+	scanner := bufio.NewScanner(bytes.NewReader(data))
+	for lineNumber := 1; scanner.Scan(); lineNumber++ {
+		line := strings.TrimSuffix(scanner.Text(), "\r")
+		if line == "" {
+			continue
+		}
+		entry, err := ParseOpenSSLIndexEntry(line)
+		if err != nil {
+			return nil, fmt.Errorf("line %d: %w", lineNumber, err)
+		}
+		entries = append(entries, entry)
+	}
+	return entries, scanner.Err()
+}
+
+// EncodeOpenSSLIndex formats entries as the contents of index.txt.
+func EncodeOpenSSLIndex(entries []OpenSSLIndexEntry) []byte {
+	var buf bytes.Buffer
+	for _, entry := range entries {
+		buf.WriteString(entry.String())
+		buf.WriteByte('\n')
+	}
+	return buf.Bytes()
+}
+
+// ReadOpenSSLIndex reads an index.txt file. A missing file is an empty database.
+func ReadOpenSSLIndex(fs afero.Fs, path string) ([]OpenSSLIndexEntry, error) {
+	contents, err := afero.ReadFile(fs, path)
+	if errors.Is(err, os.ErrNotExist) {
+		return []OpenSSLIndexEntry{}, nil
+	} else if err != nil {
+		return nil, err
+	}
+	return ParseOpenSSLIndex(contents)
+}
+
+// WriteOpenSSLIndex atomically writes an index.txt file, and its index.txt.attr file if
+// one does not already exist. uniqueSubject sets whether OpenSSL allows two valid
+// certificates with the same subject; certutils itself never enforces it.
+func WriteOpenSSLIndex(fs afero.Fs, path string, entries []OpenSSLIndexEntry, uniqueSubject bool) error {
+	attrPath := path + ".attr"
+	if exists, err := afero.Exists(fs, attrPath); err != nil {
+		return err
+	} else if !exists {
+		value := "no"
+		if uniqueSubject {
+			value = "yes"
+		}
+		if err := writeFileAtomic(fs, attrPath, []byte("unique_subject = "+value+"\n"), 0644); err != nil {
+			return err
+		}
+	}
+	return writeFileAtomic(fs, path, EncodeOpenSSLIndex(entries), 0644)
+}
+
+// UpdateExpiredOpenSSLIndex marks valid entries past their expiry as expired, as
+// "openssl ca -updatedb" does. It returns the number of entries changed.
+func UpdateExpiredOpenSSLIndex(entries []OpenSSLIndexEntry, now time.Time) int {
+	updated := 0
+	for i := range entries {
+		if entries[i].Status == OpenSSLIndexValid && !now.Before(entries[i].Expiry) {
+			entries[i].Status = OpenSSLIndexExpired
+			updated++
+		}
+	}
+	return updated
+}
+
+// RevokedCertificatesFromOpenSSLIndex returns the revoked entries for CRL generation.
+func RevokedCertificatesFromOpenSSLIndex(entries []OpenSSLIndexEntry) []RevokedCertificate {
+	revoked := []RevokedCertificate{}
+	for _, entry := range entries {
+		if entry.Status == OpenSSLIndexRevoked {
+			revoked = append(revoked, entry.RevokedCertificate())
+		}
+	}
+	return revoked
+}
+
+// openSSLShortNames are the short names X509_NAME_oneline uses for common attributes.
+var openSSLShortNames = map[string]string{
+	"2.5.4.3":                    "CN",
+	"2.5.4.4":                    "SN",
+	"2.5.4.5":                    "serialNumber",
+	"2.5.4.6":                    "C",
+	"2.5.4.7":                    "L",
+	"2.5.4.8":                    "ST",
+	"2.5.4.9":                    "street",
+	"2.5.4.10":                   "O",
+	"2.5.4.11":                   "OU",
+	"2.5.4.12":                   "title",
+	"2.5.4.17":                   "postalCode",
+	"2.5.4.42":                   "GN",
+	"2.5.4.43":                   "initials",
+	"2.5.4.46":                   "dnQualifier",
+	"2.5.4.65":                   "pseudonym",
+	"0.9.2342.19200300.100.1.1":  "UID",
+	"0.9.2342.19200300.100.1.25": "DC",
+	"1.2.840.113549.1.9.1":       "emailAddress",
+}
+
+// OpenSSLOneLineName formats a DER encoded distinguished name as OpenSSL's X509_NAME_oneline
+// does, for example "/C=AU/O=Example/CN=www.example.com". Attributes appear in the order they
+// are encoded. Bytes outside printable ASCII are escaped as \xXX.
+func OpenSSLOneLineName(rawName []byte) (string, error) {
+	var rdns pkix.RDNSequence
+	if rest, err := asn1.Unmarshal(rawName, &rdns); err != nil {
+		return "", err
+	} else if len(rest) > 0 {
+		return "", errors.New("trailing data after distinguished name")
+	}
+
+	var b strings.Builder
+	for _, rdn := range rdns {
+		for _, attribute := range rdn {
+			name, found := openSSLShortNames[attribute.Type.String()]
+			if !found {
+				name = attribute.Type.String()
+			}
+			b.WriteString("/")
+			b.WriteString(name)
+			b.WriteString("=")
+			b.WriteString(escapeOpenSSLValue(fmt.Sprint(attribute.Value)))
+		}
+	}
+	return b.String(), nil
+}
+
+func escapeOpenSSLValue(value string) string {
+	var b strings.Builder
+	for i := 0; i < len(value); i++ {
+		c := value[i]
+		if c < ' ' || c > '~' {
+			fmt.Fprintf(&b, "\\x%02X", c)
+			continue
+		}
+		b.WriteByte(c)
+	}
+	return b.String()
+}
+
+// openSSLOneLineCommonName returns the last CN attribute of a name formatted by
+// OpenSSLOneLineName. Values may themselves contain '/', so a component which does not start
+// with a known attribute name continues the previous value.
+func openSSLOneLineCommonName(name string) string {
+	commonName := ""
+	inCommonName := false
+	for _, component := range strings.Split(strings.TrimPrefix(name, "/"), "/") {
+		key, value, found := strings.Cut(component, "=")
+		switch {
+		case found && isOpenSSLAttributeName(key):
+			inCommonName = key == "CN"
+			if inCommonName {
+				commonName = value
+			}
+		case inCommonName:
+			commonName += "/" + component
+		}
+	}
+	return commonName
+}
+
+func isOpenSSLAttributeName(key string) bool {
+	for _, name := range openSSLShortNames {
+		if key == name {
+			return true
+		}
+	}
+	return strings.Trim(key, "0123456789.") == "" && strings.Contains(key, ".")
+}
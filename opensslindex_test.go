@@ -0,0 +1,149 @@
+package certutils
+
+import (
+	"crypto/x509/pkix"
+	"encoding/asn1"
+	"math/big"
+	"time"
+
+	"github.com/spf13/afero"
+	. "gopkg.in/check.v1"
+)
+
+type OpenSSLIndexSuite struct {
+}
+
+var _ = Suite(&OpenSSLIndexSuite{})
+
+func (s *OpenSSLIndexSuite) TestRoundTrip(c *C) {
+	index := "V\t310101000000Z\t\t01\tunknown\t/C=AU/O=Example/CN=www.example.com\n" +
+		"R\t310101000000Z\t250601120000Z,superseded\t02\tunknown\t/CN=old.example.com\n" +
+		"R\t310101000000Z\t250601120000Z,keyTime,20250530000000Z\t0A\tunknown\t/CN=stolen.example.com\n" +
+		"R\t310101000000Z\t250601120000Z,certificateHold,holdInstructionNone\t0B\tunknown\t/CN=held.example.com\n" +
+		"R\t310101000000Z\t250601120000Z\t0C\tunknown\t/CN=no reason\n" +
+		"E\t20510101000000Z\t\t0D\tunknown\t/CN=far/future\n"
+
+	entries, err := ParseOpenSSLIndex([]byte(index))
+	c.Assert(err, IsNil)
+	c.Assert(entries, HasLen, 6)
+
+	c.Check(entries[0].Status, Equals, OpenSSLIndexValid)
+	c.Check(entries[0].Expiry, Equals, time.Date(2031, 1, 1, 0, 0, 0, 0, time.UTC))
+	c.Check(entries[0].SerialNumber.Int64(), Equals, int64(1))
+	c.Check(entries[0].Subject, Equals, "/C=AU/O=Example/CN=www.example.com")
+
+	revokedAt := time.Date(2025, 6, 1, 12, 0, 0, 0, time.UTC)
+	c.Check(entries[1].RevokedAt, Equals, revokedAt)
+	c.Check(entries[1].Reason, Equals, RevocationReasonSuperseded)
+	c.Check(entries[2].Reason, Equals, RevocationReasonKeyCompromise)
+	c.Check(entries[2].InvalidityDate, Equals, time.Date(2025, 5, 30, 0, 0, 0, 0, time.UTC))
+	c.Check(entries[3].Reason, Equals, RevocationReasonCertificateHold)
+	c.Check(entries[4].Reason, Equals, RevocationReasonUnspecified)
+	c.Check(entries[5].Status, Equals, OpenSSLIndexExpired)
+	c.Check(entries[5].Expiry.Year(), Equals, 2051)
+
+	c.Check(string(EncodeOpenSSLIndex(entries)), Equals, index)
+
+	revoked := RevokedCertificatesFromOpenSSLIndex(entries)
+	c.Assert(revoked, HasLen, 4)
+	c.Check(revoked[1], DeepEquals, RevokedCertificate{
+		SerialNumber:   big.NewInt(10),
+		RevocationTime: revokedAt,
+		Reason:         RevocationReasonKeyCompromise,
+		InvalidityDate: time.Date(2025, 5, 30, 0, 0, 0, 0, time.UTC),
+	})
+}
+
+func (s *OpenSSLIndexSuite) TestParseErrors(c *C) {
+	for _, line := range []string{
+		"V\t310101000000Z\t\t01\tunknown",
+		"X\t310101000000Z\t\t01\tunknown\t/CN=a",
+		"V\t3101010000Z\t\t01\tunknown\t/CN=a",
+		"V\t310101000000Z\t\tZZ\tunknown\t/CN=a",
+		"R\t310101000000Z\t\t01\tunknown\t/CN=a",
+		"R\t310101000000Z\t250601120000Z,keyTime\t01\tunknown\t/CN=a",
+		"R\t310101000000Z\t250601120000Z,notAReason\t01\tunknown\t/CN=a",
+	} {
+		_, err := ParseOpenSSLIndexEntry(line)
+		c.Check(err, NotNil, Commentf(line))
+	}
+
+	_, err := ParseOpenSSLIndex([]byte("V\t310101000000Z\t\t01\tunknown\t/CN=a\nbad\n"))
+	c.Check(err, ErrorMatches, "line 2: .*")
+}
+
+func (s *OpenSSLIndexSuite) TestEntryFromCertificate(c *C) {
+	ca, caKey := newTestCA(c, PrivateKeyTypeEcp256)
+	certificate := newTestLeaf(c, ca, caKey, PrivateKeyTypeEcp256, "www.example.com")
+
+	entry, err := NewOpenSSLIndexEntry(certificate.Leaf)
+	c.Assert(err, IsNil)
+	c.Check(entry.Status, Equals, OpenSSLIndexValid)
+	c.Check(entry.Subject, Equals, "/CN=www.example.com")
+	c.Check(entry.Expiry.Equal(certificate.Leaf.NotAfter), Equals, true)
+
+	// The invalidity date is only kept for key compromise.
+	revokedAt := time.Date(2025, 6, 1, 12, 0, 0, 0, time.UTC)
+	entry.Revoke(revokedAt, RevocationReasonAffiliationChanged, revokedAt.Add(-time.Hour))
+	c.Check(entry.InvalidityDate.IsZero(), Equals, true)
+	entry.Revoke(revokedAt, RevocationReasonCACompromise, revokedAt.Add(-time.Hour))
+	c.Check(entry.InvalidityDate, Equals, revokedAt.Add(-time.Hour))
+
+	parsed, err := ParseOpenSSLIndexEntry(entry.String())
+	c.Assert(err, IsNil)
+	c.Check(parsed.Reason, Equals, RevocationReasonCACompromise)
+	c.Check(parsed.InvalidityDate, Equals, entry.InvalidityDate)
+}
+
+func (s *OpenSSLIndexSuite) TestOneLineName(c *C) {
+	name := pkix.Name{
+		Country:      []string{"AU"},
+		Organization: []string{"Example/Org"},
+		CommonName:   "café.example.com",
+		ExtraNames: []pkix.AttributeTypeAndValue{
+			{Type: []int{1, 2, 3, 4}, Value: "custom"},
+		},
+	}
+	raw, err := asn1.Marshal(name.ToRDNSequence())
+	c.Assert(err, IsNil)
+
+	oneLine, err := OpenSSLOneLineName(raw)
+	c.Assert(err, IsNil)
+	c.Check(oneLine, Equals, "/C=AU/O=Example/Org/CN=caf\\xC3\\xA9.example.com/1.2.3.4=custom")
+	c.Check(openSSLOneLineCommonName(oneLine), Equals, "caf\\xC3\\xA9.example.com")
+	c.Check(openSSLOneLineCommonName("/CN=a/b/O=c"), Equals, "a/b")
+}
+
+func (s *OpenSSLIndexSuite) TestReadWrite(c *C) {
+	fs := afero.NewMemMapFs()
+	entries, err := ReadOpenSSLIndex(fs, "/ca/index.txt")
+	c.Assert(err, IsNil)
+	c.Check(entries, HasLen, 0)
+
+	now := time.Date(2030, 1, 1, 0, 0, 0, 0, time.UTC)
+	entries = []OpenSSLIndexEntry{
+		{Status: OpenSSLIndexValid, Expiry: now.Add(-time.Hour), SerialNumber: big.NewInt(1), Subject: "/CN=a"},
+		{Status: OpenSSLIndexValid, Expiry: now.Add(time.Hour), SerialNumber: big.NewInt(2), Subject: "/CN=b"},
+	}
+	c.Check(UpdateExpiredOpenSSLIndex(entries, now), Equals, 1)
+	c.Check(entries[0].Status, Equals, OpenSSLIndexExpired)
+	c.Check(entries[1].Status, Equals, OpenSSLIndexValid)
+
+	c.Assert(WriteOpenSSLIndex(fs, "/ca/index.txt", entries, false), IsNil)
+	attr, err := afero.ReadFile(fs, "/ca/index.txt.attr")
+	c.Assert(err, IsNil)
+	c.Check(string(attr), Equals, "unique_subject = no\n")
+
+	// An existing attr file is left alone.
+	c.Assert(afero.WriteFile(fs, "/ca/index.txt.attr", []byte("unique_subject = yes\n"), 0644), IsNil)
+	c.Assert(WriteOpenSSLIndex(fs, "/ca/index.txt", entries, false), IsNil)
+	attr, err = afero.ReadFile(fs, "/ca/index.txt.attr")
+	c.Assert(err, IsNil)
+	c.Check(string(attr), Equals, "unique_subject = yes\n")
+
+	read, err := ReadOpenSSLIndex(fs, "/ca/index.txt")
+	c.Assert(err, IsNil)
+	c.Check(read, HasLen, 2)
+	c.Check(read[0].FileName, Equals, OpenSSLIndexUnknownFileName)
+	c.Check(read[1].String(), Equals, "V\t300101010000Z\t\t02\tunknown\t/CN=b")
+}
@@ -0,0 +1,594 @@
+package certutils
+
+import (
+	"bytes"
+	"crypto"
+	"crypto/cipher"
+	"crypto/des"
+	"crypto/hmac"
+	"crypto/sha1"
+	"crypto/tls"
+	"crypto/x509"
+	"crypto/x509/pkix"
+	"encoding/asn1"
+	"errors"
+	"fmt"
+	"hash"
+	"unicode/utf16"
+
+	"software.sslmate.com/src/go-pkcs12"
+)
+
+var ErrUnsupportedPKCS12 = errors.New("unsupported PKCS#12 content")
+var ErrPKCS12NotAuthenticated = errors.New("PKCS#12 bundle has no MAC to verify the passphrase against")
+
+var (
+	oidPKCS12KeyBag             = asn1.ObjectIdentifier{1, 2, 840, 113549, 1, 12, 10, 1, 1}
+	oidPKCS12ShroudedKeyBag     = asn1.ObjectIdentifier{1, 2, 840, 113549, 1, 12, 10, 1, 2}
+	oidPKCS12CertBag            = asn1.ObjectIdentifier{1, 2, 840, 113549, 1, 12, 10, 1, 3}
+	oidPKCS12SafeContentsBag    = asn1.ObjectIdentifier{1, 2, 840, 113549, 1, 12, 10, 1, 6}
+	oidPKCS12X509Certificate    = asn1.ObjectIdentifier{1, 2, 840, 113549, 1, 9, 22, 1}
+	oidPKCS12FriendlyName       = asn1.ObjectIdentifier{1, 2, 840, 113549, 1, 9, 20}
+	oidPKCS12LocalKeyID         = asn1.ObjectIdentifier{1, 2, 840, 113549, 1, 9, 21}
+	oidPBEWithSHAAnd3KeyDESCBC  = asn1.ObjectIdentifier{1, 2, 840, 113549, 1, 12, 1, 3}
+	oidPBEWithSHAAnd128BitRC2   = asn1.ObjectIdentifier{1, 2, 840, 113549, 1, 12, 1, 5}
+	oidPBEWithSHAAnd40BitRC2CBC = asn1.ObjectIdentifier{1, 2, 840, 113549, 1, 12, 1, 6}
+	oidPBMAC1                   = asn1.ObjectIdentifier{1, 2, 840, 113549, 1, 5, 14}
+)
+
+// The ID bytes of the PKCS#12 key derivation function (RFC 7292 appendix B.3).
+const (
+	pkcs12KeyMaterial = 1
+	pkcs12IVMaterial  = 2
+	pkcs12MACMaterial = 3
+
+	// maxPKCS12Iterations bounds the iteration count of the PKCS#12 key derivation function,
+	// so a crafted bundle cannot use unbounded CPU.
+	maxPKCS12Iterations = MaxPBKDF2Iterations
+)
+
+// PKCS12Encryption selects the algorithms EncodePKCS12 protects a bundle with.
+type PKCS12Encryption int
+
+const (
+	// PKCS12EncryptionAES256 encrypts with AES-256-CBC under PBKDF2-HMAC-SHA256 and
+	// authenticates with HMAC-SHA256, as OpenSSL 3 does by default. It is read by OpenSSL
+	// 1.1.1, Java 12 and Windows Server 2019 and later.
+	PKCS12EncryptionAES256 PKCS12Encryption = iota
+	// PKCS12EncryptionLegacy3DES encrypts with 3DES and authenticates with HMAC-SHA1, for
+	// older Windows and Java releases.
+	PKCS12EncryptionLegacy3DES
+	// PKCS12EncryptionLegacyRC2 encrypts the certificates with 40-bit RC2 and the key with
+	// 3DES, as OpenSSL did before 3.0, for consumers which accept nothing else.
+	PKCS12EncryptionLegacyRC2
+)
+
+// PKCS12Options sets parameters for EncodePKCS12.
+type PKCS12Options struct {
+	Encryption PKCS12Encryption
+	// Iterations is the iteration count for key derivation and the MAC. Zero selects 2048 for
+	// key derivation, and for the MAC under PKCS12EncryptionAES256.
+	Iterations int
+}
+
+// EncodePKCS12 encodes the key, leaf and chain of certificate as a PKCS#12 (PFX) bundle
+// protected by passphrase.
+func EncodePKCS12(certificate tls.Certificate, passphrase []byte, options PKCS12Options) ([]byte, error) {
+	if certificate.PrivateKey == nil {
+		return nil, ErrNoPrivateKey
+	}
+	if len(certificate.Certificate) == 0 {
+		return nil, ErrNoCertificate
+	}
+	chain := make([]*x509.Certificate, 0, len(certificate.Certificate))
+	for _, der := range certificate.Certificate {
+		parsed, err := x509.ParseCertificate(der)
+		if err != nil {
+			return nil, err
+		}
+		chain = append(chain, parsed)
+	}
+	if err := CheckKeyMatchesCertificate(certificate.PrivateKey, chain[0]); err != nil {
+		return nil, err
+	}
+
+	var encoder *pkcs12.Encoder
+	switch options.Encryption {
+	case PKCS12EncryptionAES256:
+		encoder = pkcs12.Modern2023
+	case PKCS12EncryptionLegacy3DES:
+		encoder = pkcs12.LegacyDES
+	case PKCS12EncryptionLegacyRC2:
+		encoder = pkcs12.LegacyRC2
+	default:
+		return nil, fmt.Errorf("%w: encryption %d", ErrUnsupportedPKCS12, options.Encryption)
+	}
+	if options.Iterations > 0 {
+		encoder = encoder.WithIterations(options.Iterations)
+	}
+	return encoder.Encode(certificate.PrivateKey, chain[0], chain[1:], string(passphrase))
+}
+
+// PKCS12Key is a private key read from a PKCS#12 bundle.
+type PKCS12Key struct {
+	PrivateKey   crypto.PrivateKey
+	FriendlyName string
+	// LocalKeyID links the key to its certificate.
+	LocalKeyID []byte
+}
+
+// PKCS12Certificate is a certificate read from a PKCS#12 bundle.
+type PKCS12Certificate struct {
+	Certificate  *x509.Certificate
+	FriendlyName string
+	LocalKeyID   []byte
+}
+
+// PKCS12Bundle holds the keys and certificates of a PKCS#12 bundle in the order they were
+// stored. Bags of other types are skipped.
+type PKCS12Bundle struct {
+	Keys         []PKCS12Key
+	Certificates []PKCS12Certificate
+}
+
+// KeyPair returns the key pair for the key with the given friendly name, or for the only key
+// if friendlyName is empty. The leaf is the certificate with the key's local key ID, or
+// failing that the first matching the key. The certificates which are not the leaf of another
+// key in the bundle make up the chain.
+func (b *PKCS12Bundle) KeyPair(friendlyName string) (*KeyPair, error) {
+	var key *PKCS12Key
+	switch {
+	case len(b.Keys) == 0:
+		return nil, ErrNoPrivateKey
+	case friendlyName == "" && len(b.Keys) > 1:
+		return nil, ErrMultiplePrivateKeys
+	case friendlyName == "":
+		key = &b.Keys[0]
+	default:
+		for i := range b.Keys {
+			if b.Keys[i].FriendlyName == friendlyName {
+				key = &b.Keys[i]
+				break
+			}
+		}
+		if key == nil {
+			return nil, fmt.Errorf("%w with friendly name %q", ErrNoPrivateKey, friendlyName)
+		}
+	}
+
+	var leaf *x509.Certificate
+	for _, certificate := range b.Certificates {
+		if len(key.LocalKeyID) > 0 && bytes.Equal(certificate.LocalKeyID, key.LocalKeyID) &&
+			CheckKeyMatchesCertificate(key.PrivateKey, certificate.Certificate) == nil {
+			leaf = certificate.Certificate
+			break
+		}
+	}
+	for _, certificate := range b.Certificates {
+		if leaf == nil && CheckKeyMatchesCertificate(key.PrivateKey, certificate.Certificate) == nil {
+			leaf = certificate.Certificate
+		}
+	}
+	if leaf == nil {
+		mismatch := &ErrKeyPairMismatch{CertFile: "PKCS#12 bundle", KeyFile: "PKCS#12 bundle"}
+		for _, certificate := range b.Certificates {
+			mismatch.Subjects = append(mismatch.Subjects, certificate.Certificate.Subject.String())
+		}
+		return nil, mismatch
+	}
+
+	others := []*x509.Certificate{}
+	for _, certificate := range b.Certificates {
+		if certificate.Certificate == leaf || b.isOtherLeaf(key, certificate.Certificate) {
+			continue
+		}
+		others = append(others, certificate.Certificate)
+	}
+	return newKeyPair(key.PrivateKey, leaf, others), nil
+}
+
+// isOtherLeaf reports whether certificate belongs to a key in the bundle other than key.
+func (b *PKCS12Bundle) isOtherLeaf(key *PKCS12Key, certificate *x509.Certificate) bool {
+	for i := range b.Keys {
+		if &b.Keys[i] != key && CheckKeyMatchesCertificate(b.Keys[i].PrivateKey, certificate) == nil {
+			return true
+		}
+	}
+	return false
+}
+
+type pfxPDU struct {
+	Version  int
+	AuthSafe pkcs7ContentInfo
+	MacData  pkcs12MacData `asn1:"optional"`
+}
+
+type pkcs12MacData struct {
+	Mac        pkcs12DigestInfo
+	MacSalt    []byte
+	Iterations int `asn1:"optional,default:1"`
+}
+
+type pkcs12DigestInfo struct {
+	Algorithm pkix.AlgorithmIdentifier
+	Digest    []byte
+}
+
+type pkcs12EncryptedData struct {
+	Version              int
+	EncryptedContentInfo pkcs12EncryptedContentInfo
+}
+
+type pkcs12EncryptedContentInfo struct {
+	ContentType                asn1.ObjectIdentifier
+	ContentEncryptionAlgorithm pkix.AlgorithmIdentifier
+	EncryptedContent           asn1.RawValue `asn1:"optional"`
+}
+
+type pkcs12SafeBag struct {
+	ID         asn1.ObjectIdentifier
+	Value      asn1.RawValue     `asn1:"tag:0,explicit"`
+	Attributes []pkcs12Attribute `asn1:"set,optional"`
+}
+
+type pkcs12Attribute struct {
+	ID    asn1.ObjectIdentifier
+	Value asn1.RawValue `asn1:"set"`
+}
+
+type pkcs12CertBag struct {
+	ID   asn1.ObjectIdentifier
+	Data []byte `asn1:"tag:0,explicit"`
+}
+
+type pkcs12PBEParams struct {
+	Salt       []byte
+	Iterations int
+}
+
+type pbmac1Params struct {
+	KeyDerivationFunc pkix.AlgorithmIdentifier
+	MessageAuthScheme pkix.AlgorithmIdentifier
+}
+
+// pkcs12Decoder holds the passphrase in the two forms PKCS#12 uses: UTF-8 for PBES2 and
+// PBMAC1, and a NUL terminated BMPString for the older PKCS#12 algorithms.
+type pkcs12Decoder struct {
+	passphrase  []byte
+	bmpPassword []byte
+	bundle      *PKCS12Bundle
+}
+
+// DecodePKCS12 decodes a PKCS#12 (PFX) bundle protected by passphrase, including the BER
+// encoded bundles written by Windows and Java. It reads keys and certificates from any number
+// of bags, encrypted with PBES2 or the legacy 3DES and RC2 algorithms, with their friendly
+// names and local key IDs. An incorrect passphrase returns ErrIncorrectPassphrase, and a bundle
+// without a MAC is only accepted with an empty passphrase.
+func DecodePKCS12(data []byte, passphrase []byte) (*PKCS12Bundle, error) {
+	der, err := berToDER(data)
+	if err != nil {
+		return nil, err
+	}
+	var pfx pfxPDU
+	if rest, err := asn1.Unmarshal(der, &pfx); err != nil || len(rest) > 0 {
+		return nil, fmt.Errorf("%w: malformed PFX", ErrUnsupportedPKCS12)
+	}
+	if pfx.Version != 3 {
+		return nil, fmt.Errorf("%w: version %d", ErrUnsupportedPKCS12, pfx.Version)
+	}
+	if !pfx.AuthSafe.ContentType.Equal(oidPKCS7Data) {
+		return nil, fmt.Errorf("%w: authenticated safe of type %v", ErrUnsupportedPKCS12, pfx.AuthSafe.ContentType)
+	}
+	var authSafe []byte
+	if _, err := asn1.Unmarshal(pfx.AuthSafe.Content.Bytes, &authSafe); err != nil {
+		return nil, fmt.Errorf("%w: malformed authenticated safe", ErrUnsupportedPKCS12)
+	}
+
+	// An empty password may be encoded as an empty BMPString or as nothing at all.
+	decoder := &pkcs12Decoder{passphrase: passphrase, bundle: &PKCS12Bundle{}}
+	candidates := [][]byte{bmpPassword(passphrase)}
+	if len(passphrase) == 0 {
+		candidates = append(candidates, nil)
+	}
+	decoder.bmpPassword = candidates[0]
+	if len(pfx.MacData.Mac.Algorithm.Algorithm) > 0 {
+		err := ErrIncorrectPassphrase
+		for _, candidate := range candidates {
+			if err = verifyPKCS12MAC(pfx.MacData, authSafe, passphrase, candidate); err == nil {
+				decoder.bmpPassword = candidate
+				break
+			}
+		}
+		if err != nil {
+			return nil, err
+		}
+	} else if len(passphrase) > 0 {
+		// Anyone could have written the bundle, so it cannot be treated as protected.
+		return nil, ErrPKCS12NotAuthenticated
+	}
+
+	if authSafe, err = berToDER(authSafe); err != nil {
+		return nil, err
+	}
+	var contents []pkcs7ContentInfo
+	if rest, err := asn1.Unmarshal(authSafe, &contents); err != nil || len(rest) > 0 {
+		return nil, fmt.Errorf("%w: malformed authenticated safe", ErrUnsupportedPKCS12)
+	}
+	for _, content := range contents {
+		safe, err := decoder.safeContents(content)
+		if err != nil {
+			return nil, err
+		}
+		if err := decoder.readBags(safe); err != nil {
+			return nil, err
+		}
+	}
+	return decoder.bundle, nil
+}
+
+// safeContents returns the SafeContents held by a ContentInfo of the authenticated safe.
+func (d *pkcs12Decoder) safeContents(content pkcs7ContentInfo) ([]byte, error) {
+	switch {
+	case content.ContentType.Equal(oidPKCS7Data):
+		var data []byte
+		if _, err := asn1.Unmarshal(content.Content.Bytes, &data); err != nil {
+			return nil, fmt.Errorf("%w: malformed safe contents", ErrUnsupportedPKCS12)
+		}
+		return berToDER(data)
+	case content.ContentType.Equal(oidPKCS7EncryptedData):
+		var encrypted pkcs12EncryptedData
+		if _, err := asn1.Unmarshal(content.Content.Bytes, &encrypted); err != nil {
+			return nil, fmt.Errorf("%w: malformed encrypted safe contents", ErrUnsupportedPKCS12)
+		}
+		info := encrypted.EncryptedContentInfo
+		ciphertext := info.EncryptedContent.Bytes
+		if info.EncryptedContent.IsCompound {
+			// A constructed [0] IMPLICIT OCTET STRING, which berToDER cannot recognise.
+			var err error
+			if ciphertext, err = concatenateOctetStrings(ciphertext); err != nil {
+				return nil, err
+			}
+		}
+		plaintext, err := d.decrypt(info.ContentEncryptionAlgorithm, ciphertext)
+		if err != nil {
+			return nil, err
+		}
+		return berToDER(plaintext)
+	default:
+		return nil, fmt.Errorf("%w: safe contents of type %v", ErrUnsupportedPKCS12, content.ContentType)
+	}
+}
+
+// readBags adds the keys and certificates of a SafeContents to the bundle.
+func (d *pkcs12Decoder) readBags(safe []byte) error {
+	var bags []pkcs12SafeBag
+	if rest, err := asn1.Unmarshal(safe, &bags); err != nil || len(rest) > 0 {
+		return fmt.Errorf("%w: malformed safe contents", ErrUnsupportedPKCS12)
+	}
+
+	for _, bag := range bags {
+		friendlyName, localKeyID, err := pkcs12BagAttributes(bag.Attributes)
+		if err != nil {
+			return err
+		}
+
+		switch {
+		case bag.ID.Equal(oidPKCS12KeyBag), bag.ID.Equal(oidPKCS12ShroudedKeyBag):
+			der := bag.Value.Bytes
+			if bag.ID.Equal(oidPKCS12ShroudedKeyBag) {
+				var info encryptedPrivateKeyInfo
+				if _, err := asn1.Unmarshal(der, &info); err != nil {
+					return fmt.Errorf("%w: malformed shrouded key bag", ErrUnsupportedPKCS12)
+				}
+				if der, err = d.decrypt(info.Algorithm, info.EncryptedData); err != nil {
+					return err
+				}
+			}
+			key, err := x509.ParsePKCS8PrivateKey(der)
+			if err != nil {
+				return ErrIncorrectPassphrase
+			}
+			d.bundle.Keys = append(d.bundle.Keys, PKCS12Key{
+				PrivateKey:   key,
+				FriendlyName: friendlyName,
+				LocalKeyID:   localKeyID,
+			})
+		case bag.ID.Equal(oidPKCS12CertBag):
+			var certBag pkcs12CertBag
+			if _, err := asn1.Unmarshal(bag.Value.Bytes, &certBag); err != nil {
+				return fmt.Errorf("%w: malformed certificate bag", ErrUnsupportedPKCS12)
+			}
+			if !certBag.ID.Equal(oidPKCS12X509Certificate) {
+				continue
+			}
+			certificate, err := x509.ParseCertificate(certBag.Data)
+			if err != nil {
+				return err
+			}
+			d.bundle.Certificates = append(d.bundle.Certificates, PKCS12Certificate{
+				Certificate:  certificate,
+				FriendlyName: friendlyName,
+				LocalKeyID:   localKeyID,
+			})
+		case bag.ID.Equal(oidPKCS12SafeContentsBag):
+			if err := d.readBags(bag.Value.Bytes); err != nil {
+				return err
+			}
+		}
+	}
+	return nil
+}
+
+// decrypt decrypts ciphertext with a PBES2 or legacy PKCS#12 password based scheme.
+func (d *pkcs12Decoder) decrypt(algorithm pkix.AlgorithmIdentifier, ciphertext []byte) ([]byte, error) {
+	if algorithm.Algorithm.Equal(oidPBES2) {
+		return decryptPBES2(algorithm, ciphertext, d.passphrase)
+	}
+
+	var params pkcs12PBEParams
+	if _, err := asn1.Unmarshal(algorithm.Parameters.FullBytes, &params); err != nil || params.Iterations < 1 {
+		return nil, fmt.Errorf("%w: malformed PBE parameters", ErrUnsupportedPKCS12)
+	}
+	if params.Iterations > maxPKCS12Iterations {
+		return nil, fmt.Errorf("%w: PBE iterations %d", ErrUnsupportedPKCS12, params.Iterations)
+	}
+	var block cipher.Block
+	switch {
+	case algorithm.Algorithm.Equal(oidPBEWithSHAAnd3KeyDESCBC):
+		var err error
+		key := pkcs12KDF(sha1.New, params.Salt, d.bmpPassword, params.Iterations, pkcs12KeyMaterial, 24)
+		if block, err = des.NewTripleDESCipher(key); err != nil {
+			return nil, err
+		}
+	case algorithm.Algorithm.Equal(oidPBEWithSHAAnd128BitRC2):
+		key := pkcs12KDF(sha1.New, params.Salt, d.bmpPassword, params.Iterations, pkcs12KeyMaterial, 16)
+		block = newRC2Cipher(key, 128)
+	case algorithm.Algorithm.Equal(oidPBEWithSHAAnd40BitRC2CBC):
+		key := pkcs12KDF(sha1.New, params.Salt, d.bmpPassword, params.Iterations, pkcs12KeyMaterial, 5)
+		block = newRC2Cipher(key, 40)
+	default:
+		return nil, fmt.Errorf("%w: encryption algorithm %v", ErrUnsupportedPKCS12, algorithm.Algorithm)
+	}
+	iv := pkcs12KDF(sha1.New, params.Salt, d.bmpPassword, params.Iterations, pkcs12IVMaterial, block.BlockSize())
+	return decryptCBC(block, iv, ciphertext)
+}
+
+// pkcs12BagAttributes returns the friendly name and local key ID of a bag.
+func pkcs12BagAttributes(attributes []pkcs12Attribute) (string, []byte, error) {
+	var friendlyName string
+	var localKeyID []byte
+	for _, attribute := range attributes {
+		switch {
+		case attribute.ID.Equal(oidPKCS12FriendlyName):
+			var value asn1.RawValue
+			if _, err := asn1.Unmarshal(attribute.Value.Bytes, &value); err != nil || value.Tag != 30 || len(value.Bytes)%2 != 0 {
+				return "", nil, fmt.Errorf("%w: malformed friendly name", ErrUnsupportedPKCS12)
+			}
+			units := make([]uint16, len(value.Bytes)/2)
+			for i := range units {
+				units[i] = uint16(value.Bytes[2*i])<<8 | uint16(value.Bytes[2*i+1])
+			}
+			friendlyName = string(utf16.Decode(units))
+		case attribute.ID.Equal(oidPKCS12LocalKeyID):
+			if _, err := asn1.Unmarshal(attribute.Value.Bytes, &localKeyID); err != nil {
+				return "", nil, fmt.Errorf("%w: malformed local key ID", ErrUnsupportedPKCS12)
+			}
+		}
+	}
+	return friendlyName, localKeyID, nil
+}
+
+// verifyPKCS12MAC checks the MAC over the authenticated safe.
+func verifyPKCS12MAC(macData pkcs12MacData, message, passphrase, bmpPassword []byte) error {
+	var newHash func() hash.Hash
+	var key []byte
+	algorithm := macData.Mac.Algorithm
+	if algorithm.Algorithm.Equal(oidPBMAC1) {
+		var params pbmac1Params
+		if _, err := asn1.Unmarshal(algorithm.Parameters.FullBytes, &params); err != nil ||
+			!params.KeyDerivationFunc.Algorithm.Equal(oidPBKDF2) {
+			return fmt.Errorf("%w: malformed PBMAC1 parameters", ErrUnsupportedPKCS12)
+		}
+		var kdfParams pbkdf2Params
+		if _, err := asn1.Unmarshal(params.KeyDerivationFunc.Parameters.FullBytes, &kdfParams); err != nil {
+			return fmt.Errorf("%w: malformed PBKDF2 parameters", ErrUnsupportedPKCS12)
+		}
+		// RFC 9579 requires a key length, and a short one would make the MAC easy to forge.
+		if kdfParams.KeyLength < 20 || kdfParams.KeyLength > 64 {
+			return fmt.Errorf("%w: PBMAC1 key length %d", ErrUnsupportedPKCS12, kdfParams.KeyLength)
+		}
+		switch {
+		case params.MessageAuthScheme.Algorithm.Equal(oidHMACWithSHA1):
+			newHash = crypto.SHA1.New
+		case params.MessageAuthScheme.Algorithm.Equal(oidHMACWithSHA256):
+			newHash = crypto.SHA256.New
+		case params.MessageAuthScheme.Algorithm.Equal(oidHMACWithSHA384):
+			newHash = crypto.SHA384.New
+		case params.MessageAuthScheme.Algorithm.Equal(oidHMACWithSHA512):
+			newHash = crypto.SHA512.New
+		default:
+			return fmt.Errorf("%w: PBMAC1 MAC %v", ErrUnsupportedPKCS12, params.MessageAuthScheme.Algorithm)
+		}
+		var err error
+		if key, err = deriveKey(params.KeyDerivationFunc, passphrase, kdfParams.KeyLength); err != nil {
+			return err
+		}
+	} else {
+		for hashFunction, oid := range ocspHashOIDs {
+			if algorithm.Algorithm.Equal(oid) {
+				newHash = hashFunction.New
+			}
+		}
+		if newHash == nil {
+			return fmt.Errorf("%w: MAC algorithm %v", ErrUnsupportedPKCS12, algorithm.Algorithm)
+		}
+		if macData.Iterations < 1 || macData.Iterations > maxPKCS12Iterations {
+			return fmt.Errorf("%w: MAC iterations %d", ErrUnsupportedPKCS12, macData.Iterations)
+		}
+		key = pkcs12KDF(newHash, macData.MacSalt, bmpPassword, macData.Iterations, pkcs12MACMaterial, newHash().Size())
+	}
+
+	mac := hmac.New(newHash, key)
+	mac.Write(message)
+	if !hmac.Equal(mac.Sum(nil), macData.Mac.Digest) {
+		return ErrIncorrectPassphrase
+	}
+	return nil
+}
+
+// bmpPassword encodes a password as a NUL terminated BMPString.
+func bmpPassword(passphrase []byte) []byte {
+	units := utf16.Encode([]rune(string(passphrase)))
+	encoded := make([]byte, 0, 2*len(units)+2)
+	for _, unit := range units {
+		encoded = append(encoded, byte(unit>>8), byte(unit))
+	}
+	return append(encoded, 0, 0)
+}
+
+// pkcs12KDF is the key derivation function of RFC 7292 appendix B.2.
+func pkcs12KDF(newHash func() hash.Hash, salt, password []byte, iterations int, id byte, size int) []byte {
+	h := newHash()
+	v := h.BlockSize()
+	// fill repeats data to a whole number of v byte blocks.
+	fill := func(data []byte) []byte {
+		if len(data) == 0 {
+			return nil
+		}
+		filled := make([]byte, v*((len(data)+v-1)/v))
+		for i := range filled {
+			filled[i] = data[i%len(data)]
+		}
+		return filled
+	}
+
+	d := bytes.Repeat([]byte{id}, v)
+	input := append(fill(salt), fill(password)...)
+	var derived []byte
+	for {
+		h.Reset()
+		h.Write(d)
+		h.Write(input)
+		a := h.Sum(nil)
+		for i := 1; i < iterations; i++ {
+			h.Reset()
+			h.Write(a)
+			a = h.Sum(a[:0])
+		}
+		derived = append(derived, a...)
+		if len(derived) >= size {
+			return derived[:size]
+		}
+
+		// Each block of the input becomes (block + B + 1) mod 2^(8v).
+		b := fill(a)
+		for j := 0; j < len(input); j += v {
+			carry := 1
+			for k := v - 1; k >= 0; k-- {
+				sum := int(input[j+k]) + int(b[k]) + carry
+				input[j+k] = byte(sum)
+				carry = sum >> 8
+			}
+		}
+	}
+}
@@ -0,0 +1,301 @@
+package certutils
+
+import (
+	"bytes"
+	"crypto"
+	"crypto/hmac"
+	"crypto/tls"
+	"crypto/x509"
+	"crypto/x509/pkix"
+	"encoding/asn1"
+	"encoding/hex"
+	"errors"
+
+	. "gopkg.in/check.v1"
+	"software.sslmate.com/src/go-pkcs12"
+)
+
+type PKCS12Suite struct {
+}
+
+var _ = Suite(&PKCS12Suite{})
+
+// testPKCS12Bag returns a safe bag with the given attributes.
+func testPKCS12Bag(c *C, id asn1.ObjectIdentifier, value []byte, friendlyName string, localKeyID []byte) pkcs12SafeBag {
+	bag := pkcs12SafeBag{
+		ID:    id,
+		Value: asn1.RawValue{Class: asn1.ClassContextSpecific, Tag: 0, IsCompound: true, Bytes: value},
+	}
+	if friendlyName != "" {
+		name := bmpPassword([]byte(friendlyName))
+		encoded, err := asn1.Marshal(asn1.RawValue{Tag: 30, Bytes: name[:len(name)-2]})
+		c.Assert(err, IsNil)
+		bag.Attributes = append(bag.Attributes, pkcs12Attribute{
+			ID:    oidPKCS12FriendlyName,
+			Value: asn1.RawValue{Tag: asn1.TagSet, IsCompound: true, Bytes: encoded},
+		})
+	}
+	if localKeyID != nil {
+		encoded, err := asn1.Marshal(localKeyID)
+		c.Assert(err, IsNil)
+		bag.Attributes = append(bag.Attributes, pkcs12Attribute{
+			ID:    oidPKCS12LocalKeyID,
+			Value: asn1.RawValue{Tag: asn1.TagSet, IsCompound: true, Bytes: encoded},
+		})
+	}
+	return bag
+}
+
+func testPKCS12KeyBag(c *C, key crypto.PrivateKey, friendlyName string, localKeyID []byte) pkcs12SafeBag {
+	der, err := x509.MarshalPKCS8PrivateKey(key)
+	c.Assert(err, IsNil)
+	return testPKCS12Bag(c, oidPKCS12KeyBag, der, friendlyName, localKeyID)
+}
+
+func testPKCS12CertBag(c *C, certificate *x509.Certificate, friendlyName string, localKeyID []byte) pkcs12SafeBag {
+	der, err := asn1.Marshal(pkcs12CertBag{ID: oidPKCS12X509Certificate, Data: certificate.Raw})
+	c.Assert(err, IsNil)
+	return testPKCS12Bag(c, oidPKCS12CertBag, der, friendlyName, localKeyID)
+}
+
+// encodeTestPKCS12 builds an unencrypted bundle with an HMAC-SHA256 MAC, holding each list of
+// bags in its own safe.
+func encodeTestPKCS12(c *C, passphrase []byte, safes ...[]pkcs12SafeBag) []byte {
+	dataContentInfo := func(data []byte) pkcs7ContentInfo {
+		octets, err := asn1.Marshal(data)
+		c.Assert(err, IsNil)
+		return pkcs7ContentInfo{
+			ContentType: oidPKCS7Data,
+			Content:     asn1.RawValue{Class: asn1.ClassContextSpecific, Tag: 0, IsCompound: true, Bytes: octets},
+		}
+	}
+
+	contents := []pkcs7ContentInfo{}
+	for _, bags := range safes {
+		safe, err := asn1.Marshal(bags)
+		c.Assert(err, IsNil)
+		contents = append(contents, dataContentInfo(safe))
+	}
+	authSafe, err := asn1.Marshal(contents)
+	c.Assert(err, IsNil)
+
+	salt := []byte("saltsalt")
+	key := pkcs12KDF(crypto.SHA256.New, salt, bmpPassword(passphrase), 2048, pkcs12MACMaterial, 32)
+	mac := hmac.New(crypto.SHA256.New, key)
+	mac.Write(authSafe)
+	pfx, err := asn1.Marshal(pfxPDU{
+		Version:  3,
+		AuthSafe: dataContentInfo(authSafe),
+		MacData: pkcs12MacData{
+			Mac: pkcs12DigestInfo{
+				Algorithm: pkix.AlgorithmIdentifier{Algorithm: ocspHashOIDs[crypto.SHA256], Parameters: asn1.NullRawValue},
+				Digest:    mac.Sum(nil),
+			},
+			MacSalt:    salt,
+			Iterations: 2048,
+		},
+	})
+	c.Assert(err, IsNil)
+	return pfx
+}
+
+func (s *PKCS12Suite) TestRC2(c *C) {
+	// Test vectors from RFC 2268 section 5.
+	for _, vector := range []struct {
+		key, plaintext, ciphertext string
+		effectiveBits              int
+	}{
+		{"0000000000000000", "0000000000000000", "ebb773f993278eff", 63},
+		{"ffffffffffffffff", "ffffffffffffffff", "278b27e42e2f0d49", 64},
+		{"3000000000000000", "1000000000000001", "30649edf9be7d2c2", 64},
+		{"88bca90e90875a7f0f79c384627bafb2", "0000000000000000", "2269552ab0f85ca6", 128},
+	} {
+		key, _ := hex.DecodeString(vector.key)
+		plaintext, _ := hex.DecodeString(vector.plaintext)
+		block := newRC2Cipher(key, vector.effectiveBits)
+		ciphertext := make([]byte, rc2BlockSize)
+		block.Encrypt(ciphertext, plaintext)
+		c.Check(hex.EncodeToString(ciphertext), Equals, vector.ciphertext)
+		decrypted := make([]byte, rc2BlockSize)
+		block.Decrypt(decrypted, ciphertext)
+		c.Check(decrypted, DeepEquals, plaintext)
+	}
+}
+
+func (s *PKCS12Suite) TestRoundTrip(c *C) {
+	intermediate, intermediateKey, root := newTestIntermediateCA(c)
+	certificate := newTestLeaf(c, intermediate, intermediateKey, PrivateKeyTypeRsa2048, "www.example.com")
+	certificate.Certificate = append(certificate.Certificate, root.Raw)
+	passphrase := []byte("pässwörd")
+
+	for _, encryption := range []PKCS12Encryption{PKCS12EncryptionAES256, PKCS12EncryptionLegacy3DES, PKCS12EncryptionLegacyRC2} {
+		pfx, err := EncodePKCS12(*certificate, passphrase, PKCS12Options{Encryption: encryption, Iterations: 1000})
+		c.Assert(err, IsNil)
+
+		bundle, err := DecodePKCS12(pfx, passphrase)
+		c.Assert(err, IsNil, Commentf("encryption %d", encryption))
+		c.Check(bundle.Keys, HasLen, 1)
+		c.Check(bundle.Certificates, HasLen, 3)
+		keyPair, err := bundle.KeyPair("")
+		c.Assert(err, IsNil)
+		c.Check(keyPair.Leaf.Equal(certificate.Leaf), Equals, true)
+		c.Check(keyPair.Intermediates, DeepEquals, []*x509.Certificate{intermediate})
+		c.Check(keyPair.Roots, DeepEquals, []*x509.Certificate{root})
+		c.Check(CheckKeyMatchesCertificate(keyPair.Certificate.PrivateKey, certificate.Leaf), IsNil)
+
+		_, err = DecodePKCS12(pfx, []byte("wrong"))
+		c.Check(err, Equals, ErrIncorrectPassphrase)
+	}
+
+	_, err := EncodePKCS12(*certificate, passphrase, PKCS12Options{Encryption: PKCS12Encryption(99)})
+	c.Check(errors.Is(err, ErrUnsupportedPKCS12), Equals, true)
+	other := newTestLeaf(c, intermediate, intermediateKey, PrivateKeyTypeEcp256, "www.example.com")
+	mismatched := *certificate
+	mismatched.PrivateKey = other.PrivateKey
+	_, err = EncodePKCS12(mismatched, passphrase, PKCS12Options{})
+	c.Check(err, Equals, ErrKeyMismatch)
+}
+
+func (s *PKCS12Suite) TestDecodesPBMAC1AndPasswordless(c *C) {
+	intermediate, intermediateKey, _ := newTestIntermediateCA(c)
+	certificate := newTestLeaf(c, intermediate, intermediateKey, PrivateKeyTypeEd25519, "www.example.com")
+	for _, test := range []struct {
+		encoder    *pkcs12.Encoder
+		passphrase string
+	}{
+		{pkcs12.Modern2026, "password"},
+		{pkcs12.Passwordless, ""},
+	} {
+		pfx, err := test.encoder.Encode(certificate.PrivateKey, certificate.Leaf, nil, test.passphrase)
+		c.Assert(err, IsNil)
+		bundle, err := DecodePKCS12(pfx, []byte(test.passphrase))
+		c.Assert(err, IsNil)
+		keyPair, err := bundle.KeyPair("")
+		c.Assert(err, IsNil)
+		c.Check(keyPair.Leaf.Equal(certificate.Leaf), Equals, true)
+	}
+}
+
+func (s *PKCS12Suite) TestRejectsUnauthenticatedAndCostlyBundles(c *C) {
+	intermediate, intermediateKey, _ := newTestIntermediateCA(c)
+	certificate := newTestLeaf(c, intermediate, intermediateKey, PrivateKeyTypeEcp256, "www.example.com")
+	passphrase := []byte("password")
+	pfx := encodeTestPKCS12(c, passphrase, []pkcs12SafeBag{
+		testPKCS12KeyBag(c, certificate.PrivateKey, "", nil),
+		testPKCS12CertBag(c, certificate.Leaf, "", nil),
+	})
+	rewrite := func(macData pkcs12MacData) []byte {
+		var parsed pfxPDU
+		_, err := asn1.Unmarshal(pfx, &parsed)
+		c.Assert(err, IsNil)
+		parsed.MacData = macData
+		rewritten, err := asn1.Marshal(parsed)
+		c.Assert(err, IsNil)
+		return rewritten
+	}
+
+	// Without a MAC nothing vouches for the passphrase.
+	unauthenticated := rewrite(pkcs12MacData{})
+	_, err := DecodePKCS12(unauthenticated, passphrase)
+	c.Check(err, Equals, ErrPKCS12NotAuthenticated)
+	bundle, err := DecodePKCS12(unauthenticated, nil)
+	c.Assert(err, IsNil)
+	c.Check(bundle.Keys, HasLen, 1)
+
+	var parsed pfxPDU
+	_, err = asn1.Unmarshal(pfx, &parsed)
+	c.Assert(err, IsNil)
+	macData := parsed.MacData
+	macData.Iterations = maxPKCS12Iterations + 1
+	_, err = DecodePKCS12(rewrite(macData), passphrase)
+	c.Check(errors.Is(err, ErrUnsupportedPKCS12), Equals, true)
+}
+
+func (s *PKCS12Suite) TestMultipleKeysAndFriendlyNames(c *C) {
+	intermediate, intermediateKey, root := newTestIntermediateCA(c)
+	server := newTestLeaf(c, intermediate, intermediateKey, PrivateKeyTypeEcp256, "server.example.com")
+	client := newTestLeaf(c, intermediate, intermediateKey, PrivateKeyTypeEd25519, "client.example.com")
+	passphrase := []byte("password")
+
+	// Keys and certificates in separate safes, the client certificate without a local key ID
+	// and the issuers in a nested bag.
+	pfx := encodeTestPKCS12(c, passphrase, []pkcs12SafeBag{
+		testPKCS12KeyBag(c, server.PrivateKey, "server", []byte{1}),
+		testPKCS12KeyBag(c, client.PrivateKey, "client", []byte{2}),
+	}, []pkcs12SafeBag{
+		testPKCS12CertBag(c, server.Leaf, "server", []byte{1}),
+		testPKCS12CertBag(c, client.Leaf, "client", nil),
+		testPKCS12Bag(c, oidPKCS12SafeContentsBag, func() []byte {
+			nested, err := asn1.Marshal([]pkcs12SafeBag{
+				testPKCS12CertBag(c, root, "Root CA", nil),
+				testPKCS12CertBag(c, intermediate, "Issuing CA", nil),
+			})
+			c.Assert(err, IsNil)
+			return nested
+		}(), "", nil),
+	})
+
+	bundle, err := DecodePKCS12(pfx, passphrase)
+	c.Assert(err, IsNil)
+	c.Assert(bundle.Keys, HasLen, 2)
+	c.Check(bundle.Keys[0].FriendlyName, Equals, "server")
+	c.Check(bundle.Keys[0].LocalKeyID, DeepEquals, []byte{1})
+	c.Check(bundle.Keys[1].FriendlyName, Equals, "client")
+	names := []string{}
+	for _, certificate := range bundle.Certificates {
+		names = append(names, certificate.FriendlyName)
+	}
+	c.Check(names, DeepEquals, []string{"server", "client", "Root CA", "Issuing CA"})
+
+	_, err = bundle.KeyPair("")
+	c.Check(err, Equals, ErrMultiplePrivateKeys)
+	_, err = bundle.KeyPair("missing")
+	c.Check(errors.Is(err, ErrNoPrivateKey), Equals, true)
+
+	for _, expected := range []*tls.Certificate{server, client} {
+		keyPair, err := bundle.KeyPair(expected.Leaf.Subject.CommonName[:6])
+		c.Assert(err, IsNil)
+		c.Check(keyPair.Leaf.Equal(expected.Leaf), Equals, true)
+		c.Check(keyPair.Certificate.Certificate, DeepEquals, [][]byte{expected.Leaf.Raw, intermediate.Raw})
+		c.Check(keyPair.Roots, DeepEquals, []*x509.Certificate{root})
+	}
+
+	// A local key ID pointing at the wrong certificate is not trusted.
+	pfx = encodeTestPKCS12(c, passphrase, []pkcs12SafeBag{
+		testPKCS12KeyBag(c, server.PrivateKey, "", []byte{1}),
+		testPKCS12CertBag(c, client.Leaf, "", []byte{1}),
+	})
+	bundle, err = DecodePKCS12(pfx, passphrase)
+	c.Assert(err, IsNil)
+	_, err = bundle.KeyPair("")
+	c.Check(errors.Is(err, ErrKeyMismatch), Equals, true)
+}
+
+func (s *PKCS12Suite) TestBERToDER(c *C) {
+	// An indefinite length SEQUENCE holding a chunked, indefinite length OCTET STRING.
+	ber, _ := hex.DecodeString("308024800402010204010300000201050000")
+	der, err := berToDER(ber)
+	c.Assert(err, IsNil)
+	c.Check(hex.EncodeToString(der), Equals, "30080403010203020105")
+
+	certificate, _ := newTestCA(c, PrivateKeyTypeEcp256)
+	der, err = berToDER(certificate.Raw)
+	c.Assert(err, IsNil)
+	c.Check(der, DeepEquals, certificate.Raw)
+
+	_, err = berToDER(ber[:len(ber)-2])
+	c.Check(err, Equals, errMalformedBER)
+
+	// Deeply nested input is rejected rather than overflowing the stack.
+	nested := bytes.Repeat([]byte{0x30, 0x80}, 1<<20)
+	_, err = berToDER(nested)
+	c.Check(err, Equals, errMalformedBER)
+	_, err = ParsePKCS7Certificates(nested)
+	c.Check(err, Equals, ErrNotPKCS7Certificates)
+	nested = append(bytes.Repeat([]byte{0x30, 0x80}, maxBERDepth+1), bytes.Repeat([]byte{0, 0}, maxBERDepth+1)...)
+	_, err = berToDER(nested)
+	c.Check(err, IsNil)
+	nested = append(bytes.Repeat([]byte{0x30, 0x80}, maxBERDepth+2), bytes.Repeat([]byte{0, 0}, maxBERDepth+2)...)
+	_, err = berToDER(nested)
+	c.Check(err, Equals, errMalformedBER)
+}
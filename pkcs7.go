@@ -0,0 +1,177 @@
+package certutils
+
+import (
+	"bytes"
+	"crypto/sha256"
+	"crypto/x509"
+	"crypto/x509/pkix"
+	"encoding/asn1"
+	"encoding/hex"
+	"encoding/pem"
+	"errors"
+	"fmt"
+	"net/http"
+	"time"
+)
+
+const (
+	// PKIXCertContentType and PKCS7MIMEContentType are the media types RFC 5280 section
+	// 4.2.2.1 gives for a caIssuers response holding a single certificate or a certs-only
+	// PKCS#7 bundle.
+	PKIXCertContentType  = "application/pkix-cert"
+	PKCS7MIMEContentType = "application/pkcs7-mime"
+	// DefaultCAIssuersMaxAge is how long clients may cache a caIssuers response.
+	DefaultCAIssuersMaxAge = 24 * time.Hour
+)
+
+var ErrNotPKCS7Certificates = errors.New("data is not a PKCS#7 certificate bundle")
+
+var (
+	oidPKCS7Data          = asn1.ObjectIdentifier{1, 2, 840, 113549, 1, 7, 1}
+	oidPKCS7SignedData    = asn1.ObjectIdentifier{1, 2, 840, 113549, 1, 7, 2}
+	oidPKCS7EncryptedData = asn1.ObjectIdentifier{1, 2, 840, 113549, 1, 7, 6}
+)
+
+// pkcs7ContentInfo is the ContentInfo of RFC 2315 section 7.
+type pkcs7ContentInfo struct {
+	ContentType asn1.ObjectIdentifier
+	Content     asn1.RawValue `asn1:"tag:0,explicit,optional"`
+}
+
+// pkcs7SignedData is a SignedData without signers, as used for certificate bundles.
+type pkcs7SignedData struct {
+	Version          int
+	DigestAlgorithms []pkix.AlgorithmIdentifier `asn1:"set"`
+	ContentInfo      pkcs7ContentInfo
+	Certificates     asn1.RawValue
+	SignerInfos      []asn1.RawValue `asn1:"set"`
+}
+
+// MarshalPKCS7Certificates returns the certificates as a DER encoded, degenerate PKCS#7
+// SignedData, as written by "openssl crl2pkcs7 -nocrl". The order of the certificates is kept.
+func MarshalPKCS7Certificates(certs ...*x509.Certificate) ([]byte, error) {
+	var raw []byte
+	for _, cert := range certs {
+		raw = append(raw, cert.Raw...)
+	}
+	signedData, err := asn1.Marshal(pkcs7SignedData{
+		Version:          1,
+		DigestAlgorithms: []pkix.AlgorithmIdentifier{},
+		ContentInfo:      pkcs7ContentInfo{ContentType: oidPKCS7Data},
+		Certificates:     asn1.RawValue{Class: asn1.ClassContextSpecific, Tag: 0, IsCompound: true, Bytes: raw},
+		SignerInfos:      []asn1.RawValue{},
+	})
+	if err != nil {
+		return nil, err
+	}
+	return asn1.Marshal(pkcs7ContentInfo{
+		ContentType: oidPKCS7SignedData,
+		Content:     asn1.RawValue{Class: asn1.ClassContextSpecific, Tag: 0, IsCompound: true, Bytes: signedData},
+	})
+}
+
+// EncodePKCS7Certificates returns the certificates as a PEM encoded PKCS#7 bundle.
+func EncodePKCS7Certificates(certs ...*x509.Certificate) ([]byte, error) {
+	der, err := MarshalPKCS7Certificates(certs...)
+	if err != nil {
+		return nil, err
+	}
+	return pem.EncodeToMemory(&pem.Block{Type: PKCS7BlockType, Bytes: der}), nil
+}
+
+// ParsePKCS7Certificates returns the certificates held by a DER or BER encoded PKCS#7
+// SignedData, such as a .p7b or .p7c file. Signatures, if there are any, are not verified.
+func ParsePKCS7Certificates(data []byte) ([]*x509.Certificate, error) {
+	der, err := berToDER(data)
+	if err != nil {
+		return nil, ErrNotPKCS7Certificates
+	}
+	var info pkcs7ContentInfo
+	if rest, err := asn1.Unmarshal(der, &info); err != nil || len(rest) > 0 || !info.ContentType.Equal(oidPKCS7SignedData) {
+		return nil, ErrNotPKCS7Certificates
+	}
+	var signedData asn1.RawValue
+	if _, err := asn1.Unmarshal(info.Content.Bytes, &signedData); err != nil || signedData.Tag != asn1.TagSequence {
+		return nil, ErrNotPKCS7Certificates
+	}
+
+	// The certificates are the [0] field following the version, digest algorithms and content.
+	certificates := []*x509.Certificate{}
+	for rest := signedData.Bytes; len(rest) > 0; {
+		var field asn1.RawValue
+		if rest, err = asn1.Unmarshal(rest, &field); err != nil {
+			return nil, ErrNotPKCS7Certificates
+		}
+		if field.Class != asn1.ClassContextSpecific || field.Tag != 0 {
+			continue
+		}
+		for remaining := field.Bytes; len(remaining) > 0; {
+			var choice asn1.RawValue
+			if remaining, err = asn1.Unmarshal(remaining, &choice); err != nil {
+				return nil, ErrNotPKCS7Certificates
+			}
+			// The other choices are extended and attribute certificates.
+			if choice.Class != asn1.ClassUniversal || choice.Tag != asn1.TagSequence {
+				continue
+			}
+			certificate, err := x509.ParseCertificate(choice.FullBytes)
+			if err != nil {
+				return nil, err
+			}
+			certificates = append(certificates, certificate)
+		}
+	}
+	return certificates, nil
+}
+
+// CAIssuersHandler is an http.Handler serving the certificates published at the caIssuers
+// URL of an authority's certificates: a single certificate as DER, or several as a certs-only
+// PKCS#7 bundle.
+type CAIssuersHandler struct {
+	Certificates []*x509.Certificate
+	// MaxAge is sent in the Cache-Control header. Defaults to DefaultCAIssuersMaxAge.
+	MaxAge time.Duration
+}
+
+// NewCAIssuersHandler returns a CAIssuersHandler serving the certificates.
+func NewCAIssuersHandler(certificates ...*x509.Certificate) *CAIssuersHandler {
+	return &CAIssuersHandler{Certificates: certificates}
+}
+
+// ServeHTTP implements http.Handler for GET and HEAD requests, answering conditional
+// requests against the ETag of the response.
+func (h *CAIssuersHandler) ServeHTTP(w http.ResponseWriter, req *http.Request) {
+	if req.Method != http.MethodGet && req.Method != http.MethodHead {
+		w.Header().Set("Allow", "GET, HEAD")
+		http.Error(w, http.StatusText(http.StatusMethodNotAllowed), http.StatusMethodNotAllowed)
+		return
+	}
+
+	var body []byte
+	var contentType string
+	switch len(h.Certificates) {
+	case 0:
+		http.NotFound(w, req)
+		return
+	case 1:
+		body, contentType = h.Certificates[0].Raw, PKIXCertContentType
+	default:
+		der, err := MarshalPKCS7Certificates(h.Certificates...)
+		if err != nil {
+			http.Error(w, http.StatusText(http.StatusInternalServerError), http.StatusInternalServerError)
+			return
+		}
+		body, contentType = der, PKCS7MIMEContentType
+	}
+
+	maxAge := h.MaxAge
+	if maxAge <= 0 {
+		maxAge = DefaultCAIssuersMaxAge
+	}
+	digest := sha256.Sum256(body)
+	header := w.Header()
+	header.Set("Content-Type", contentType)
+	header.Set("Cache-Control", fmt.Sprintf("max-age=%d, public", int(maxAge.Seconds())))
+	header.Set("ETag", fmt.Sprintf("%q", hex.EncodeToString(digest[:])))
+	http.ServeContent(w, req, "", time.Time{}, bytes.NewReader(body))
+}
@@ -0,0 +1,129 @@
+package certutils
+
+import (
+	"crypto/x509"
+	"encoding/asn1"
+	"encoding/pem"
+	"net/http"
+	"net/http/httptest"
+
+	. "gopkg.in/check.v1"
+)
+
+type PKCS7Suite struct {
+}
+
+var _ = Suite(&PKCS7Suite{})
+
+// indefiniteLengthBER re-encodes every constructed element of der with an indefinite length,
+// as Windows does.
+func indefiniteLengthBER(c *C, der []byte) []byte {
+	var ber []byte
+	for len(der) > 0 {
+		var element asn1.RawValue
+		var err error
+		der, err = asn1.Unmarshal(der, &element)
+		c.Assert(err, IsNil)
+		if !element.IsCompound {
+			ber = append(ber, element.FullBytes...)
+			continue
+		}
+		ber = append(ber, element.FullBytes[0], 0x80)
+		ber = append(ber, indefiniteLengthBER(c, element.Bytes)...)
+		ber = append(ber, 0, 0)
+	}
+	return ber
+}
+
+func (s *PKCS7Suite) TestRoundTrip(c *C) {
+	intermediate, _, root := newTestIntermediateCA(c)
+	chain := []*x509.Certificate{intermediate, root}
+
+	der, err := MarshalPKCS7Certificates(chain...)
+	c.Assert(err, IsNil)
+	parsed, err := ParsePKCS7Certificates(der)
+	c.Assert(err, IsNil)
+	c.Check(parsed, DeepEquals, chain)
+
+	parsed, err = ParsePKCS7Certificates(indefiniteLengthBER(c, der))
+	c.Assert(err, IsNil)
+	c.Check(parsed, DeepEquals, chain)
+
+	empty, err := MarshalPKCS7Certificates()
+	c.Assert(err, IsNil)
+	parsed, err = ParsePKCS7Certificates(empty)
+	c.Assert(err, IsNil)
+	c.Check(parsed, HasLen, 0)
+
+	_, err = ParsePKCS7Certificates(root.Raw)
+	c.Check(err, Equals, ErrNotPKCS7Certificates)
+	_, err = ParsePKCS7Certificates(der[:len(der)-1])
+	c.Check(err, Equals, ErrNotPKCS7Certificates)
+}
+
+func (s *PKCS7Suite) TestLoadCertificatesFromPem(c *C) {
+	intermediate, intermediateKey, root := newTestIntermediateCA(c)
+	leaf := newTestLeaf(c, intermediate, intermediateKey, PrivateKeyTypeEcp256, "www.example.com")
+
+	bundle, err := EncodePKCS7Certificates(intermediate, root)
+	c.Assert(err, IsNil)
+	block, _ := pem.Decode(bundle)
+	c.Assert(block, NotNil)
+	c.Check(block.Type, Equals, PKCS7BlockType)
+
+	leafPEM, err := EncodeCertificates(leaf.Leaf)
+	c.Assert(err, IsNil)
+	certs, err := LoadCertificatesFromPem(append(leafPEM, bundle...))
+	c.Assert(err, IsNil)
+	c.Check(certs, DeepEquals, []*x509.Certificate{leaf.Leaf, intermediate, root})
+
+	corrupt := pem.EncodeToMemory(&pem.Block{Type: PKCS7BlockType, Bytes: block.Bytes[:10]})
+	_, err = LoadCertificatesFromPem(append(leafPEM, corrupt...))
+	c.Check(err, ErrorMatches, "error on block 1: .*")
+
+	// A DER bundle can be used as the CA file of a key pair.
+	der, err := MarshalPKCS7Certificates(root)
+	c.Assert(err, IsNil)
+	certPEM, keyPEM, err := EncodeX509KeyPair(*leaf)
+	c.Assert(err, IsNil)
+	keyPair, err := ParseKeyPair(certPEM, keyPEM, der, nil)
+	c.Assert(err, IsNil)
+	c.Check(keyPair.Roots, DeepEquals, []*x509.Certificate{root})
+}
+
+func (s *PKCS7Suite) TestCAIssuersHandler(c *C) {
+	intermediate, _, root := newTestIntermediateCA(c)
+	handler := NewCAIssuersHandler(intermediate)
+	serve := func(method string, header http.Header) *httptest.ResponseRecorder {
+		recorder := httptest.NewRecorder()
+		request := httptest.NewRequest(method, "/issuing-ca.crt", nil)
+		for key, values := range header {
+			request.Header[key] = values
+		}
+		handler.ServeHTTP(recorder, request)
+		return recorder
+	}
+
+	response := serve(http.MethodGet, nil)
+	c.Check(response.Code, Equals, http.StatusOK)
+	c.Check(response.Header().Get("Content-Type"), Equals, PKIXCertContentType)
+	c.Check(response.Header().Get("Cache-Control"), Equals, "max-age=86400, public")
+	c.Check(response.Body.Bytes(), DeepEquals, intermediate.Raw)
+
+	etag := response.Header().Get("ETag")
+	c.Check(serve(http.MethodGet, http.Header{"If-None-Match": {etag}}).Code, Equals, http.StatusNotModified)
+	c.Check(serve(http.MethodHead, nil).Body.Len(), Equals, 0)
+	response = serve(http.MethodPost, nil)
+	c.Check(response.Code, Equals, http.StatusMethodNotAllowed)
+	c.Check(response.Header().Get("Allow"), Equals, "GET, HEAD")
+
+	handler.Certificates = append(handler.Certificates, root)
+	response = serve(http.MethodGet, nil)
+	c.Check(response.Header().Get("Content-Type"), Equals, PKCS7MIMEContentType)
+	certs, err := ParsePKCS7Certificates(response.Body.Bytes())
+	c.Assert(err, IsNil)
+	c.Check(certs, DeepEquals, []*x509.Certificate{intermediate, root})
+
+	handler.Certificates = nil
+	c.Check(serve(http.MethodGet, nil).Code, Equals, http.StatusNotFound)
+}
@@ -2,7 +2,9 @@
 package certutils
 
 import (
+	"crypto"
 	"crypto/ecdsa"
+	"crypto/ed25519"
 	"crypto/elliptic"
 	"crypto/rand"
 	"crypto/rsa"
@@ -25,17 +27,16 @@ func (e ErrUnknownPrivateKey) Error() string {
 	return "unknown private key type"
 }
 
-// PrivateKeyType is the list of allowable RSA bit lengths
-// ENUM(rsa2048, rsa3076, rsa4096, ecp256, ecp384, ecp521)
+// PrivateKeyType is the list of allowable private key types
+// ENUM(rsa2048, rsa3076, rsa4096, ecp256, ecp384, ecp521, ed25519)
 type PrivateKeyType string
 
-// PublicKey detects the type of key and returns its PublicKey.
+// PublicKey detects the type of key and returns its PublicKey. Any crypto.Signer is
+// supported, which allows keys held in hardware tokens or remote key stores.
 func PublicKey(priv interface{}) interface{} {
 	switch key := priv.(type) {
-	case *rsa.PrivateKey:
-		return &key.PublicKey
-	case *ecdsa.PrivateKey:
-		return &key.PublicKey
+	case crypto.Signer:
+		return key.Public()
 	case x509.Certificate:
 		// For handling CSR requests
 		return key.PublicKey
@@ -45,10 +46,27 @@ func PublicKey(priv interface{}) interface{} {
 }
 
 // GetPrivateKeyType returns the type of private key according to the known
-// types in this package, or an error if it does not match.
+// types in this package, or an error if it does not match. The type is determined
+// from the public half of the key, so any crypto.Signer is supported.
 func GetPrivateKeyType(priv interface{}) (PrivateKeyType, error) {
-	switch key := priv.(type) {
-	case *rsa.PrivateKey:
+	signer, ok := priv.(crypto.Signer)
+	if !ok {
+		return "", &ErrUnknownPrivateKey{}
+	}
+	return GetPublicKeyType(signer.Public())
+}
+
+// publicKeysEqual reports whether two public keys are the same key.
+func publicKeysEqual(a crypto.PublicKey, b crypto.PublicKey) bool {
+	key, ok := a.(interface{ Equal(crypto.PublicKey) bool })
+	return ok && key.Equal(b)
+}
+
+// GetPublicKeyType returns the type of key pair the public key belongs to according
+// to the known types in this package, or an error if it does not match.
+func GetPublicKeyType(pub crypto.PublicKey) (PrivateKeyType, error) {
+	switch key := pub.(type) {
+	case *rsa.PublicKey:
 		switch key.N.BitLen() {
 		case 2048:
 			return PrivateKeyTypeRsa2048, nil
@@ -59,39 +77,50 @@ func GetPrivateKeyType(priv interface{}) (PrivateKeyType, error) {
 		default:
 			return "", &ErrUnknownPrivateKey{}
 		}
-	case *ecdsa.PrivateKey:
+	case *ecdsa.PublicKey:
 		switch key.Curve.Params().Name {
 		case elliptic.P256().Params().Name:
 			return PrivateKeyTypeEcp256, nil
-		case elliptic.P256().Params().Name:
+		case elliptic.P384().Params().Name:
 			return PrivateKeyTypeEcp384, nil
-		case elliptic.P256().Params().Name:
+		case elliptic.P521().Params().Name:
 			return PrivateKeyTypeEcp521, nil
 		default:
 			return "", &ErrUnknownPrivateKey{}
 		}
+	case ed25519.PublicKey:
+		return PrivateKeyTypeEd25519, nil
 	default:
 		return "", &ErrUnknownPrivateKey{}
 	}
 }
 
 // GeneratePrivateKey generates a new secure private key based on the type requested.
-func GeneratePrivateKey(keyType PrivateKeyType) (interface{}, error) {
+func GeneratePrivateKey(keyType PrivateKeyType) (crypto.Signer, error) {
+	var key crypto.Signer
+	var err error
 	switch keyType {
 	case PrivateKeyTypeRsa2048:
-		return rsa.GenerateKey(rand.Reader, 2048)
+		key, err = rsa.GenerateKey(rand.Reader, 2048)
 	case PrivateKeyTypeRsa3076:
-		return rsa.GenerateKey(rand.Reader, 3072)
+		key, err = rsa.GenerateKey(rand.Reader, 3072)
 	case PrivateKeyTypeRsa4096:
-		return rsa.GenerateKey(rand.Reader, 4096)
+		key, err = rsa.GenerateKey(rand.Reader, 4096)
 	// P224 curve is disabled because Red Hat disable it.
 	case PrivateKeyTypeEcp256:
-		return ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+		key, err = ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
 	case PrivateKeyTypeEcp384:
-		return ecdsa.GenerateKey(elliptic.P384(), rand.Reader)
+		key, err = ecdsa.GenerateKey(elliptic.P384(), rand.Reader)
 	case PrivateKeyTypeEcp521:
-		return ecdsa.GenerateKey(elliptic.P521(), rand.Reader)
+		key, err = ecdsa.GenerateKey(elliptic.P521(), rand.Reader)
+	case PrivateKeyTypeEd25519:
+		_, key, err = ed25519.GenerateKey(rand.Reader)
 	default:
 		return nil, &ErrPrivateKeyGeneration{fmt.Sprintf("unknown key type: %s", keyType)}
 	}
+	if err != nil {
+		// Avoid returning a typed nil inside the interface.
+		return nil, err
+	}
+	return key, nil
 }
@@ -24,6 +24,8 @@ const (
 	PrivateKeyTypeEcp384 PrivateKeyType = "ecp384"
 	// PrivateKeyTypeEcp521 is a PrivateKeyType of type ecp521.
 	PrivateKeyTypeEcp521 PrivateKeyType = "ecp521"
+	// PrivateKeyTypeEd25519 is a PrivateKeyType of type ed25519.
+	PrivateKeyTypeEd25519 PrivateKeyType = "ed25519"
 )
 
 var ErrInvalidPrivateKeyType = fmt.Errorf("not a valid PrivateKeyType, try [%s]", strings.Join(_PrivateKeyTypeNames, ", "))
@@ -35,6 +37,7 @@ var _PrivateKeyTypeNames = []string{
 	string(PrivateKeyTypeEcp256),
 	string(PrivateKeyTypeEcp384),
 	string(PrivateKeyTypeEcp521),
+	string(PrivateKeyTypeEd25519),
 }
 
 // PrivateKeyTypeNames returns a list of possible string values of PrivateKeyType.
@@ -63,6 +66,7 @@ var _PrivateKeyTypeValue = map[string]PrivateKeyType{
 	"ecp256":  PrivateKeyTypeEcp256,
 	"ecp384":  PrivateKeyTypeEcp384,
 	"ecp521":  PrivateKeyTypeEcp521,
+	"ed25519": PrivateKeyTypeEd25519,
 }
 
 // ParsePrivateKeyType attempts to convert a string to a PrivateKeyType.
@@ -0,0 +1,62 @@
+package certutils
+
+import (
+	"crypto/ed25519"
+	"crypto/x509"
+	"crypto/x509/pkix"
+
+	. "gopkg.in/check.v1"
+)
+
+type PrivateKeyTypeSuite struct {
+}
+
+var _ = Suite(&PrivateKeyTypeSuite{})
+
+func (s *PrivateKeyTypeSuite) TestGetPrivateKeyType(c *C) {
+	for _, keyType := range []PrivateKeyType{PrivateKeyTypeRsa2048, PrivateKeyTypeEcp256,
+		PrivateKeyTypeEcp384, PrivateKeyTypeEcp521, PrivateKeyTypeEd25519} {
+		key, err := GeneratePrivateKey(keyType)
+		c.Assert(err, IsNil)
+
+		detected, err := GetPrivateKeyType(key)
+		c.Assert(err, IsNil)
+		c.Check(detected, Equals, keyType)
+	}
+}
+
+func (s *PrivateKeyTypeSuite) TestEd25519RoundTrip(c *C) {
+	key, err := GeneratePrivateKey(PrivateKeyTypeEd25519)
+	c.Assert(err, IsNil)
+
+	encoded, err := EncodeKeys(key)
+	c.Assert(err, IsNil)
+
+	keys, err := LoadPrivateKeysFromPem(encoded)
+	c.Assert(err, IsNil)
+	c.Assert(keys, HasLen, 1)
+	c.Assert(keys[0], DeepEquals, key)
+
+	csr, err := GenerateCSR(pkix.Name{CommonName: "ed25519 CA"}, CSRParameters{
+		KeyUsage: x509.KeyUsageCertSign | x509.KeyUsageCRLSign,
+		IsCA:     true,
+	}, key)
+	c.Assert(err, IsNil)
+	c.Assert(csr.PublicKeyAlgorithm, Equals, x509.Ed25519)
+
+	ca, err := SignCertificate(csr, nil, key, SigningParameters{
+		NotBefore: CertificateNotBefore(),
+		NotAfter:  CACertificateNotAfter(0),
+	})
+	c.Assert(err, IsNil)
+	c.Assert(ca.SignatureAlgorithm, Equals, x509.PureEd25519)
+	c.Assert(ca.PublicKey, DeepEquals, PublicKey(key))
+
+	leaf := RequestTLSCertificate(ca, key, SigningParameters{
+		NotBefore: CertificateNotBefore(),
+		NotAfter:  CertificateNotAfter(0, ca),
+	}, PrivateKeyTypeEd25519, "ed25519.example.com")
+	c.Assert(leaf, NotNil)
+	c.Assert(leaf.PrivateKey, FitsTypeOf, ed25519.PrivateKey{})
+	c.Assert(leaf.Leaf.CheckSignatureFrom(ca), IsNil)
+}
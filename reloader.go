@@ -0,0 +1,304 @@
+package certutils
+
+import (
+	"bytes"
+	"context"
+	"crypto/sha256"
+	"crypto/tls"
+	"crypto/x509"
+	"errors"
+	"os"
+	"path/filepath"
+	"sync"
+	"sync/atomic"
+	"time"
+
+	"github.com/fsnotify/fsnotify"
+	"github.com/spf13/afero"
+)
+
+const (
+	// DefaultReloadInterval is how often a CertificateReloader polls its files.
+	DefaultReloadInterval = time.Minute
+
+	// reloadSettleDelay batches the burst of events produced by a single update.
+	reloadSettleDelay = 100 * time.Millisecond
+)
+
+var ErrNoServerName = errors.New("no server name to verify the server certificate against; set ServerName in the tls.Config")
+
+// CertificateReloader serves a key pair loaded with LoadKeyPairFiles and reloads it when
+// the files change, so renewed certificates are picked up without a restart. Files are polled,
+// and on the operating system filesystem also watched with inotify or its equivalent. An update
+// which cannot be loaded, such as a half-written file or a key which does not match the new
+// certificate, is reported and the last good pair is kept until a later update loads.
+type CertificateReloader struct {
+	// Interval is how often the files are polled. Defaults to DefaultReloadInterval.
+	Interval time.Duration
+	// DisableNotify stops Run watching the files for changes between polls.
+	DisableNotify bool
+	// OnReload, if set, is called by Run with each newly loaded pair.
+	OnReload func(*KeyPair)
+
+	fs         afero.Fs
+	certFile   string
+	keyFile    string
+	caFile     string
+	passphrase PassphraseFunc
+
+	keyPair atomic.Pointer[KeyPair]
+	roots   atomic.Pointer[x509.CertPool]
+
+	mu      sync.Mutex
+	checked []byte
+}
+
+// NewCertificateReloader loads the key pair, failing if it cannot be loaded. keyFile and
+// caFile are optional as for LoadKeyPairFiles.
+func NewCertificateReloader(fs afero.Fs, certFile, keyFile, caFile string, passphrase PassphraseFunc) (*CertificateReloader, error) {
+	r := &CertificateReloader{
+		fs:         fs,
+		certFile:   certFile,
+		keyFile:    keyFile,
+		caFile:     caFile,
+		passphrase: passphrase,
+	}
+	if _, err := r.Reload(); err != nil {
+		return nil, err
+	}
+	return r, nil
+}
+
+func (r *CertificateReloader) interval() time.Duration {
+	if r.Interval <= 0 {
+		return DefaultReloadInterval
+	}
+	return r.Interval
+}
+
+// KeyPair returns the current key pair.
+func (r *CertificateReloader) KeyPair() *KeyPair {
+	return r.keyPair.Load()
+}
+
+// Reload loads the files if their contents have changed since the last successful load, and
+// reports whether a new pair is being served. On error the current pair is kept.
+func (r *CertificateReloader) Reload() (bool, error) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	hash := sha256.New()
+	contents := map[string][]byte{}
+	for _, name := range []string{r.certFile, r.keyFile, r.caFile} {
+		if name == "" {
+			continue
+		}
+		data, err := afero.ReadFile(r.fs, name)
+		if err != nil {
+			return false, err
+		}
+		contents[name] = data
+		hash.Write([]byte(name))
+		hash.Write(data)
+	}
+	checked := hash.Sum(nil)
+	if bytes.Equal(checked, r.checked) {
+		return false, nil
+	}
+
+	var keyData, caData []byte
+	if r.keyFile != "" && r.keyFile != r.certFile {
+		keyData = contents[r.keyFile]
+	}
+	if r.caFile != "" {
+		caData = contents[r.caFile]
+	}
+	keyPair, err := ParseKeyPair(contents[r.certFile], keyData, caData, r.passphrase)
+	var mismatch *ErrKeyPairMismatch
+	if errors.As(err, &mismatch) {
+		mismatch.CertFile = r.certFile
+		mismatch.KeyFile = r.keyFile
+		if r.keyFile == "" {
+			mismatch.KeyFile = r.certFile
+		}
+	}
+	if err != nil {
+		return false, err
+	}
+
+	r.roots.Store(keyPair.RootPool())
+	r.keyPair.Store(keyPair)
+	r.checked = checked
+	return true, nil
+}
+
+// Run reloads the files every Interval, and when they change if notification is available,
+// until the context is cancelled. Errors are passed to onError, which may be nil.
+func (r *CertificateReloader) Run(ctx context.Context, onError func(error)) {
+	reload := func() {
+		reloaded, err := r.Reload()
+		if err != nil {
+			if onError != nil {
+				onError(err)
+			}
+		} else if reloaded && r.OnReload != nil {
+			r.OnReload(r.KeyPair())
+		}
+	}
+
+	var events <-chan fsnotify.Event
+	var watchErrors <-chan error
+	if _, isOsFs := r.fs.(*afero.OsFs); isOsFs && !r.DisableNotify {
+		watcher, err := r.watch()
+		if err != nil {
+			if onError != nil {
+				onError(err)
+			}
+		} else {
+			defer watcher.Close()
+			events = watcher.Events
+			watchErrors = watcher.Errors
+		}
+	}
+
+	ticker := time.NewTicker(r.interval())
+	defer ticker.Stop()
+	settle := time.NewTimer(0)
+	if !settle.Stop() {
+		<-settle.C
+	}
+	defer settle.Stop()
+
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case <-ticker.C:
+			reload()
+		case <-events:
+			settle.Reset(reloadSettleDelay)
+		case <-settle.C:
+			reload()
+		case err := <-watchErrors:
+			if onError != nil {
+				onError(err)
+			}
+		}
+	}
+}
+
+// watch watches the directories holding the files, since updates commonly replace a file by
+// renaming over it or, in Kubernetes, by swapping a symlinked directory.
+func (r *CertificateReloader) watch() (*fsnotify.Watcher, error) {
+	watcher, err := fsnotify.NewWatcher()
+	if err != nil {
+		return nil, err
+	}
+	dirs := map[string]bool{}
+	for _, name := range []string{r.certFile, r.keyFile, r.caFile} {
+		if name != "" {
+			dirs[filepath.Dir(name)] = true
+		}
+	}
+	for dir := range dirs {
+		if err := watcher.Add(dir); err != nil && !errors.Is(err, os.ErrNotExist) {
+			watcher.Close()
+			return nil, err
+		}
+	}
+	return watcher, nil
+}
+
+// GetCertificate can be used as tls.Config.GetCertificate.
+func (r *CertificateReloader) GetCertificate(*tls.ClientHelloInfo) (*tls.Certificate, error) {
+	return &r.KeyPair().Certificate, nil
+}
+
+// GetClientCertificate can be used as tls.Config.GetClientCertificate.
+func (r *CertificateReloader) GetClientCertificate(*tls.CertificateRequestInfo) (*tls.Certificate, error) {
+	return &r.KeyPair().Certificate, nil
+}
+
+// CertPool returns the current trust anchors from the CA file, or nil if there are none.
+func (r *CertificateReloader) CertPool() *x509.CertPool {
+	return r.roots.Load()
+}
+
+// ServerConfig returns a copy of config which serves the current certificate and, through
+// GetConfigForClient, uses the current trust anchors as ClientCAs. config may be nil.
+func (r *CertificateReloader) ServerConfig(config *tls.Config) *tls.Config {
+	server := &tls.Config{}
+	if config != nil {
+		server = config.Clone()
+	}
+	server.Certificates = nil
+	server.GetCertificate = r.GetCertificate
+	server.GetConfigForClient = func(*tls.ClientHelloInfo) (*tls.Config, error) {
+		connection := server.Clone()
+		connection.GetConfigForClient = nil
+		if roots := r.CertPool(); roots != nil {
+			connection.ClientCAs = roots
+		}
+		return connection, nil
+	}
+	return server
+}
+
+// ClientConfig returns a copy of config which presents the current certificate and verifies
+// servers against the current trust anchors, or config.RootCAs if the CA file has none. As
+// tls.Config has no callback for RootCAs, the built-in verification is replaced by an
+// equivalent VerifyConnection. config may be nil.
+//
+// The server name is config.ServerName, or otherwise the name sent in SNI. Connections to a
+// server addressed by IP therefore need config.ServerName set to the IP, and fail otherwise.
+func (r *CertificateReloader) ClientConfig(config *tls.Config) *tls.Config {
+	client := &tls.Config{}
+	if config != nil {
+		client = config.Clone()
+	}
+	client.Certificates = nil
+	client.GetClientCertificate = r.GetClientCertificate
+	if client.InsecureSkipVerify {
+		return client
+	}
+
+	verifyConnection := client.VerifyConnection
+	serverName, rootCAs, now := client.ServerName, client.RootCAs, client.Time
+	client.InsecureSkipVerify = true
+	client.VerifyConnection = func(state tls.ConnectionState) error {
+		if len(state.PeerCertificates) == 0 {
+			return errors.New("server presented no certificate")
+		}
+		name := serverName
+		if name == "" {
+			name = state.ServerName
+		}
+		if name == "" {
+			return ErrNoServerName
+		}
+		roots := r.CertPool()
+		if roots == nil {
+			roots = rootCAs
+		}
+		options := x509.VerifyOptions{
+			Roots:         roots,
+			Intermediates: x509.NewCertPool(),
+			DNSName:       name,
+		}
+		if now != nil {
+			options.CurrentTime = now()
+		}
+		for _, certificate := range state.PeerCertificates[1:] {
+			options.Intermediates.AddCert(certificate)
+		}
+		_, err := state.PeerCertificates[0].Verify(options)
+		if err != nil {
+			return err
+		}
+		if verifyConnection != nil {
+			return verifyConnection(state)
+		}
+		return nil
+	}
+	return client
+}
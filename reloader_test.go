@@ -0,0 +1,221 @@
+package certutils
+
+import (
+	"context"
+	"crypto/tls"
+	"crypto/x509"
+	"errors"
+	"net"
+	"path/filepath"
+	"time"
+
+	"github.com/spf13/afero"
+	. "gopkg.in/check.v1"
+)
+
+type CertificateReloaderSuite struct {
+}
+
+var _ = Suite(&CertificateReloaderSuite{})
+
+// saveReloaderTestFiles writes a key pair and a CA as tls.crt, tls.key and ca.crt in dir. The
+// issuer is left out of tls.crt so ca.crt alone decides what is trusted.
+func saveReloaderTestFiles(c *C, fs afero.Fs, dir string, certificate *tls.Certificate, ca *x509.Certificate) {
+	c.Assert(fs.MkdirAll(dir, 0755), IsNil)
+	leafOnly := *certificate
+	leafOnly.Certificate = leafOnly.Certificate[:1]
+	c.Assert(SaveX509KeyPair(fs, filepath.Join(dir, KubernetesTLSCertFile), filepath.Join(dir, KubernetesTLSKeyFile), leafOnly), IsNil)
+	caPEM, err := EncodeCertificates(ca)
+	c.Assert(err, IsNil)
+	c.Assert(writeFileAtomic(fs, filepath.Join(dir, KubernetesCAFile), caPEM, 0644), IsNil)
+}
+
+func newTestReloader(c *C, fs afero.Fs, dir string) *CertificateReloader {
+	reloader, err := NewCertificateReloader(fs, filepath.Join(dir, KubernetesTLSCertFile),
+		filepath.Join(dir, KubernetesTLSKeyFile), filepath.Join(dir, KubernetesCAFile), nil)
+	c.Assert(err, IsNil)
+	return reloader
+}
+
+// reloaderTestHandshake runs a TLS handshake between the configs over loopback TCP, returning
+// the client and server errors. Unlike net.Pipe, TCP buffers a failing side's alert while the
+// other is still writing.
+func reloaderTestHandshake(c *C, serverConfig, clientConfig *tls.Config) (error, error) {
+	listener, err := net.Listen("tcp", "127.0.0.1:0")
+	c.Assert(err, IsNil)
+	defer listener.Close()
+	serverErr := make(chan error, 1)
+	go func() {
+		serverConn, err := listener.Accept()
+		if err != nil {
+			serverErr <- err
+			return
+		}
+		serverErr <- tls.Server(serverConn, serverConfig).Handshake()
+		serverConn.Close()
+	}()
+	clientConn, err := net.Dial("tcp", listener.Addr().String())
+	c.Assert(err, IsNil)
+	clientErr := tls.Client(clientConn, clientConfig).Handshake()
+	clientConn.Close()
+	return clientErr, <-serverErr
+}
+
+func (s *CertificateReloaderSuite) TestKeepsLastGoodPair(c *C) {
+	fs := afero.NewMemMapFs()
+	ca, caKey := newTestCA(c, PrivateKeyTypeEcp256)
+	first := newTestLeaf(c, ca, caKey, PrivateKeyTypeEcp256, "www.example.com", x509.ExtKeyUsageServerAuth)
+	saveReloaderTestFiles(c, fs, "/tls", first, ca)
+
+	reloader := newTestReloader(c, fs, "/tls")
+	served, err := reloader.GetCertificate(nil)
+	c.Assert(err, IsNil)
+	c.Check(served.Leaf.Equal(first.Leaf), Equals, true)
+	c.Check(reloader.CertPool().Equal(reloader.KeyPair().RootPool()), Equals, true)
+
+	reloaded, err := reloader.Reload()
+	c.Assert(err, IsNil)
+	c.Check(reloaded, Equals, false)
+
+	// A half-written certificate.
+	second := newTestLeaf(c, ca, caKey, PrivateKeyTypeEcp256, "www.example.com", x509.ExtKeyUsageServerAuth)
+	secondPEM, err := EncodeCertificates(second.Leaf)
+	c.Assert(err, IsNil)
+	c.Assert(afero.WriteFile(fs, "/tls/tls.crt", secondPEM[:len(secondPEM)/2], 0644), IsNil)
+	_, err = reloader.Reload()
+	c.Check(err, Equals, ErrNoCertificate)
+
+	// The certificate has been replaced but the key has not.
+	c.Assert(afero.WriteFile(fs, "/tls/tls.crt", secondPEM, 0644), IsNil)
+	_, err = reloader.Reload()
+	c.Check(errors.Is(err, ErrKeyMismatch), Equals, true)
+	c.Check(err, ErrorMatches, "private key in /tls/tls.key does not match .*")
+	served, err = reloader.GetCertificate(nil)
+	c.Assert(err, IsNil)
+	c.Check(served.Leaf.Equal(first.Leaf), Equals, true)
+
+	saveReloaderTestFiles(c, fs, "/tls", second, ca)
+	reloaded, err = reloader.Reload()
+	c.Assert(err, IsNil)
+	c.Check(reloaded, Equals, true)
+	served, err = reloader.GetClientCertificate(nil)
+	c.Assert(err, IsNil)
+	c.Check(served.Leaf.Equal(second.Leaf), Equals, true)
+
+	_, err = NewCertificateReloader(fs, "/missing/tls.crt", "/missing/tls.key", "", nil)
+	c.Check(err, NotNil)
+}
+
+func (s *CertificateReloaderSuite) TestTLSConfigs(c *C) {
+	fs := afero.NewMemMapFs()
+	ca, caKey := newTestCA(c, PrivateKeyTypeEcp256)
+	saveReloaderTestFiles(c, fs, "/server", newTestLeaf(c, ca, caKey, PrivateKeyTypeEcp256, "server.example.com", x509.ExtKeyUsageServerAuth), ca)
+	saveReloaderTestFiles(c, fs, "/client", newTestLeaf(c, ca, caKey, PrivateKeyTypeEcp256, "client.example.com", x509.ExtKeyUsageClientAuth), ca)
+	server := newTestReloader(c, fs, "/server")
+	client := newTestReloader(c, fs, "/client")
+
+	serverConfig := server.ServerConfig(&tls.Config{ClientAuth: tls.RequireAndVerifyClientCert})
+	clientConfig := client.ClientConfig(&tls.Config{ServerName: "server.example.com"})
+	handshake := func() (error, error) {
+		return reloaderTestHandshake(c, serverConfig, clientConfig)
+	}
+
+	clientErr, serverErr := handshake()
+	c.Check(clientErr, IsNil)
+	c.Check(serverErr, IsNil)
+
+	// Rotating the server's CA stops it accepting the client, and the client rejects the
+	// server once it trusts a different CA.
+	otherCA, otherKey := newTestCA(c, PrivateKeyTypeEcp256)
+	saveReloaderTestFiles(c, fs, "/server", newTestLeaf(c, ca, caKey, PrivateKeyTypeEcp256, "server.example.com", x509.ExtKeyUsageServerAuth), otherCA)
+	_, err := server.Reload()
+	c.Assert(err, IsNil)
+	_, serverErr = handshake()
+	c.Check(serverErr, NotNil)
+
+	saveReloaderTestFiles(c, fs, "/client", newTestLeaf(c, otherCA, otherKey, PrivateKeyTypeEcp256, "client.example.com", x509.ExtKeyUsageClientAuth), otherCA)
+	_, err = client.Reload()
+	c.Assert(err, IsNil)
+	clientErr, _ = handshake()
+	c.Check(clientErr, ErrorMatches, ".*certificate signed by unknown authority.*")
+}
+
+func (s *CertificateReloaderSuite) TestClientConfigVerification(c *C) {
+	fs := afero.NewMemMapFs()
+	ca, caKey := newTestCA(c, PrivateKeyTypeEcp256)
+	saveReloaderTestFiles(c, fs, "/server", newTestLeaf(c, ca, caKey, PrivateKeyTypeEcp256, "127.0.0.1", x509.ExtKeyUsageServerAuth), ca)
+	saveReloaderTestFiles(c, fs, "/client", newTestLeaf(c, ca, caKey, PrivateKeyTypeEcp256, "client.example.com", x509.ExtKeyUsageClientAuth), ca)
+	serverConfig := newTestReloader(c, fs, "/server").ServerConfig(nil)
+	client := newTestReloader(c, fs, "/client")
+
+	// A server reached by IP sends no SNI, so its name must come from the config.
+	clientErr, _ := reloaderTestHandshake(c, serverConfig, client.ClientConfig(nil))
+	c.Check(clientErr, ErrorMatches, ".*"+ErrNoServerName.Error())
+	clientErr, _ = reloaderTestHandshake(c, serverConfig, client.ClientConfig(&tls.Config{ServerName: "127.0.0.1"}))
+	c.Check(clientErr, IsNil)
+	clientErr, _ = reloaderTestHandshake(c, serverConfig, client.ClientConfig(&tls.Config{ServerName: "10.0.0.1"}))
+	c.Check(clientErr, ErrorMatches, ".*certificate is valid for 127.0.0.1, not 10.0.0.1")
+
+	// Without a CA file the caller's RootCAs are used.
+	noCA, err := NewCertificateReloader(fs, "/client/tls.crt", "/client/tls.key", "", nil)
+	c.Assert(err, IsNil)
+	roots := x509.NewCertPool()
+	roots.AddCert(ca)
+	clientErr, _ = reloaderTestHandshake(c, serverConfig, noCA.ClientConfig(&tls.Config{ServerName: "127.0.0.1", RootCAs: roots}))
+	c.Check(clientErr, IsNil)
+	otherCA, _ := newTestCA(c, PrivateKeyTypeEcp256)
+	otherRoots := x509.NewCertPool()
+	otherRoots.AddCert(otherCA)
+	clientErr, _ = reloaderTestHandshake(c, serverConfig, noCA.ClientConfig(&tls.Config{ServerName: "127.0.0.1", RootCAs: otherRoots}))
+	c.Check(clientErr, ErrorMatches, ".*certificate signed by unknown authority.*")
+
+	// config.Time is honoured.
+	clientErr, _ = reloaderTestHandshake(c, serverConfig, client.ClientConfig(&tls.Config{
+		ServerName: "127.0.0.1",
+		Time:       func() time.Time { return time.Now().AddDate(20, 0, 0) },
+	}))
+	c.Check(clientErr, ErrorMatches, ".*certificate has expired or is not yet valid.*")
+}
+
+func (s *CertificateReloaderSuite) TestRun(c *C) {
+	ca, caKey := newTestCA(c, PrivateKeyTypeEcp256)
+	for _, test := range []struct {
+		fs       afero.Fs
+		dir      string
+		interval time.Duration
+	}{
+		// Polling.
+		{afero.NewMemMapFs(), "/tls", 20 * time.Millisecond},
+		// Notification, with polling too slow to notice the change.
+		{afero.NewOsFs(), c.MkDir(), time.Hour},
+	} {
+		saveReloaderTestFiles(c, test.fs, test.dir, newTestLeaf(c, ca, caKey, PrivateKeyTypeEcp256, "www.example.com", x509.ExtKeyUsageServerAuth), ca)
+		reloader := newTestReloader(c, test.fs, test.dir)
+		reloader.Interval = test.interval
+		reloads := make(chan *KeyPair, 1)
+		reloader.OnReload = func(keyPair *KeyPair) { reloads <- keyPair }
+
+		ctx, cancel := context.WithCancel(context.Background())
+		done := make(chan struct{})
+		go func() {
+			// A poll between the key and certificate being replaced sees a mismatch, which
+			// is expected and retried.
+			reloader.Run(ctx, nil)
+			close(done)
+		}()
+		// Give the watcher time to start.
+		time.Sleep(50 * time.Millisecond)
+
+		renewed := newTestLeaf(c, ca, caKey, PrivateKeyTypeEcp256, "www.example.com", x509.ExtKeyUsageServerAuth)
+		saveReloaderTestFiles(c, test.fs, test.dir, renewed, ca)
+		select {
+		case keyPair := <-reloads:
+			c.Check(keyPair.Leaf.Equal(renewed.Leaf), Equals, true)
+			c.Check(reloader.KeyPair(), Equals, keyPair)
+		case <-time.After(5 * time.Second):
+			c.Error("certificate was not reloaded")
+		}
+		cancel()
+		<-done
+	}
+}
@@ -0,0 +1,354 @@
+package certutils
+
+import (
+	"context"
+	"crypto/tls"
+	"crypto/x509"
+	"errors"
+	"fmt"
+	"sync"
+	"time"
+)
+
+const (
+	// DefaultRenewalFraction renews certificates two thirds of the way through their lifetime.
+	DefaultRenewalFraction = 2.0 / 3.0
+	// DefaultRenewalMinBackoff is the delay after the first failed renewal. It doubles with
+	// each further failure up to DefaultRenewalMaxBackoff.
+	DefaultRenewalMinBackoff = 30 * time.Second
+	DefaultRenewalMaxBackoff = time.Hour
+)
+
+var ErrUnknownCertificate = errors.New("certificate is not managed")
+
+// RenewFunc issues a replacement for the current certificate.
+type RenewFunc func(ctx context.Context, current *tls.Certificate) (*tls.Certificate, error)
+
+// RenewalEventType is the kind of a RenewalEvent.
+type RenewalEventType int
+
+const (
+	RenewalEventRenewed RenewalEventType = iota
+	RenewalEventFailed
+)
+
+func (t RenewalEventType) String() string {
+	switch t {
+	case RenewalEventRenewed:
+		return "renewed"
+	case RenewalEventFailed:
+		return "failed"
+	default:
+		return fmt.Sprintf("RenewalEventType(%d)", int(t))
+	}
+}
+
+// RenewalEvent reports the outcome of a renewal attempt.
+type RenewalEvent struct {
+	Type RenewalEventType
+	// Name is the name the certificate was added under.
+	Name string
+	// Certificate is the certificate now being served.
+	Certificate *tls.Certificate
+	// Err is set for RenewalEventFailed.
+	Err error
+	// Failures is the number of consecutive failed attempts.
+	Failures int
+	// NextRenewal is when the next attempt is due.
+	NextRenewal time.Time
+}
+
+// RenewalManager serves certificates and renews them at a fraction of their lifetime, backing
+// off exponentially when renewal fails. Certificates are renewed by Run, or by calling
+// RenewDue.
+type RenewalManager struct {
+	// Fraction of a certificate's lifetime after which it is renewed. Defaults to
+	// DefaultRenewalFraction.
+	Fraction float64
+	// MinBackoff and MaxBackoff bound the delay between failed attempts. They default to
+	// DefaultRenewalMinBackoff and DefaultRenewalMaxBackoff.
+	MinBackoff time.Duration
+	MaxBackoff time.Duration
+	// OnEvent, if set, is called after every renewal attempt.
+	OnEvent func(RenewalEvent)
+	// Now returns the current time. Defaults to time.Now.
+	Now func() time.Time
+
+	mu           sync.RWMutex
+	certificates map[string]*managedCertificate
+	order        []string
+	wake         chan struct{}
+}
+
+type managedCertificate struct {
+	// renewing serialises renewals of the certificate.
+	renewing sync.Mutex
+
+	name        string
+	renew       RenewFunc
+	certificate *tls.Certificate
+	failures    int
+	nextRenewal time.Time
+}
+
+// NewRenewalManager returns an empty RenewalManager.
+func NewRenewalManager() *RenewalManager {
+	return &RenewalManager{
+		certificates: map[string]*managedCertificate{},
+		wake:         make(chan struct{}, 1),
+	}
+}
+
+func (m *RenewalManager) now() time.Time {
+	if m.Now == nil {
+		return time.Now()
+	}
+	return m.Now()
+}
+
+func (m *RenewalManager) fraction() float64 {
+	if m.Fraction <= 0 || m.Fraction >= 1 {
+		return DefaultRenewalFraction
+	}
+	return m.Fraction
+}
+
+func (m *RenewalManager) backoff(failures int) time.Duration {
+	minBackoff, maxBackoff := m.MinBackoff, m.MaxBackoff
+	if minBackoff <= 0 {
+		minBackoff = DefaultRenewalMinBackoff
+	}
+	if maxBackoff <= 0 {
+		maxBackoff = DefaultRenewalMaxBackoff
+	}
+	backoff := minBackoff
+	for i := 1; i < failures && backoff < maxBackoff; i++ {
+		backoff *= 2
+	}
+	if backoff > maxBackoff {
+		backoff = maxBackoff
+	}
+	return backoff
+}
+
+// renewalTime returns when a certificate is due for renewal.
+func (m *RenewalManager) renewalTime(leaf *x509.Certificate) time.Time {
+	lifetime := leaf.NotAfter.Sub(leaf.NotBefore)
+	return leaf.NotBefore.Add(time.Duration(float64(lifetime) * m.fraction()))
+}
+
+// Add manages a certificate under name, replacing any certificate already added under it.
+func (m *RenewalManager) Add(name string, certificate *tls.Certificate, renew RenewFunc) error {
+	certificate, err := withLeaf(certificate)
+	if err != nil {
+		return err
+	}
+
+	m.mu.Lock()
+	if _, found := m.certificates[name]; !found {
+		m.order = append(m.order, name)
+	}
+	m.certificates[name] = &managedCertificate{
+		name:        name,
+		renew:       renew,
+		certificate: certificate,
+		nextRenewal: m.renewalTime(certificate.Leaf),
+	}
+	m.mu.Unlock()
+
+	m.notify()
+	return nil
+}
+
+// Remove stops managing the certificate added under name.
+func (m *RenewalManager) Remove(name string) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	if _, found := m.certificates[name]; !found {
+		return
+	}
+	delete(m.certificates, name)
+	for i, existing := range m.order {
+		if existing == name {
+			m.order = append(m.order[:i], m.order[i+1:]...)
+			break
+		}
+	}
+}
+
+func (m *RenewalManager) notify() {
+	select {
+	case m.wake <- struct{}{}:
+	default:
+	}
+}
+
+// Certificate returns the current certificate added under name, or nil.
+func (m *RenewalManager) Certificate(name string) *tls.Certificate {
+	m.mu.RLock()
+	defer m.mu.RUnlock()
+	if managed, found := m.certificates[name]; found {
+		return managed.certificate
+	}
+	return nil
+}
+
+// GetCertificate can be used as tls.Config.GetCertificate. It returns the first certificate
+// valid for the requested server name, or the first certificate added if none is.
+func (m *RenewalManager) GetCertificate(hello *tls.ClientHelloInfo) (*tls.Certificate, error) {
+	m.mu.RLock()
+	defer m.mu.RUnlock()
+	if len(m.order) == 0 {
+		return nil, errors.New("no certificates are managed")
+	}
+	if hello != nil && hello.ServerName != "" {
+		for _, name := range m.order {
+			certificate := m.certificates[name].certificate
+			if certificate.Leaf.VerifyHostname(hello.ServerName) == nil {
+				return certificate, nil
+			}
+		}
+	}
+	return m.certificates[m.order[0]].certificate, nil
+}
+
+// NextRenewal returns when the next certificate is due for renewal, or the zero time if no
+// certificates are managed.
+func (m *RenewalManager) NextRenewal() time.Time {
+	m.mu.RLock()
+	defer m.mu.RUnlock()
+	next := time.Time{}
+	for _, managed := range m.certificates {
+		if next.IsZero() || managed.nextRenewal.Before(next) {
+			next = managed.nextRenewal
+		}
+	}
+	return next
+}
+
+// RenewDue renews every certificate whose renewal time has passed, and returns the errors of
+// any which failed.
+func (m *RenewalManager) RenewDue(ctx context.Context) error {
+	now := m.now()
+	m.mu.RLock()
+	due := []string{}
+	for _, name := range m.order {
+		if !now.Before(m.certificates[name].nextRenewal) {
+			due = append(due, name)
+		}
+	}
+	m.mu.RUnlock()
+
+	var errs []error
+	for _, name := range due {
+		if err := m.renew(ctx, name, true); err != nil {
+			errs = append(errs, err)
+		}
+	}
+	return errors.Join(errs...)
+}
+
+// Renew renews the certificate added under name now, whether or not it is due.
+func (m *RenewalManager) Renew(ctx context.Context, name string) error {
+	return m.renew(ctx, name, false)
+}
+
+// renew renews a certificate. If onlyIfDue is set a certificate renewed by another caller
+// while waiting is left alone.
+func (m *RenewalManager) renew(ctx context.Context, name string, onlyIfDue bool) error {
+	m.mu.RLock()
+	managed, found := m.certificates[name]
+	m.mu.RUnlock()
+	if !found {
+		return ErrUnknownCertificate
+	}
+	managed.renewing.Lock()
+	defer managed.renewing.Unlock()
+
+	m.mu.RLock()
+	current := managed.certificate
+	notDue := m.now().Before(managed.nextRenewal)
+	m.mu.RUnlock()
+	if onlyIfDue && notDue {
+		return nil
+	}
+
+	renewed, err := managed.renew(ctx, current)
+	if err == nil {
+		renewed, err = withLeaf(renewed)
+	}
+	if err == nil {
+		err = CheckKeyMatchesCertificate(renewed.PrivateKey, renewed.Leaf)
+	}
+
+	m.mu.Lock()
+	event := RenewalEvent{Name: name}
+	if err != nil {
+		err = fmt.Errorf("renewing %s: %w", name, err)
+		managed.failures++
+		managed.nextRenewal = m.now().Add(m.backoff(managed.failures))
+		event.Type = RenewalEventFailed
+		event.Err = err
+	} else {
+		managed.certificate = renewed
+		managed.failures = 0
+		managed.nextRenewal = m.renewalTime(renewed.Leaf)
+		// A replacement which is already due, such as one capped by an expiring authority or
+		// the same certificate returned again, waits as after a failure rather than spinning.
+		if earliest := m.now().Add(m.backoff(1)); managed.nextRenewal.Before(earliest) {
+			managed.nextRenewal = earliest
+		}
+		event.Type = RenewalEventRenewed
+	}
+	event.Certificate = managed.certificate
+	event.Failures = managed.failures
+	event.NextRenewal = managed.nextRenewal
+	m.mu.Unlock()
+
+	if m.OnEvent != nil {
+		m.OnEvent(event)
+	}
+	return err
+}
+
+// Run renews certificates as they become due until the context is cancelled.
+func (m *RenewalManager) Run(ctx context.Context) {
+	for {
+		_ = m.RenewDue(ctx)
+
+		var timer *time.Timer
+		var wait <-chan time.Time
+		if next := m.NextRenewal(); !next.IsZero() {
+			timer = time.NewTimer(next.Sub(m.now()))
+			wait = timer.C
+		}
+		select {
+		case <-ctx.Done():
+		case <-m.wake:
+		case <-wait:
+		}
+		if timer != nil {
+			timer.Stop()
+		}
+		if ctx.Err() != nil {
+			return
+		}
+	}
+}
+
+// withLeaf returns the certificate with its Leaf parsed.
+func withLeaf(certificate *tls.Certificate) (*tls.Certificate, error) {
+	if certificate == nil || len(certificate.Certificate) == 0 {
+		return nil, errors.New("certificate has no leaf")
+	}
+	if certificate.Leaf != nil {
+		return certificate, nil
+	}
+	leaf, err := x509.ParseCertificate(certificate.Certificate[0])
+	if err != nil {
+		return nil, err
+	}
+	parsed := *certificate
+	parsed.Leaf = leaf
+	return &parsed, nil
+}
@@ -0,0 +1,251 @@
+package certutils
+
+import (
+	"context"
+	"crypto"
+	"crypto/tls"
+	"crypto/x509"
+	"errors"
+	"sync"
+	"time"
+
+	. "gopkg.in/check.v1"
+)
+
+type RenewalManagerSuite struct {
+}
+
+var _ = Suite(&RenewalManagerSuite{})
+
+// renewalTestClock is a settable clock for RenewalManager.Now.
+type renewalTestClock struct {
+	mu  sync.Mutex
+	now time.Time
+}
+
+func (c *renewalTestClock) Now() time.Time {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	return c.now
+}
+
+func (c *renewalTestClock) Advance(d time.Duration) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	c.now = c.now.Add(d)
+}
+
+// renewalTestIssuer issues certificates valid for lifetime from the clock's current time,
+// failing while err is set.
+type renewalTestIssuer struct {
+	ca       *x509.Certificate
+	caKey    crypto.Signer
+	clock    *renewalTestClock
+	lifetime time.Duration
+
+	mu    sync.Mutex
+	err   error
+	calls int
+}
+
+func (i *renewalTestIssuer) issue(host string) *tls.Certificate {
+	now := i.clock.Now()
+	return RequestTLSCertificate(i.ca, i.caKey, SigningParameters{
+		NotBefore: now,
+		NotAfter:  now.Add(i.lifetime),
+	}, PrivateKeyTypeEcp256, host)
+}
+
+func (i *renewalTestIssuer) renew(_ context.Context, current *tls.Certificate) (*tls.Certificate, error) {
+	i.mu.Lock()
+	defer i.mu.Unlock()
+	i.calls++
+	if i.err != nil {
+		return nil, i.err
+	}
+	return i.issue(current.Leaf.DNSNames[0]), nil
+}
+
+func (i *renewalTestIssuer) setErr(err error) {
+	i.mu.Lock()
+	defer i.mu.Unlock()
+	i.err = err
+}
+
+func newRenewalTest(c *C, start time.Time, lifetime time.Duration) (*RenewalManager, *renewalTestIssuer, *[]RenewalEvent) {
+	ca, caKey := newTestCA(c, PrivateKeyTypeEcp256)
+	clock := &renewalTestClock{now: start}
+	issuer := &renewalTestIssuer{ca: ca, caKey: caKey, clock: clock, lifetime: lifetime}
+	events := &[]RenewalEvent{}
+	manager := NewRenewalManager()
+	manager.Now = clock.Now
+	manager.OnEvent = func(event RenewalEvent) { *events = append(*events, event) }
+	return manager, issuer, events
+}
+
+func (s *RenewalManagerSuite) TestRenewsAtFraction(c *C) {
+	start := time.Now().UTC().Truncate(time.Second)
+	lifetime := 90 * 24 * time.Hour
+	manager, issuer, events := newRenewalTest(c, start, lifetime)
+
+	original := issuer.issue("www.example.com")
+	c.Assert(manager.Add("www", original, issuer.renew), IsNil)
+	c.Check(manager.NextRenewal(), Equals, start.Add(60*24*time.Hour))
+
+	c.Assert(manager.RenewDue(context.Background()), IsNil)
+	c.Check(issuer.calls, Equals, 0)
+
+	issuer.clock.Advance(60 * 24 * time.Hour)
+	c.Assert(manager.RenewDue(context.Background()), IsNil)
+	c.Check(issuer.calls, Equals, 1)
+	c.Assert(*events, HasLen, 1)
+	event := (*events)[0]
+	c.Check(event.Type, Equals, RenewalEventRenewed)
+	c.Check(event.Name, Equals, "www")
+	c.Check(event.Certificate, Equals, manager.Certificate("www"))
+	c.Check(event.NextRenewal, Equals, start.Add(120*24*time.Hour))
+
+	served, err := manager.GetCertificate(&tls.ClientHelloInfo{ServerName: "www.example.com"})
+	c.Assert(err, IsNil)
+	c.Check(served.Leaf.Equal(original.Leaf), Equals, false)
+	c.Check(served.Leaf.NotBefore, Equals, start.Add(60*24*time.Hour))
+
+	// Renewing on demand ignores the schedule.
+	c.Assert(manager.Renew(context.Background(), "www"), IsNil)
+	c.Check(issuer.calls, Equals, 2)
+	c.Check(manager.Renew(context.Background(), "missing"), Equals, ErrUnknownCertificate)
+}
+
+func (s *RenewalManagerSuite) TestBackoff(c *C) {
+	start := time.Now().UTC().Truncate(time.Second)
+	manager, issuer, events := newRenewalTest(c, start, 3*time.Hour)
+	manager.MinBackoff = time.Minute
+	manager.MaxBackoff = 5 * time.Minute
+	original := issuer.issue("www.example.com")
+	c.Assert(manager.Add("www", original, issuer.renew), IsNil)
+
+	issuer.clock.Advance(2 * time.Hour)
+	issuer.setErr(errors.New("issuer unavailable"))
+	for _, backoff := range []time.Duration{time.Minute, 2 * time.Minute, 4 * time.Minute, 5 * time.Minute} {
+		err := manager.RenewDue(context.Background())
+		c.Check(err, ErrorMatches, "renewing www: issuer unavailable")
+		last := (*events)[len(*events)-1]
+		c.Check(last.Type, Equals, RenewalEventFailed)
+		c.Check(last.Certificate, Equals, original)
+		c.Check(last.NextRenewal, Equals, issuer.clock.Now().Add(backoff))
+
+		// Nothing is attempted before the backoff has passed.
+		calls := issuer.calls
+		c.Check(manager.RenewDue(context.Background()), IsNil)
+		c.Check(issuer.calls, Equals, calls)
+		issuer.clock.Advance(backoff)
+	}
+	c.Check((*events)[len(*events)-1].Failures, Equals, 4)
+
+	issuer.setErr(nil)
+	c.Assert(manager.RenewDue(context.Background()), IsNil)
+	last := (*events)[len(*events)-1]
+	c.Check(last.Type, Equals, RenewalEventRenewed)
+	c.Check(last.Failures, Equals, 0)
+}
+
+func (s *RenewalManagerSuite) TestRenewalAlreadyDue(c *C) {
+	start := time.Now().UTC().Truncate(time.Second)
+	manager, issuer, events := newRenewalTest(c, start, time.Hour)
+	manager.MinBackoff = time.Minute
+	original := issuer.issue("www.example.com")
+	// The issuer returns the same certificate, as a remote issuer might.
+	c.Assert(manager.Add("www", original, func(context.Context, *tls.Certificate) (*tls.Certificate, error) {
+		issuer.calls++
+		return original, nil
+	}), IsNil)
+
+	issuer.clock.Advance(time.Hour)
+	c.Assert(manager.RenewDue(context.Background()), IsNil)
+	c.Check(issuer.calls, Equals, 1)
+	c.Check((*events)[0].Type, Equals, RenewalEventRenewed)
+	c.Check((*events)[0].NextRenewal, Equals, issuer.clock.Now().Add(time.Minute))
+
+	c.Assert(manager.RenewDue(context.Background()), IsNil)
+	c.Check(issuer.calls, Equals, 1)
+	issuer.clock.Advance(time.Minute)
+	c.Assert(manager.RenewDue(context.Background()), IsNil)
+	c.Check(issuer.calls, Equals, 2)
+}
+
+func (s *RenewalManagerSuite) TestRejectsMismatchedRenewal(c *C) {
+	manager, issuer, events := newRenewalTest(c, time.Now(), time.Hour)
+	original := issuer.issue("www.example.com")
+	c.Assert(manager.Add("www", original, func(ctx context.Context, current *tls.Certificate) (*tls.Certificate, error) {
+		renewed := issuer.issue("www.example.com")
+		renewed.PrivateKey = current.PrivateKey
+		return renewed, nil
+	}), IsNil)
+
+	err := manager.Renew(context.Background(), "www")
+	c.Check(errors.Is(err, ErrKeyMismatch), Equals, true)
+	c.Check(manager.Certificate("www"), Equals, original)
+	c.Check((*events)[0].Type, Equals, RenewalEventFailed)
+}
+
+func (s *RenewalManagerSuite) TestGetCertificateByName(c *C) {
+	manager, issuer, _ := newRenewalTest(c, time.Now(), time.Hour)
+	_, err := manager.GetCertificate(nil)
+	c.Check(err, NotNil)
+
+	first := issuer.issue("first.example.com")
+	second := issuer.issue("second.example.com")
+	c.Assert(manager.Add("first", first, issuer.renew), IsNil)
+	c.Assert(manager.Add("second", second, issuer.renew), IsNil)
+
+	served, err := manager.GetCertificate(&tls.ClientHelloInfo{ServerName: "second.example.com"})
+	c.Assert(err, IsNil)
+	c.Check(served, Equals, second)
+	served, err = manager.GetCertificate(&tls.ClientHelloInfo{ServerName: "other.example.com"})
+	c.Assert(err, IsNil)
+	c.Check(served, Equals, first)
+
+	manager.Remove("first")
+	served, err = manager.GetCertificate(nil)
+	c.Assert(err, IsNil)
+	c.Check(served, Equals, second)
+	c.Check(manager.Certificate("first"), IsNil)
+}
+
+func (s *RenewalManagerSuite) TestRun(c *C) {
+	ca, caKey := newTestCA(c, PrivateKeyTypeEcp256)
+	manager := NewRenewalManager()
+	renewed := make(chan RenewalEvent, 1)
+	manager.OnEvent = func(event RenewalEvent) { renewed <- event }
+
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+	done := make(chan struct{})
+	go func() {
+		manager.Run(ctx)
+		close(done)
+	}()
+
+	// A certificate added while running is renewed when due.
+	now := time.Now()
+	expiring := RequestTLSCertificate(ca, caKey, SigningParameters{
+		NotBefore: now.Add(-time.Second),
+		NotAfter:  now.Add(500 * time.Millisecond),
+	}, PrivateKeyTypeEcp256, "www.example.com")
+	c.Assert(manager.Add("www", expiring, func(context.Context, *tls.Certificate) (*tls.Certificate, error) {
+		return RequestTLSCertificate(ca, caKey, SigningParameters{
+			NotBefore: CertificateNotBefore(),
+			NotAfter:  CertificateNotAfter(0, ca),
+		}, PrivateKeyTypeEcp256, "www.example.com"), nil
+	}), IsNil)
+
+	select {
+	case event := <-renewed:
+		c.Check(event.Type, Equals, RenewalEventRenewed)
+		c.Check(manager.Certificate("www").Leaf.NotAfter.After(expiring.Leaf.NotAfter), Equals, true)
+	case <-time.After(5 * time.Second):
+		c.Error("certificate was not renewed")
+	}
+	cancel()
+	<-done
+}
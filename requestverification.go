@@ -0,0 +1,83 @@
+package certutils
+
+import (
+	"crypto"
+	"crypto/ecdsa"
+	"crypto/ed25519"
+	"crypto/elliptic"
+	"crypto/rsa"
+	"crypto/x509"
+	"fmt"
+)
+
+// MinimumRSAKeySize is the smallest RSA modulus, in bits, which will be accepted for signing.
+const MinimumRSAKeySize = 2048
+
+// ErrInvalidRequestSignature is returned when the signature on a CSR does not verify. Either
+// the request was altered or the requester does not possess the private key.
+type ErrInvalidRequestSignature struct {
+	Err error
+}
+
+func (e ErrInvalidRequestSignature) Error() string {
+	return fmt.Sprintf("certificate request signature is invalid: %v", e.Err)
+}
+
+func (e ErrInvalidRequestSignature) Unwrap() error {
+	return e.Err
+}
+
+// ErrWeakPublicKey is returned when a public key is too weak or malformed to be certified.
+type ErrWeakPublicKey struct {
+	Reason string
+}
+
+func (e ErrWeakPublicKey) Error() string {
+	return fmt.Sprintf("public key rejected: %s", e.Reason)
+}
+
+// ValidatePublicKey checks that a public key is of a supported type, of sufficient strength
+// and well-formed.
+func ValidatePublicKey(pub crypto.PublicKey) error {
+	switch key := pub.(type) {
+	case *rsa.PublicKey:
+		if key.N == nil || key.N.BitLen() < MinimumRSAKeySize {
+			return &ErrWeakPublicKey{fmt.Sprintf("RSA modulus is smaller than %d bits", MinimumRSAKeySize)}
+		}
+		if key.N.Bit(0) == 0 {
+			return &ErrWeakPublicKey{"RSA modulus is even"}
+		}
+		if key.E < 3 || key.E%2 == 0 {
+			return &ErrWeakPublicKey{fmt.Sprintf("RSA public exponent %d is invalid", key.E)}
+		}
+	case *ecdsa.PublicKey:
+		switch key.Curve {
+		case elliptic.P256(), elliptic.P384(), elliptic.P521():
+		default:
+			return &ErrWeakPublicKey{"unsupported elliptic curve"}
+		}
+		// Conversion to ECDH validates the point is on the curve and not the identity.
+		if _, err := key.ECDH(); err != nil {
+			return &ErrWeakPublicKey{"invalid elliptic curve point"}
+		}
+	case ed25519.PublicKey:
+		if len(key) != ed25519.PublicKeySize {
+			return &ErrWeakPublicKey{"Ed25519 public key has the wrong length"}
+		}
+	default:
+		return &ErrWeakPublicKey{fmt.Sprintf("unsupported public key type %T", pub)}
+	}
+	return nil
+}
+
+// VerifyCertificateRequest checks the CSR signature, which proves the requester possesses
+// the private key, and that the requested public key is acceptable.
+func VerifyCertificateRequest(csr *x509.CertificateRequest) error {
+	if err := ValidatePublicKey(csr.PublicKey); err != nil {
+		return err
+	}
+	if err := csr.CheckSignature(); err != nil {
+		return &ErrInvalidRequestSignature{Err: err}
+	}
+	return nil
+}
@@ -0,0 +1,528 @@
+package certutils
+
+import (
+	"bytes"
+	"context"
+	"crypto"
+	"crypto/sha256"
+	"crypto/tls"
+	"crypto/x509"
+	"crypto/x509/pkix"
+	"encoding/asn1"
+	"errors"
+	"fmt"
+	"io"
+	"math/big"
+	"net/http"
+	"strings"
+	"sync"
+	"time"
+
+	"golang.org/x/crypto/ocsp"
+)
+
+const (
+	// DefaultRevocationTimeout bounds the time spent fetching revocation data for one
+	// connection.
+	DefaultRevocationTimeout = 10 * time.Second
+	// maxRevocationResponseSize bounds the size of a fetched OCSP response or CRL.
+	maxRevocationResponseSize = 16 * 1024 * 1024
+	// revocationClockSkew is the tolerance applied to thisUpdate and nextUpdate.
+	revocationClockSkew = 5 * time.Minute
+)
+
+// RevocationMode selects what happens when the revocation status of a certificate cannot be
+// determined.
+type RevocationMode int
+
+const (
+	// RevocationSoftFail accepts certificates whose status cannot be determined.
+	RevocationSoftFail RevocationMode = iota
+	// RevocationHardFail rejects certificates whose status cannot be determined, including
+	// certificates which advertise no OCSP responder or CRL distribution point.
+	RevocationHardFail
+)
+
+// ErrCertificateRevoked is returned when a certificate in the peer's chain is revoked.
+type ErrCertificateRevoked struct {
+	Subject      string
+	SerialNumber *big.Int
+	RevokedAt    time.Time
+	Reason       RevocationReason
+}
+
+func (e ErrCertificateRevoked) Error() string {
+	return fmt.Sprintf("certificate %q (serial %s) was revoked at %s: %s",
+		e.Subject, FormatSerialHex(e.SerialNumber), e.RevokedAt.UTC().Format(time.RFC3339), e.Reason)
+}
+
+// ErrRevocationStatusUnavailable is returned in hard-fail mode when the revocation status of
+// a certificate cannot be determined.
+type ErrRevocationStatusUnavailable struct {
+	Subject string
+	Err     error
+}
+
+func (e ErrRevocationStatusUnavailable) Error() string {
+	return fmt.Sprintf("revocation status of %q is unavailable: %v", e.Subject, e.Err)
+}
+
+func (e ErrRevocationStatusUnavailable) Unwrap() error {
+	return e.Err
+}
+
+var errNoRevocationEndpoints = errors.New("certificate has no OCSP responder or CRL distribution point")
+
+// RevocationFetcher retrieves OCSP responses and CRLs.
+type RevocationFetcher interface {
+	// FetchOCSP sends a DER encoded OCSP request to the responder and returns the response.
+	FetchOCSP(ctx context.Context, url string, request []byte) ([]byte, error)
+	// FetchCRL returns the CRL at url, DER or PEM encoded.
+	FetchCRL(ctx context.Context, url string) ([]byte, error)
+}
+
+// HTTPRevocationFetcher fetches revocation data over HTTP. The zero value uses
+// http.DefaultClient.
+type HTTPRevocationFetcher struct {
+	Client *http.Client
+}
+
+func (f HTTPRevocationFetcher) client() *http.Client {
+	if f.Client == nil {
+		return http.DefaultClient
+	}
+	return f.Client
+}
+
+// FetchOCSP implements RevocationFetcher using an HTTP POST.
+func (f HTTPRevocationFetcher) FetchOCSP(ctx context.Context, url string, request []byte) ([]byte, error) {
+	req, err := http.NewRequestWithContext(ctx, http.MethodPost, url, bytes.NewReader(request))
+	if err != nil {
+		return nil, err
+	}
+	req.Header.Set("Content-Type", OCSPRequestContentType)
+	req.Header.Set("Accept", OCSPResponseContentType)
+	return f.do(req)
+}
+
+// FetchCRL implements RevocationFetcher using an HTTP GET.
+func (f HTTPRevocationFetcher) FetchCRL(ctx context.Context, url string) ([]byte, error) {
+	req, err := http.NewRequestWithContext(ctx, http.MethodGet, url, nil)
+	if err != nil {
+		return nil, err
+	}
+	return f.do(req)
+}
+
+func (f HTTPRevocationFetcher) do(req *http.Request) ([]byte, error) {
+	resp, err := f.client().Do(req)
+	if err != nil {
+		return nil, err
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode != http.StatusOK {
+		return nil, fmt.Errorf("fetching %s: %s", req.URL, resp.Status)
+	}
+	body, err := io.ReadAll(io.LimitReader(resp.Body, maxRevocationResponseSize+1))
+	if err != nil {
+		return nil, err
+	}
+	if len(body) > maxRevocationResponseSize {
+		return nil, fmt.Errorf("fetching %s: response too large", req.URL)
+	}
+	return body, nil
+}
+
+// RevocationChecker checks the revocation status of verified certificate chains using
+// stapled OCSP responses, the OCSP responders in each certificate's Authority Information
+// Access extension, and its CRL distribution points, in that order.
+//
+// Results are cached until the nextUpdate of the OCSP response or CRL they came from.
+type RevocationChecker struct {
+	// Fetcher retrieves OCSP responses and CRLs. Defaults to HTTPRevocationFetcher.
+	Fetcher RevocationFetcher
+	Mode    RevocationMode
+	// DisableOCSP and DisableCRL skip the respective source. A stapled response is still
+	// used when DisableOCSP is set.
+	DisableOCSP bool
+	DisableCRL  bool
+	// Timeout bounds the fetches made by VerifyPeerCertificate and VerifyConnection.
+	// Defaults to DefaultRevocationTimeout.
+	Timeout time.Duration
+
+	mu        sync.Mutex
+	responses map[string]*revocationCacheEntry
+	crls      map[string]*crlCacheEntry
+}
+
+type revocationCacheEntry struct {
+	status  OCSPStatus
+	expires time.Time
+}
+
+type crlCacheEntry struct {
+	crl     *x509.RevocationList
+	issuer  *x509.Certificate
+	expires time.Time
+}
+
+// NewRevocationChecker returns a RevocationChecker which fetches over HTTP.
+func NewRevocationChecker(mode RevocationMode) *RevocationChecker {
+	return &RevocationChecker{Fetcher: HTTPRevocationFetcher{}, Mode: mode}
+}
+
+func (r *RevocationChecker) fetcher() RevocationFetcher {
+	if r.Fetcher == nil {
+		return HTTPRevocationFetcher{}
+	}
+	return r.Fetcher
+}
+
+func (r *RevocationChecker) context() (context.Context, context.CancelFunc) {
+	timeout := r.Timeout
+	if timeout <= 0 {
+		timeout = DefaultRevocationTimeout
+	}
+	return context.WithTimeout(context.Background(), timeout)
+}
+
+// VerifyPeerCertificate can be used as tls.Config.VerifyPeerCertificate. It has no access to
+// a stapled OCSP response, so VerifyConnection should be preferred. If the chains were not
+// verified, because InsecureSkipVerify is set, the certificates are checked in the order the
+// peer sent them.
+func (r *RevocationChecker) VerifyPeerCertificate(rawCerts [][]byte, verifiedChains [][]*x509.Certificate) error {
+	if len(verifiedChains) == 0 {
+		chain := make([]*x509.Certificate, 0, len(rawCerts))
+		for _, raw := range rawCerts {
+			cert, err := x509.ParseCertificate(raw)
+			if err != nil {
+				return err
+			}
+			chain = append(chain, cert)
+		}
+		verifiedChains = [][]*x509.Certificate{chain}
+	}
+
+	ctx, cancel := r.context()
+	defer cancel()
+	return r.checkChains(ctx, verifiedChains, nil)
+}
+
+// VerifyConnection can be used as tls.Config.VerifyConnection. A stapled OCSP response is
+// used for the leaf certificate if the peer sent one.
+func (r *RevocationChecker) VerifyConnection(state tls.ConnectionState) error {
+	chains := state.VerifiedChains
+	if len(chains) == 0 {
+		chains = [][]*x509.Certificate{state.PeerCertificates}
+	}
+
+	ctx, cancel := r.context()
+	defer cancel()
+	return r.checkChains(ctx, chains, state.OCSPResponse)
+}
+
+// checkChains accepts the connection if any of the chains passes.
+func (r *RevocationChecker) checkChains(ctx context.Context, chains [][]*x509.Certificate, staple []byte) error {
+	var firstErr error
+	for _, chain := range chains {
+		err := r.CheckChain(ctx, chain, staple)
+		if err == nil {
+			return nil
+		}
+		if firstErr == nil {
+			firstErr = err
+		}
+	}
+	return firstErr
+}
+
+// CheckChain checks every certificate in a chain ordered from leaf to root, except the root
+// itself. staple is an optional OCSP response for the leaf.
+func (r *RevocationChecker) CheckChain(ctx context.Context, chain []*x509.Certificate, staple []byte) error {
+	for i := 0; i+1 < len(chain); i++ {
+		var certStaple []byte
+		if i == 0 {
+			certStaple = staple
+		}
+		if err := r.Check(ctx, chain[i], chain[i+1], certStaple); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// Check checks a single certificate issued by issuer. It returns an ErrCertificateRevoked if
+// the certificate is revoked, and in hard-fail mode an ErrRevocationStatusUnavailable if its
+// status cannot be determined.
+func (r *RevocationChecker) Check(ctx context.Context, cert *x509.Certificate, issuer *x509.Certificate, staple []byte) error {
+	if isOCSPNoCheck(cert) {
+		return nil
+	}
+
+	status, err := r.status(ctx, cert, issuer, staple)
+	if err != nil {
+		if r.Mode == RevocationHardFail {
+			return &ErrRevocationStatusUnavailable{Subject: cert.Subject.String(), Err: err}
+		}
+		return nil
+	}
+	if status.Status == CertificateStatusRevoked {
+		return &ErrCertificateRevoked{
+			Subject:      cert.Subject.String(),
+			SerialNumber: cert.SerialNumber,
+			RevokedAt:    status.RevokedAt,
+			Reason:       status.Reason,
+		}
+	}
+	return nil
+}
+
+// isOCSPNoCheck reports whether cert is a delegated OCSP responder which relying parties
+// are told not to check (RFC 6960 section 4.2.2.2.1).
+func isOCSPNoCheck(cert *x509.Certificate) bool {
+	if !hasExtKeyUsage(cert, x509.ExtKeyUsageOCSPSigning) {
+		return false
+	}
+	for _, ext := range cert.Extensions {
+		if ext.Id.Equal(oidOCSPNoCheck) {
+			return true
+		}
+	}
+	return false
+}
+
+func revocationCacheKey(cert *x509.Certificate, issuer *x509.Certificate) string {
+	digest := sha256.Sum256(issuer.RawSubjectPublicKeyInfo)
+	return fmt.Sprintf("%x:%s", digest, cert.SerialNumber.Text(16))
+}
+
+// status determines the status of the certificate from the first source which gives a
+// definitive answer.
+func (r *RevocationChecker) status(ctx context.Context, cert *x509.Certificate, issuer *x509.Certificate,
+	staple []byte) (OCSPStatus, error) {
+	now := time.Now()
+	key := revocationCacheKey(cert, issuer)
+	r.mu.Lock()
+	entry, found := r.responses[key]
+	r.mu.Unlock()
+	if found && now.Before(entry.expires) {
+		return entry.status, nil
+	}
+
+	var errs []error
+	if len(staple) > 0 {
+		status, expires, err := r.parseOCSPResponse(staple, cert, issuer, now)
+		if err == nil {
+			return r.cacheStatus(key, status, expires), nil
+		}
+		errs = append(errs, fmt.Errorf("stapled OCSP response: %w", err))
+	}
+
+	if !r.DisableOCSP && len(cert.OCSPServer) > 0 {
+		request, err := ocsp.CreateRequest(cert, issuer, &ocsp.RequestOptions{Hash: crypto.SHA256})
+		if err != nil {
+			return OCSPStatus{}, err
+		}
+		for _, url := range cert.OCSPServer {
+			der, err := r.fetcher().FetchOCSP(ctx, url, request)
+			if err != nil {
+				errs = append(errs, err)
+				continue
+			}
+			status, expires, err := r.parseOCSPResponse(der, cert, issuer, now)
+			if err != nil {
+				errs = append(errs, fmt.Errorf("OCSP response from %s: %w", url, err))
+				continue
+			}
+			return r.cacheStatus(key, status, expires), nil
+		}
+	}
+
+	if !r.DisableCRL {
+		for _, url := range cert.CRLDistributionPoints {
+			crl, err := r.crl(ctx, url, issuer, now)
+			if err != nil {
+				errs = append(errs, fmt.Errorf("CRL from %s: %w", url, err))
+				continue
+			}
+			if err := checkCRLScope(crl, cert, url); err != nil {
+				errs = append(errs, fmt.Errorf("CRL from %s: %w", url, err))
+				continue
+			}
+			status := OCSPStatus{Status: CertificateStatusGood}
+			for _, entry := range crl.RevokedCertificateEntries {
+				if entry.SerialNumber.Cmp(cert.SerialNumber) == 0 {
+					status = OCSPStatus{
+						Status:    CertificateStatusRevoked,
+						RevokedAt: entry.RevocationTime,
+						Reason:    RevocationReason(entry.ReasonCode),
+					}
+					break
+				}
+			}
+			return r.cacheStatus(key, status, crl.NextUpdate), nil
+		}
+	}
+
+	if len(errs) == 0 {
+		return OCSPStatus{}, errNoRevocationEndpoints
+	}
+	return OCSPStatus{}, errors.Join(errs...)
+}
+
+func (r *RevocationChecker) cacheStatus(key string, status OCSPStatus, expires time.Time) OCSPStatus {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	if r.responses == nil {
+		r.responses = make(map[string]*revocationCacheEntry)
+	}
+	r.responses[key] = &revocationCacheEntry{status: status, expires: expires}
+	return status
+}
+
+// parseOCSPResponse verifies an OCSP response for the certificate and returns its status
+// and when it expires. Unknown statuses are treated as an error.
+func (r *RevocationChecker) parseOCSPResponse(der []byte, cert *x509.Certificate, issuer *x509.Certificate,
+	now time.Time) (OCSPStatus, time.Time, error) {
+	response, err := verifyOCSPResponse(der, cert, issuer, now)
+	if err != nil {
+		return OCSPStatus{}, time.Time{}, err
+	}
+	status, err := ocspResponseStatus(response)
+	if err != nil {
+		return OCSPStatus{}, time.Time{}, err
+	}
+	expires := response.NextUpdate
+	if expires.IsZero() {
+		// Without a nextUpdate newer information is always available, so do not cache.
+		expires = now
+	}
+	return status, expires, nil
+}
+
+// verifyOCSPResponse parses an OCSP response for the certificate, checking it was signed by
+// the issuer or a responder the issuer delegated to, and that it is current.
+func verifyOCSPResponse(der []byte, cert *x509.Certificate, issuer *x509.Certificate, now time.Time) (*ocsp.Response, error) {
+	response, err := ocsp.ParseResponseForCert(der, cert, issuer)
+	if err != nil {
+		return nil, err
+	}
+	// ParseResponseForCert checks a delegated responder was issued by the issuer, but not
+	// that it is authorized to sign OCSP responses.
+	if response.Certificate != nil && !hasExtKeyUsage(response.Certificate, x509.ExtKeyUsageOCSPSigning) {
+		return nil, ErrNotOCSPResponder
+	}
+	if response.ThisUpdate.After(now.Add(revocationClockSkew)) {
+		return nil, fmt.Errorf("response thisUpdate %v is in the future", response.ThisUpdate)
+	}
+	if !response.NextUpdate.IsZero() && now.After(response.NextUpdate.Add(revocationClockSkew)) {
+		return nil, fmt.Errorf("response expired at %v", response.NextUpdate)
+	}
+	return response, nil
+}
+
+// ocspResponseStatus converts the status of a response. An unknown status is an error.
+func ocspResponseStatus(response *ocsp.Response) (OCSPStatus, error) {
+	switch response.Status {
+	case ocsp.Good:
+		return OCSPStatus{Status: CertificateStatusGood}, nil
+	case ocsp.Revoked:
+		return OCSPStatus{
+			Status:    CertificateStatusRevoked,
+			RevokedAt: response.RevokedAt,
+			Reason:    RevocationReason(response.RevocationReason),
+		}, nil
+	default:
+		return OCSPStatus{}, errors.New("responder does not know the certificate")
+	}
+}
+
+// crl returns the verified CRL at url, fetching it if it is not cached.
+func (r *RevocationChecker) crl(ctx context.Context, url string, issuer *x509.Certificate,
+	now time.Time) (*x509.RevocationList, error) {
+	r.mu.Lock()
+	entry, found := r.crls[url]
+	r.mu.Unlock()
+	if found && entry.issuer.Equal(issuer) && now.Before(entry.expires) {
+		return entry.crl, nil
+	}
+
+	der, err := r.fetcher().FetchCRL(ctx, url)
+	if err != nil {
+		return nil, err
+	}
+	crl, err := x509.ParseRevocationList(der)
+	if err != nil {
+		crls, pemErr := LoadCRLsFromPem(der)
+		if pemErr != nil || len(crls) == 0 {
+			return nil, err
+		}
+		crl = crls[0]
+	}
+	if err := crl.CheckSignatureFrom(issuer); err != nil {
+		return nil, err
+	}
+	if crl.NextUpdate.IsZero() || now.After(crl.NextUpdate.Add(revocationClockSkew)) {
+		return nil, fmt.Errorf("CRL expired at %v", crl.NextUpdate)
+	}
+	for _, ext := range crl.Extensions {
+		if ext.Id.Equal(oidExtensionDeltaCRLIndicator) {
+			return nil, errors.New("distribution point serves a delta CRL")
+		}
+	}
+
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	if r.crls == nil {
+		r.crls = make(map[string]*crlCacheEntry)
+	}
+	r.crls[url] = &crlCacheEntry{crl: crl, issuer: issuer, expires: crl.NextUpdate}
+	return crl, nil
+}
+
+// checkCRLScope checks that a CRL's issuing distribution point, if any, covers every reason
+// the certificate could be revoked for and is the CRL published at url. Otherwise its
+// absence of an entry for the certificate says nothing about the certificate's status.
+func checkCRLScope(crl *x509.RevocationList, cert *x509.Certificate, url string) error {
+	var ext *pkix.Extension
+	for i := range crl.Extensions {
+		if crl.Extensions[i].Id.Equal(oidExtensionIssuingDistributionPoint) {
+			ext = &crl.Extensions[i]
+			break
+		}
+	}
+	if ext == nil {
+		return nil
+	}
+
+	var idp issuingDistributionPoint
+	if rest, err := asn1.Unmarshal(ext.Value, &idp); err != nil {
+		return fmt.Errorf("malformed issuing distribution point: %w", err)
+	} else if len(rest) > 0 {
+		return errors.New("malformed issuing distribution point: trailing data")
+	}
+	isCA := cert.BasicConstraintsValid && cert.IsCA
+	switch {
+	case idp.IndirectCRL:
+		return errors.New("indirect CRLs are not supported")
+	case idp.OnlySomeReasons.BitLength > 0:
+		return errors.New("CRL only covers some revocation reasons")
+	case idp.OnlyContainsAttributeCerts:
+		return errors.New("CRL only covers attribute certificates")
+	case idp.OnlyContainsCACerts && !isCA:
+		return errors.New("CRL only covers CA certificates")
+	case idp.OnlyContainsUserCerts && isCA:
+		return errors.New("CRL only covers end entity certificates")
+	case len(idp.DistributionPoint.RelativeName) > 0:
+		return errors.New("CRL distribution point names relative to the issuer are not supported")
+	}
+	if names := generalNameURIs(idp.DistributionPoint.FullName); len(names) > 0 {
+		for _, name := range names {
+			if name == url {
+				return nil
+			}
+		}
+		return fmt.Errorf("CRL is for distribution point %s", strings.Join(names, ", "))
+	}
+	return nil
+}
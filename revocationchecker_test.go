@@ -0,0 +1,282 @@
+package certutils
+
+import (
+	"context"
+	"crypto"
+	"crypto/tls"
+	"crypto/x509"
+	"crypto/x509/pkix"
+	"encoding/asn1"
+	"errors"
+	"math/big"
+	"net"
+	"net/http"
+	"net/http/httptest"
+	"sync"
+	"time"
+
+	. "gopkg.in/check.v1"
+)
+
+type RevocationCheckerSuite struct {
+}
+
+var _ = Suite(&RevocationCheckerSuite{})
+
+// revocationTestPKI is a CA publishing OCSP and a CRL from an httptest server.
+type revocationTestPKI struct {
+	ca        *CertificateAuthority
+	source    *testStatusSource
+	responder *OCSPResponder
+	server    *httptest.Server
+
+	mu      sync.Mutex
+	revoked []RevokedCertificate
+}
+
+func newRevocationTestPKI(c *C) *revocationTestPKI {
+	key, err := GeneratePrivateKey(PrivateKeyTypeEcp256)
+	c.Assert(err, IsNil)
+	root, err := CreateRootCA(pkix.Name{CommonName: "Revocation Test CA"}, key, CAParameters{})
+	c.Assert(err, IsNil)
+
+	pki := &revocationTestPKI{source: newTestStatusSource()}
+	pki.responder, err = NewOCSPResponder(root, nil, key, pki.source)
+	c.Assert(err, IsNil)
+
+	mux := http.NewServeMux()
+	mux.Handle("/ocsp/", http.StripPrefix("/ocsp", pki.responder))
+	mux.HandleFunc("/ca.crl", func(w http.ResponseWriter, r *http.Request) {
+		pki.mu.Lock()
+		defer pki.mu.Unlock()
+		crl, err := CreateCRL(pki.revoked, root, key, CRLParameters{Number: big.NewInt(1)})
+		if err != nil {
+			http.Error(w, err.Error(), http.StatusInternalServerError)
+			return
+		}
+		_, _ = w.Write(crl.Raw)
+	})
+	pki.server = httptest.NewServer(mux)
+
+	pki.ca = &CertificateAuthority{
+		Certificate: root,
+		Key:         key,
+		Endpoints: AuthorityEndpoints{
+			OCSPServers:           []string{pki.server.URL + "/ocsp/"},
+			CRLDistributionPoints: []string{pki.server.URL + "/ca.crl"},
+		},
+	}
+	return pki
+}
+
+// issue returns a leaf certificate marked as good with the OCSP responder.
+func (p *revocationTestPKI) issue(c *C) (*x509.Certificate, crypto.Signer) {
+	key, err := GeneratePrivateKey(PrivateKeyTypeEcp256)
+	c.Assert(err, IsNil)
+	csr, err := GenerateCSR(pkix.Name{CommonName: "leaf.example.com"}, CSRParameters{
+		KeyUsage:    x509.KeyUsageDigitalSignature,
+		ExtKeyUsage: []x509.ExtKeyUsage{x509.ExtKeyUsageServerAuth},
+	}, key, "leaf.example.com")
+	c.Assert(err, IsNil)
+	cert, err := p.ca.Sign(csr, SigningParameters{
+		NotBefore: CertificateNotBefore(),
+		NotAfter:  CertificateNotAfter(0, p.ca.Certificate),
+	})
+	c.Assert(err, IsNil)
+	p.source.set(cert.SerialNumber, OCSPStatus{Status: CertificateStatusGood})
+	return cert, key
+}
+
+func (p *revocationTestPKI) revoke(cert *x509.Certificate, reason RevocationReason) {
+	revokedAt := time.Now().UTC().Truncate(time.Second)
+	p.source.set(cert.SerialNumber, OCSPStatus{Status: CertificateStatusRevoked, RevokedAt: revokedAt, Reason: reason})
+	p.responder.Invalidate(cert.SerialNumber)
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	p.revoked = append(p.revoked, RevokedCertificate{SerialNumber: cert.SerialNumber, RevocationTime: revokedAt, Reason: reason})
+}
+
+// countingFetcher counts the fetches made through it.
+type countingFetcher struct {
+	RevocationFetcher
+	mu        sync.Mutex
+	ocspCalls int
+	crlCalls  int
+}
+
+func (f *countingFetcher) FetchOCSP(ctx context.Context, url string, request []byte) ([]byte, error) {
+	f.mu.Lock()
+	f.ocspCalls++
+	f.mu.Unlock()
+	return f.RevocationFetcher.FetchOCSP(ctx, url, request)
+}
+
+func (f *countingFetcher) FetchCRL(ctx context.Context, url string) ([]byte, error) {
+	f.mu.Lock()
+	f.crlCalls++
+	f.mu.Unlock()
+	return f.RevocationFetcher.FetchCRL(ctx, url)
+}
+
+// failingFetcher simulates unreachable revocation endpoints.
+type failingFetcher struct{}
+
+func (failingFetcher) FetchOCSP(context.Context, string, []byte) ([]byte, error) {
+	return nil, errors.New("unreachable")
+}
+
+func (failingFetcher) FetchCRL(context.Context, string) ([]byte, error) {
+	return nil, errors.New("unreachable")
+}
+
+func (s *RevocationCheckerSuite) TestOCSP(c *C) {
+	pki := newRevocationTestPKI(c)
+	defer pki.server.Close()
+	good, _ := pki.issue(c)
+	revoked, _ := pki.issue(c)
+	pki.revoke(revoked, RevocationReasonKeyCompromise)
+
+	fetcher := &countingFetcher{RevocationFetcher: HTTPRevocationFetcher{Client: pki.server.Client()}}
+	checker := &RevocationChecker{Fetcher: fetcher, Mode: RevocationHardFail}
+	ctx := context.Background()
+
+	c.Assert(checker.Check(ctx, good, pki.ca.Certificate, nil), IsNil)
+	c.Assert(checker.Check(ctx, good, pki.ca.Certificate, nil), IsNil)
+	c.Check(fetcher.ocspCalls, Equals, 1)
+	c.Check(fetcher.crlCalls, Equals, 0)
+
+	err := checker.Check(ctx, revoked, pki.ca.Certificate, nil)
+	revokedErr, ok := err.(*ErrCertificateRevoked)
+	c.Assert(ok, Equals, true, Commentf("%v", err))
+	c.Check(revokedErr.SerialNumber.Cmp(revoked.SerialNumber), Equals, 0)
+	c.Check(revokedErr.Reason, Equals, RevocationReasonKeyCompromise)
+
+	err = checker.VerifyPeerCertificate(nil, [][]*x509.Certificate{{revoked, pki.ca.Certificate}})
+	c.Check(err, FitsTypeOf, &ErrCertificateRevoked{})
+}
+
+func (s *RevocationCheckerSuite) TestCRL(c *C) {
+	pki := newRevocationTestPKI(c)
+	defer pki.server.Close()
+	good, _ := pki.issue(c)
+	revoked, _ := pki.issue(c)
+	pki.revoke(revoked, RevocationReasonSuperseded)
+
+	fetcher := &countingFetcher{RevocationFetcher: HTTPRevocationFetcher{Client: pki.server.Client()}}
+	checker := &RevocationChecker{Fetcher: fetcher, Mode: RevocationHardFail, DisableOCSP: true}
+	ctx := context.Background()
+
+	c.Check(checker.Check(ctx, good, pki.ca.Certificate, nil), IsNil)
+	err := checker.Check(ctx, revoked, pki.ca.Certificate, nil)
+	c.Assert(err, FitsTypeOf, &ErrCertificateRevoked{})
+	c.Check(err.(*ErrCertificateRevoked).Reason, Equals, RevocationReasonSuperseded)
+	c.Check(fetcher.ocspCalls, Equals, 0)
+	c.Check(fetcher.crlCalls, Equals, 1)
+
+	// A CRL signed by another authority is rejected.
+	otherCA, _ := newTestCA(c, PrivateKeyTypeEcp256)
+	checker = &RevocationChecker{Fetcher: fetcher, Mode: RevocationHardFail, DisableOCSP: true}
+	c.Check(checker.Check(ctx, good, otherCA, nil), FitsTypeOf, &ErrRevocationStatusUnavailable{})
+}
+
+// staticCRLFetcher serves a fixed CRL from every distribution point.
+type staticCRLFetcher struct {
+	failingFetcher
+	crl []byte
+}
+
+func (f staticCRLFetcher) FetchCRL(context.Context, string) ([]byte, error) {
+	return f.crl, nil
+}
+
+func (s *RevocationCheckerSuite) TestCRLScope(c *C) {
+	pki := newRevocationTestPKI(c)
+	defer pki.server.Close()
+	cert, _ := pki.issue(c)
+	url := pki.ca.Endpoints.CRLDistributionPoints[0]
+
+	someReasons, err := asn1.Marshal(issuingDistributionPoint{
+		OnlySomeReasons: asn1.BitString{Bytes: []byte{0x40}, BitLength: 2},
+	})
+	c.Assert(err, IsNil)
+
+	for _, t := range []struct {
+		parameters CRLParameters
+		covered    bool
+	}{
+		{CRLParameters{}, true},
+		{CRLParameters{IssuingDistributionPoint: &IssuingDistributionPointExtension{URL: url, OnlyContainsUserCerts: true}}, true},
+		{CRLParameters{IssuingDistributionPoint: &IssuingDistributionPointExtension{URL: url, OnlyContainsCACerts: true}}, false},
+		{CRLParameters{IssuingDistributionPoint: &IssuingDistributionPointExtension{URL: url + "?partition=1"}}, false},
+		{CRLParameters{IssuingDistributionPoint: &IssuingDistributionPointExtension{URL: url, IndirectCRL: true}}, false},
+		{CRLParameters{ExtraExtensions: []pkix.Extension{{Id: oidExtensionIssuingDistributionPoint, Critical: true, Value: someReasons}}}, false},
+	} {
+		t.parameters.Number = big.NewInt(1)
+		crl, err := CreateCRL(nil, pki.ca.Certificate, pki.ca.Key, t.parameters)
+		c.Assert(err, IsNil)
+		checker := &RevocationChecker{Fetcher: staticCRLFetcher{crl: crl.Raw}, Mode: RevocationHardFail, DisableOCSP: true}
+		err = checker.Check(context.Background(), cert, pki.ca.Certificate, nil)
+		if t.covered {
+			c.Check(err, IsNil)
+		} else {
+			c.Check(err, FitsTypeOf, &ErrRevocationStatusUnavailable{}, Commentf("%+v", t.parameters))
+		}
+	}
+}
+
+func (s *RevocationCheckerSuite) TestSoftAndHardFail(c *C) {
+	pki := newRevocationTestPKI(c)
+	defer pki.server.Close()
+	cert, _ := pki.issue(c)
+	ctx := context.Background()
+
+	soft := &RevocationChecker{Fetcher: failingFetcher{}, Mode: RevocationSoftFail}
+	c.Check(soft.Check(ctx, cert, pki.ca.Certificate, nil), IsNil)
+
+	hard := &RevocationChecker{Fetcher: failingFetcher{}, Mode: RevocationHardFail}
+	c.Check(hard.Check(ctx, cert, pki.ca.Certificate, nil), FitsTypeOf, &ErrRevocationStatusUnavailable{})
+
+	// A stapled response is used without contacting the responder.
+	staple, err := pki.responder.Response(cert.SerialNumber)
+	c.Assert(err, IsNil)
+	c.Check(hard.Check(ctx, cert, pki.ca.Certificate, staple), IsNil)
+}
+
+func (s *RevocationCheckerSuite) TestVerifyConnectionUsesStaple(c *C) {
+	pki := newRevocationTestPKI(c)
+	defer pki.server.Close()
+	good, goodKey := pki.issue(c)
+	revoked, revokedKey := pki.issue(c)
+	pki.revoke(revoked, RevocationReasonKeyCompromise)
+
+	roots := x509.NewCertPool()
+	roots.AddCert(pki.ca.Certificate)
+
+	handshake := func(cert *x509.Certificate, key crypto.Signer) error {
+		staple, err := pki.responder.Response(cert.SerialNumber)
+		c.Assert(err, IsNil)
+		serverConfig := &tls.Config{Certificates: []tls.Certificate{{
+			Certificate: [][]byte{cert.Raw},
+			PrivateKey:  key,
+			OCSPStaple:  staple,
+		}}}
+		checker := &RevocationChecker{Fetcher: failingFetcher{}, Mode: RevocationHardFail}
+		clientConfig := &tls.Config{
+			RootCAs:          roots,
+			ServerName:       "leaf.example.com",
+			VerifyConnection: checker.VerifyConnection,
+		}
+
+		clientConn, serverConn := net.Pipe()
+		defer clientConn.Close()
+		defer serverConn.Close()
+		go func() {
+			_ = tls.Server(serverConn, serverConfig).Handshake()
+		}()
+		return tls.Client(clientConn, clientConfig).Handshake()
+	}
+
+	c.Check(handshake(good, goodKey), IsNil)
+	var revokedErr *ErrCertificateRevoked
+	c.Check(errors.As(handshake(revoked, revokedKey), &revokedErr), Equals, true)
+}
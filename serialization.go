@@ -3,6 +3,7 @@ package certutils
 import (
 	"bytes"
 	"crypto/ecdsa"
+	"crypto/ed25519"
 	"crypto/rsa"
 	"crypto/x509"
 	"encoding/pem"
@@ -13,18 +14,23 @@ import (
 
 var ErrCouldNotParsePemCertificateSigningRequestBytes = errors.New("Could not parse bytes as PEM certificate signing request")
 var ErrCouldNotParsePemCertificateBytes = errors.New("Could not parse bytes as PEM certificate")
+var ErrCouldNotParsePemCRLBytes = errors.New("Could not parse bytes as PEM certificate revocation list")
 var ErrUnknownTypeForKey = errors.New("unknown type for encoding key")
 
 const (
 	// CertificateBlockType is a possible value for pem.Block.Type.
-	CertificateBlockType        = "CERTIFICATE"
-	RSAKeyBlockType             = "RSA PRIVATE KEY"
-	ECKeyBlockType              = "EC PRIVATE KEY"
-	PrivateKeyBlockType         = "PRIVATE KEY"
-	CertificateRequestBlockType = "CERTIFICATE REQUEST"
+	CertificateBlockType         = "CERTIFICATE"
+	RSAKeyBlockType              = "RSA PRIVATE KEY"
+	ECKeyBlockType               = "EC PRIVATE KEY"
+	PrivateKeyBlockType          = "PRIVATE KEY"
+	EncryptedPrivateKeyBlockType = "ENCRYPTED PRIVATE KEY"
+	CertificateRequestBlockType  = "CERTIFICATE REQUEST"
+	CRLBlockType                 = "X509 CRL"
+	PKCS7BlockType               = "PKCS7"
 )
 
-// LoadCertificatesFromPem will read 1 or more PEM encoded x509 certificates
+// LoadCertificatesFromPem will read 1 or more PEM encoded x509 certificates, including those
+// in PKCS7 blocks
 func LoadCertificatesFromPem(pemCerts []byte) ([]*x509.Certificate, error) {
 	idx := 0
 	certs := make([]*x509.Certificate, 0)
@@ -34,6 +40,15 @@ func LoadCertificatesFromPem(pemCerts []byte) ([]*x509.Certificate, error) {
 		if block == nil {
 			break
 		}
+		if block.Type == PKCS7BlockType && len(block.Headers) == 0 {
+			bundle, err := ParsePKCS7Certificates(block.Bytes)
+			if err != nil {
+				return certs, errors.Wrapf(ErrCouldNotParsePemCertificateBytes, "error on block %v", idx)
+			}
+			certs = append(certs, bundle...)
+			idx++
+			continue
+		}
 		if block.Type != CertificateBlockType || len(block.Headers) != 0 {
 			idx++
 			continue
@@ -51,6 +66,32 @@ func LoadCertificatesFromPem(pemCerts []byte) ([]*x509.Certificate, error) {
 	return certs, nil
 }
 
+// LoadCRLsFromPem will read 1 or more PEM encoded certificate revocation lists
+func LoadCRLsFromPem(pemCRLs []byte) ([]*x509.RevocationList, error) {
+	idx := 0
+	crls := make([]*x509.RevocationList, 0)
+	for len(pemCRLs) > 0 {
+		var block *pem.Block
+		block, pemCRLs = pem.Decode(pemCRLs)
+		if block == nil {
+			break
+		}
+		if block.Type != CRLBlockType || len(block.Headers) != 0 {
+			idx++
+			continue
+		}
+
+		crl, err := x509.ParseRevocationList(block.Bytes)
+		if err != nil {
+			return crls, errors.Wrapf(ErrCouldNotParsePemCRLBytes, "error on block %v", idx)
+		}
+
+		crls = append(crls, crl)
+		idx++
+	}
+	return crls, nil
+}
+
 // LoadRequestsFromPem will read 1 or more PEM encoded certificate signing requests
 func LoadRequestsFromPem(pemRequests []byte) ([]*x509.CertificateRequest, error) {
 	idx := 0
@@ -78,10 +119,19 @@ func LoadRequestsFromPem(pemRequests []byte) ([]*x509.CertificateRequest, error)
 	return csrs, nil
 }
 
-// LoadPrivateKeysFromPem will read 1 or more PEM encoded private keys
+// LoadPrivateKeysFromPem will read 1 or more PEM encoded private keys. Encrypted keys
+// cause ErrPassphraseRequired to be returned; use LoadEncryptedPrivateKeysFromPem for those.
 func LoadPrivateKeysFromPem(pemKeys []byte) ([]interface{}, error) {
+	return LoadEncryptedPrivateKeysFromPem(pemKeys, nil)
+}
+
+// LoadEncryptedPrivateKeysFromPem will read 1 or more PEM encoded private keys, decrypting
+// "ENCRYPTED PRIVATE KEY" and legacy "Proc-Type: 4,ENCRYPTED" blocks with the passphrase
+// supplied by passphrase. The callback is invoked at most once.
+func LoadEncryptedPrivateKeysFromPem(pemKeys []byte, passphrase PassphraseFunc) ([]interface{}, error) {
 	idx := 0
 	keys := make([]interface{}, 0)
+	var password []byte
 	for len(pemKeys) > 0 {
 		var block *pem.Block
 		block, pemKeys = pem.Decode(pemKeys)
@@ -91,6 +141,24 @@ func LoadPrivateKeysFromPem(pemKeys []byte) ([]interface{}, error) {
 
 		var key interface{}
 		var err error
+
+		if block.Type == EncryptedPrivateKeyBlockType || block.Headers["Proc-Type"] == "4,ENCRYPTED" {
+			if passphrase == nil {
+				return keys, errors.Wrapf(ErrPassphraseRequired, "error on block %v", idx)
+			}
+			if password == nil {
+				if password, err = passphrase(); err != nil {
+					return keys, errors.Wrapf(err, "error on block %v", idx)
+				}
+			}
+			if key, err = DecryptPrivateKey(block, password); err != nil {
+				return keys, errors.Wrapf(err, "error on block %v", idx)
+			}
+			keys = append(keys, key)
+			idx++
+			continue
+		}
+
 		switch block.Type {
 		case RSAKeyBlockType:
 			key, err = x509.ParsePKCS1PrivateKey(block.Bytes)
@@ -125,6 +193,13 @@ func pemBlockForKey(priv interface{}) *pem.Block {
 			log.Panicln("Unable to marshal ECDSA private key:", err)
 		}
 		return &pem.Block{Type: ECKeyBlockType, Bytes: b}
+	case ed25519.PrivateKey:
+		// Ed25519 has no legacy encoding, so it is always written as PKCS#8.
+		b, err := x509.MarshalPKCS8PrivateKey(k)
+		if err != nil {
+			log.Panicln("Unable to marshal Ed25519 private key:", err)
+		}
+		return &pem.Block{Type: PrivateKeyBlockType, Bytes: b}
 	default:
 		return nil
 	}
@@ -169,3 +244,14 @@ func EncodeRequest(csrs ...*x509.CertificateRequest) ([]byte, error) {
 	}
 	return b.Bytes(), nil
 }
+
+// EncodeCRLs returns the PEM-encoded byte array that represents the specified CRLs.
+func EncodeCRLs(crls ...*x509.RevocationList) ([]byte, error) {
+	b := bytes.Buffer{}
+	for _, crl := range crls {
+		if err := pem.Encode(&b, &pem.Block{Type: CRLBlockType, Bytes: crl.Raw}); err != nil {
+			return []byte{}, err
+		}
+	}
+	return b.Bytes(), nil
+}
@@ -0,0 +1,168 @@
+package certutils
+
+import (
+	"crypto/rand"
+	"errors"
+	"fmt"
+	"math/big"
+	"os"
+	"strings"
+	"sync"
+
+	"github.com/spf13/afero"
+)
+
+const (
+	// DefaultSerialNumberBits is the number of random bits in a serial number generated
+	// by RandomSerialSource. The CA/Browser Forum baseline requirements call for at least 64.
+	DefaultSerialNumberBits = 128
+	// MaxSerialNumberBits is the largest positive serial number which fits in the 20 octets
+	// permitted by RFC 5280.
+	MaxSerialNumberBits = 159
+)
+
+// SerialSource supplies certificate serial numbers. Implementations must be safe for
+// concurrent use and must never return the same serial number twice.
+type SerialSource interface {
+	NextSerial() (*big.Int, error)
+}
+
+// RandomSerialSource generates positive serial numbers from a cryptographically secure
+// random source. The zero value generates DefaultSerialNumberBits bit serials.
+type RandomSerialSource struct {
+	Bits int
+}
+
+// NextSerial implements SerialSource.
+func (r RandomSerialSource) NextSerial() (*big.Int, error) {
+	bits := r.Bits
+	if bits == 0 {
+		bits = DefaultSerialNumberBits
+	}
+	if bits < 64 || bits > MaxSerialNumberBits {
+		return nil, fmt.Errorf("serial number size must be between 64 and %d bits: %d", MaxSerialNumberBits, bits)
+	}
+
+	limit := new(big.Int).Lsh(big.NewInt(1), uint(bits))
+	for {
+		serial, err := rand.Int(rand.Reader, limit)
+		if err != nil {
+			return nil, err
+		}
+		// Serial numbers must be positive.
+		if serial.Sign() > 0 {
+			return serial, nil
+		}
+	}
+}
+
+// CounterSerialSource issues monotonically increasing serial numbers from memory.
+type CounterSerialSource struct {
+	mu   sync.Mutex
+	next *big.Int
+}
+
+// NewCounterSerialSource returns a CounterSerialSource whose first serial is start. If start
+// is nil the first serial is 1. Serial numbers must be positive, so start must be too.
+func NewCounterSerialSource(start *big.Int) (*CounterSerialSource, error) {
+	next := big.NewInt(1)
+	if start != nil {
+		if start.Sign() <= 0 {
+			return nil, fmt.Errorf("first serial number must be positive: %s", start)
+		}
+		next.Set(start)
+	}
+	return &CounterSerialSource{next: next}, nil
+}
+
+// NextSerial implements SerialSource.
+func (s *CounterSerialSource) NextSerial() (*big.Int, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	serial := new(big.Int).Set(s.next)
+	s.next.Add(s.next, big.NewInt(1))
+	return serial, nil
+}
+
+// FileSerialSource issues monotonically increasing serial numbers persisted to a file. The
+// file holds the next serial to issue in hexadecimal, which is the format of the OpenSSL
+// "serial" and "crlnumber" files. Writes are atomic, but the file is only locked against
+// concurrent use within this process. A file holding zero is rejected.
+type FileSerialSource struct {
+	mu    sync.Mutex
+	fs    afero.Fs
+	path  string
+	start *big.Int
+}
+
+// NewFileSerialSource returns a FileSerialSource backed by path. If the file does not exist,
+// the first serial issued is 1.
+func NewFileSerialSource(fs afero.Fs, path string) *FileSerialSource {
+	return &FileSerialSource{fs: fs, path: path, start: big.NewInt(1)}
+}
+
+// NextSerial implements SerialSource.
+func (s *FileSerialSource) NextSerial() (*big.Int, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	serial, err := s.peek()
+	if err != nil {
+		return nil, err
+	}
+
+	next := new(big.Int).Add(serial, big.NewInt(1))
+	if err := writeFileAtomic(s.fs, s.path, []byte(FormatSerialHex(next)+"\n"), 0644); err != nil {
+		return nil, err
+	}
+	return serial, nil
+}
+
+// Peek returns the next serial which will be issued without consuming it.
+func (s *FileSerialSource) Peek() (*big.Int, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	return s.peek()
+}
+
+func (s *FileSerialSource) peek() (*big.Int, error) {
+	contents, err := afero.ReadFile(s.fs, s.path)
+	if errors.Is(err, os.ErrNotExist) {
+		return new(big.Int).Set(s.start), nil
+	} else if err != nil {
+		return nil, err
+	}
+	serial, err := ParseSerialHex(string(contents))
+	if err != nil {
+		return nil, err
+	}
+	// x509.CreateCertificate accepts a zero serial, which RFC 5280 forbids.
+	if serial.Sign() == 0 {
+		return nil, fmt.Errorf("serial number in %s must be positive", s.path)
+	}
+	return serial, nil
+}
+
+// FormatSerialHex formats a serial number as upper case hexadecimal with an even number of
+// digits, as OpenSSL does.
+func FormatSerialHex(serial *big.Int) string {
+	s := strings.ToUpper(serial.Text(16))
+	if len(s)%2 != 0 {
+		s = "0" + s
+	}
+	return s
+}
+
+// ParseSerialHex parses a hexadecimal serial number as written by FormatSerialHex. Negative
+// values are rejected.
+func ParseSerialHex(s string) (*big.Int, error) {
+	serial, ok := new(big.Int).SetString(strings.TrimSpace(s), 16)
+	if !ok {
+		return nil, fmt.Errorf("invalid hexadecimal serial number: %q", strings.TrimSpace(s))
+	}
+	if serial.Sign() < 0 {
+		return nil, fmt.Errorf("serial number must not be negative: %q", strings.TrimSpace(s))
+	}
+	return serial, nil
+}
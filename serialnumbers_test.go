@@ -0,0 +1,124 @@
+package certutils
+
+import (
+	"crypto/x509"
+	"crypto/x509/pkix"
+	"math/big"
+	"sync"
+
+	"github.com/spf13/afero"
+	. "gopkg.in/check.v1"
+)
+
+type SerialNumberSuite struct {
+}
+
+var _ = Suite(&SerialNumberSuite{})
+
+// drainConcurrently pulls n serials from source across n goroutines and returns them
+// keyed by their string form.
+func drainConcurrently(c *C, source SerialSource, n int) map[string]bool {
+	var mu sync.Mutex
+	var wg sync.WaitGroup
+	seen := map[string]bool{}
+	for i := 0; i < n; i++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			serial, err := source.NextSerial()
+			c.Check(err, IsNil)
+			mu.Lock()
+			seen[serial.String()] = true
+			mu.Unlock()
+		}()
+	}
+	wg.Wait()
+	return seen
+}
+
+func (s *SerialNumberSuite) TestRandomSerialSource(c *C) {
+	seen := drainConcurrently(c, RandomSerialSource{}, 100)
+	c.Assert(seen, HasLen, 100)
+
+	serial, err := RandomSerialSource{Bits: MaxSerialNumberBits}.NextSerial()
+	c.Assert(err, IsNil)
+	c.Assert(serial.Sign(), Equals, 1)
+	c.Assert(serial.BitLen() <= MaxSerialNumberBits, Equals, true)
+
+	_, err = RandomSerialSource{Bits: 32}.NextSerial()
+	c.Assert(err, NotNil)
+}
+
+func (s *SerialNumberSuite) TestCounterSerialSource(c *C) {
+	source, err := NewCounterSerialSource(big.NewInt(1000))
+	c.Assert(err, IsNil)
+	seen := drainConcurrently(c, source, 100)
+	c.Assert(seen, HasLen, 100)
+	for i := int64(1000); i < 1100; i++ {
+		c.Assert(seen[big.NewInt(i).String()], Equals, true)
+	}
+
+	_, err = NewCounterSerialSource(big.NewInt(0))
+	c.Assert(err, NotNil)
+	_, err = NewCounterSerialSource(big.NewInt(-1))
+	c.Assert(err, NotNil)
+}
+
+func (s *SerialNumberSuite) TestParseSerialHex(c *C) {
+	serial, err := ParseSerialHex(" 0F\n")
+	c.Assert(err, IsNil)
+	c.Assert(serial.Int64(), Equals, int64(15))
+
+	_, err = ParseSerialHex("-0F")
+	c.Assert(err, NotNil)
+	_, err = ParseSerialHex("0G")
+	c.Assert(err, NotNil)
+}
+
+func (s *SerialNumberSuite) TestFileSerialSource(c *C) {
+	fs := afero.NewMemMapFs()
+	c.Assert(afero.WriteFile(fs, "/pki/serial", []byte("0F\n"), 0644), IsNil)
+
+	source := NewFileSerialSource(fs, "/pki/serial")
+	seen := drainConcurrently(c, source, 50)
+	c.Assert(seen, HasLen, 50)
+	c.Assert(seen["15"], Equals, true)
+
+	contents, err := afero.ReadFile(fs, "/pki/serial")
+	c.Assert(err, IsNil)
+	c.Assert(string(contents), Equals, "41\n")
+
+	// A fresh source on the same file continues where the last left off.
+	next, err := NewFileSerialSource(fs, "/pki/serial").NextSerial()
+	c.Assert(err, IsNil)
+	c.Assert(next.Int64(), Equals, int64(0x41))
+
+	// Serial numbers must be positive.
+	c.Assert(afero.WriteFile(fs, "/pki/serial", []byte("00\n"), 0644), IsNil)
+	_, err = NewFileSerialSource(fs, "/pki/serial").NextSerial()
+	c.Assert(err, NotNil)
+	contents, err = afero.ReadFile(fs, "/pki/serial")
+	c.Assert(err, IsNil)
+	c.Assert(string(contents), Equals, "00\n")
+}
+
+func (s *SerialNumberSuite) TestSignWithSerialSource(c *C) {
+	ca, caKey := newTestCA(c, PrivateKeyTypeEcp256)
+	c.Assert(ca.SerialNumber.BitLen() > 64, Equals, true)
+
+	key, err := GeneratePrivateKey(PrivateKeyTypeEcp256)
+	c.Assert(err, IsNil)
+	csr, err := GenerateCSR(pkix.Name{}, CSRParameters{KeyUsage: x509.KeyUsageDigitalSignature}, key, "serial.example.com")
+	c.Assert(err, IsNil)
+
+	large, _ := new(big.Int).SetString("123456789012345678901234567890", 10)
+	source, err := NewCounterSerialSource(large)
+	c.Assert(err, IsNil)
+	cert, err := SignCertificate(csr, ca, caKey, SigningParameters{
+		SerialSource: source,
+		NotBefore:    CertificateNotBefore(),
+		NotAfter:     CertificateNotAfter(0, ca),
+	})
+	c.Assert(err, IsNil)
+	c.Assert(cert.SerialNumber.Cmp(large), Equals, 0)
+}
@@ -0,0 +1,163 @@
+package certutils
+
+import (
+	"crypto"
+	"crypto/ecdsa"
+	"crypto/ed25519"
+	"crypto/elliptic"
+	"crypto/rand"
+	"crypto/rsa"
+	"crypto/x509"
+	"crypto/x509/pkix"
+	"encoding/asn1"
+	"fmt"
+)
+
+// ErrIncompatibleSignatureAlgorithm is returned when a requested signature algorithm
+// cannot be produced by the signing key.
+type ErrIncompatibleSignatureAlgorithm struct {
+	Algorithm x509.SignatureAlgorithm
+	KeyType   string
+}
+
+func (e ErrIncompatibleSignatureAlgorithm) Error() string {
+	return fmt.Sprintf("signature algorithm %s cannot be used with %s key", e.Algorithm, e.KeyType)
+}
+
+type signatureAlgorithmDetails struct {
+	publicKeyAlgorithm x509.PublicKeyAlgorithm
+	hash               crypto.Hash
+	oid                asn1.ObjectIdentifier
+	parameters         asn1.RawValue
+}
+
+var (
+	oidSignatureSHA256WithRSA   = asn1.ObjectIdentifier{1, 2, 840, 113549, 1, 1, 11}
+	oidSignatureSHA384WithRSA   = asn1.ObjectIdentifier{1, 2, 840, 113549, 1, 1, 12}
+	oidSignatureSHA512WithRSA   = asn1.ObjectIdentifier{1, 2, 840, 113549, 1, 1, 13}
+	oidSignatureRSAPSS          = asn1.ObjectIdentifier{1, 2, 840, 113549, 1, 1, 10}
+	oidSignatureECDSAWithSHA256 = asn1.ObjectIdentifier{1, 2, 840, 10045, 4, 3, 2}
+	oidSignatureECDSAWithSHA384 = asn1.ObjectIdentifier{1, 2, 840, 10045, 4, 3, 3}
+	oidSignatureECDSAWithSHA512 = asn1.ObjectIdentifier{1, 2, 840, 10045, 4, 3, 4}
+	oidSignatureEd25519         = asn1.ObjectIdentifier{1, 3, 101, 112}
+)
+
+// The RSASSA-PSS-params encodings crypto/x509 uses: MGF1 with the same hash and a salt the
+// length of the hash.
+var (
+	asn1Null            = asn1.RawValue{Tag: asn1.TagNull}
+	pssParametersSHA256 = asn1.RawValue{FullBytes: []byte{48, 52, 160, 15, 48, 13, 6, 9, 96, 134, 72, 1, 101, 3, 4, 2, 1, 5, 0, 161, 28, 48, 26, 6, 9, 42, 134, 72, 134, 247, 13, 1, 1, 8, 48, 13, 6, 9, 96, 134, 72, 1, 101, 3, 4, 2, 1, 5, 0, 162, 3, 2, 1, 32}}
+	pssParametersSHA384 = asn1.RawValue{FullBytes: []byte{48, 52, 160, 15, 48, 13, 6, 9, 96, 134, 72, 1, 101, 3, 4, 2, 2, 5, 0, 161, 28, 48, 26, 6, 9, 42, 134, 72, 134, 247, 13, 1, 1, 8, 48, 13, 6, 9, 96, 134, 72, 1, 101, 3, 4, 2, 2, 5, 0, 162, 3, 2, 1, 48}}
+	pssParametersSHA512 = asn1.RawValue{FullBytes: []byte{48, 52, 160, 15, 48, 13, 6, 9, 96, 134, 72, 1, 101, 3, 4, 2, 3, 5, 0, 161, 28, 48, 26, 6, 9, 42, 134, 72, 134, 247, 13, 1, 1, 8, 48, 13, 6, 9, 96, 134, 72, 1, 101, 3, 4, 2, 3, 5, 0, 162, 3, 2, 1, 64}}
+)
+
+// signatureAlgorithms lists the algorithms an issuer may select. Algorithms based on
+// MD5 or SHA-1 are deliberately absent.
+var signatureAlgorithms = map[x509.SignatureAlgorithm]signatureAlgorithmDetails{
+	x509.SHA256WithRSA:    {x509.RSA, crypto.SHA256, oidSignatureSHA256WithRSA, asn1Null},
+	x509.SHA384WithRSA:    {x509.RSA, crypto.SHA384, oidSignatureSHA384WithRSA, asn1Null},
+	x509.SHA512WithRSA:    {x509.RSA, crypto.SHA512, oidSignatureSHA512WithRSA, asn1Null},
+	x509.SHA256WithRSAPSS: {x509.RSA, crypto.SHA256, oidSignatureRSAPSS, pssParametersSHA256},
+	x509.SHA384WithRSAPSS: {x509.RSA, crypto.SHA384, oidSignatureRSAPSS, pssParametersSHA384},
+	x509.SHA512WithRSAPSS: {x509.RSA, crypto.SHA512, oidSignatureRSAPSS, pssParametersSHA512},
+	x509.ECDSAWithSHA256:  {x509.ECDSA, crypto.SHA256, oidSignatureECDSAWithSHA256, asn1.RawValue{}},
+	x509.ECDSAWithSHA384:  {x509.ECDSA, crypto.SHA384, oidSignatureECDSAWithSHA384, asn1.RawValue{}},
+	x509.ECDSAWithSHA512:  {x509.ECDSA, crypto.SHA512, oidSignatureECDSAWithSHA512, asn1.RawValue{}},
+	x509.PureEd25519:      {x509.Ed25519, crypto.Hash(0), oidSignatureEd25519, asn1.RawValue{}},
+}
+
+// publicKeyAlgorithm returns the x509.PublicKeyAlgorithm of a public key.
+func publicKeyAlgorithm(pub crypto.PublicKey) x509.PublicKeyAlgorithm {
+	switch pub.(type) {
+	case *rsa.PublicKey:
+		return x509.RSA
+	case *ecdsa.PublicKey:
+		return x509.ECDSA
+	case ed25519.PublicKey:
+		return x509.Ed25519
+	default:
+		return x509.UnknownPublicKeyAlgorithm
+	}
+}
+
+// DefaultSignatureAlgorithm returns the signature algorithm an issuer holding the private
+// half of pub should use. The hash is matched to the strength of the key, so a P-384
+// issuer signs with SHA-384 and a P-521 issuer signs with SHA-512.
+func DefaultSignatureAlgorithm(pub crypto.PublicKey) (x509.SignatureAlgorithm, error) {
+	switch key := pub.(type) {
+	case *rsa.PublicKey:
+		return x509.SHA256WithRSA, nil
+	case *ecdsa.PublicKey:
+		switch key.Curve {
+		case elliptic.P256():
+			return x509.ECDSAWithSHA256, nil
+		case elliptic.P384():
+			return x509.ECDSAWithSHA384, nil
+		case elliptic.P521():
+			return x509.ECDSAWithSHA512, nil
+		default:
+			return x509.UnknownSignatureAlgorithm, &ErrUnknownPrivateKey{}
+		}
+	case ed25519.PublicKey:
+		return x509.PureEd25519, nil
+	default:
+		return x509.UnknownSignatureAlgorithm, &ErrUnknownPrivateKey{}
+	}
+}
+
+// SelectSignatureAlgorithm returns the signature algorithm an issuer with the given public key
+// should sign with. If requested is x509.UnknownSignatureAlgorithm the default for the key is
+// returned, otherwise requested is checked to be usable with the key.
+func SelectSignatureAlgorithm(pub crypto.PublicKey, requested x509.SignatureAlgorithm) (x509.SignatureAlgorithm, error) {
+	if requested == x509.UnknownSignatureAlgorithm {
+		return DefaultSignatureAlgorithm(pub)
+	}
+
+	details, found := signatureAlgorithms[requested]
+	keyAlgorithm := publicKeyAlgorithm(pub)
+	if !found || details.publicKeyAlgorithm != keyAlgorithm {
+		return x509.UnknownSignatureAlgorithm, &ErrIncompatibleSignatureAlgorithm{
+			Algorithm: requested,
+			KeyType:   keyAlgorithm.String(),
+		}
+	}
+	return requested, nil
+}
+
+// SignatureAlgorithmHash returns the hash function used by a signature algorithm. Algorithms
+// which do not pre-hash, such as Ed25519, return a zero crypto.Hash.
+func SignatureAlgorithmHash(algorithm x509.SignatureAlgorithm) (crypto.Hash, bool) {
+	details, found := signatureAlgorithms[algorithm]
+	return details.hash, found
+}
+
+// signData signs data with the key using the given algorithm, which must have been chosen
+// with SelectSignatureAlgorithm. It returns the AlgorithmIdentifier to embed alongside the
+// signature, for structures the standard library cannot sign itself.
+func signData(key crypto.Signer, algorithm x509.SignatureAlgorithm, data []byte) (pkix.AlgorithmIdentifier, []byte, error) {
+	details, found := signatureAlgorithms[algorithm]
+	if !found {
+		return pkix.AlgorithmIdentifier{}, nil, &ErrIncompatibleSignatureAlgorithm{
+			Algorithm: algorithm,
+			KeyType:   publicKeyAlgorithm(key.Public()).String(),
+		}
+	}
+	identifier := pkix.AlgorithmIdentifier{Algorithm: details.oid, Parameters: details.parameters}
+
+	var opts crypto.SignerOpts = details.hash
+	digest := data
+	if details.hash != crypto.Hash(0) {
+		h := details.hash.New()
+		h.Write(data)
+		digest = h.Sum(nil)
+	}
+	if details.oid.Equal(oidSignatureRSAPSS) {
+		opts = &rsa.PSSOptions{SaltLength: rsa.PSSSaltLengthEqualsHash, Hash: details.hash}
+	}
+
+	signature, err := key.Sign(rand.Reader, digest, opts)
+	if err != nil {
+		return pkix.AlgorithmIdentifier{}, nil, err
+	}
+	return identifier, signature, nil
+}
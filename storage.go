@@ -1,11 +1,28 @@
 package certutils
 
 import (
+	"bytes"
+	"crypto"
 	"crypto/tls"
+	"crypto/x509"
+	"encoding/pem"
+	"errors"
 	"github.com/spf13/afero"
 	"io"
+	"os"
+	"path/filepath"
 )
 
+const (
+	// DefaultKeyPairBackupSuffix is appended to the names of backed up files.
+	DefaultKeyPairBackupSuffix = ".bak"
+
+	certificateFileMode os.FileMode = 0644
+	privateKeyFileMode  os.FileMode = 0600
+)
+
+var ErrKeyMismatch = errors.New("private key does not match certificate")
+
 // LoadX509KeyPair implements tls.LoadX509KeyPair but accepts an afero filesystem override.
 func LoadX509KeyPair(fs afero.Fs, certFile, keyFile string) (tls.Certificate, error) {
 	certFileHandle, err := fs.Open(certFile)
@@ -32,3 +49,232 @@ func LoadX509KeyPair(fs afero.Fs, certFile, keyFile string) (tls.Certificate, er
 
 	return tls.X509KeyPair(certPEMBlock, keyPEMBlock)
 }
+
+// KeyPairSaveOptions sets how SaveX509KeyPairWithOptions writes a key pair.
+type KeyPairSaveOptions struct {
+	// Backup keeps a copy of the files being replaced, named with BackupSuffix.
+	Backup bool
+	// BackupSuffix defaults to DefaultKeyPairBackupSuffix.
+	BackupSuffix string
+	// Passphrase, if set, encrypts the private key with KeyEncryption.
+	Passphrase    []byte
+	KeyEncryption KeyEncryptionParameters
+}
+
+// CheckKeyMatchesCertificate returns ErrKeyMismatch if the private key is not the key of the
+// certificate.
+func CheckKeyMatchesCertificate(key crypto.PrivateKey, certificate *x509.Certificate) error {
+	signer, ok := key.(crypto.Signer)
+	if !ok || !publicKeysEqual(signer.Public(), certificate.PublicKey) {
+		return ErrKeyMismatch
+	}
+	return nil
+}
+
+// EncodeX509KeyPair encodes the certificate chain and private key of a tls.Certificate as
+// PEM, after checking the key matches the leaf certificate.
+func EncodeX509KeyPair(certificate tls.Certificate) ([]byte, []byte, error) {
+	return encodeX509KeyPair(certificate, KeyPairSaveOptions{})
+}
+
+func encodeX509KeyPair(certificate tls.Certificate, options KeyPairSaveOptions) ([]byte, []byte, error) {
+	if len(certificate.Certificate) == 0 {
+		return nil, nil, errors.New("certificate has no leaf")
+	}
+	leaf := certificate.Leaf
+	if leaf == nil {
+		var err error
+		if leaf, err = x509.ParseCertificate(certificate.Certificate[0]); err != nil {
+			return nil, nil, err
+		}
+	}
+	if err := CheckKeyMatchesCertificate(certificate.PrivateKey, leaf); err != nil {
+		return nil, nil, err
+	}
+
+	certPEM := bytes.Buffer{}
+	for _, der := range certificate.Certificate {
+		if err := pem.Encode(&certPEM, &pem.Block{Type: CertificateBlockType, Bytes: der}); err != nil {
+			return nil, nil, err
+		}
+	}
+	var keyPEM []byte
+	var err error
+	if options.Passphrase != nil {
+		keyPEM, err = EncodeEncryptedKeys(options.Passphrase, options.KeyEncryption, certificate.PrivateKey)
+	} else {
+		keyPEM, err = EncodeKeys(certificate.PrivateKey)
+	}
+	if err != nil {
+		return nil, nil, err
+	}
+	return certPEM.Bytes(), keyPEM, nil
+}
+
+// SaveX509KeyPair is the counterpart of LoadX509KeyPair. It writes the certificate chain to
+// certFile and the private key to keyFile, readable only by the owner. If both names are the
+// same a single file holding the key and then the chain is written.
+func SaveX509KeyPair(fs afero.Fs, certFile, keyFile string, certificate tls.Certificate) error {
+	return SaveX509KeyPairWithOptions(fs, certFile, keyFile, certificate, KeyPairSaveOptions{})
+}
+
+// SaveX509KeyPairWithOptions is SaveX509KeyPair with backups and key encryption. Nothing is
+// written unless the key matches the leaf certificate. Both files are fully written before
+// either is renamed into place, and the key is restored if the certificate cannot be, so a
+// failure leaves the previous pair intact.
+func SaveX509KeyPairWithOptions(fs afero.Fs, certFile, keyFile string, certificate tls.Certificate, options KeyPairSaveOptions) error {
+	certPEM, keyPEM, err := encodeX509KeyPair(certificate, options)
+	if err != nil {
+		return err
+	}
+
+	type pendingFile struct {
+		name string
+		data []byte
+		perm os.FileMode
+	}
+	files := []pendingFile{
+		{keyFile, keyPEM, privateKeyFileMode},
+		{certFile, certPEM, certificateFileMode},
+	}
+	if filepath.Clean(certFile) == filepath.Clean(keyFile) {
+		files = []pendingFile{{keyFile, append(keyPEM, certPEM...), privateKeyFileMode}}
+	}
+
+	if options.Backup {
+		suffix := options.BackupSuffix
+		if suffix == "" {
+			suffix = DefaultKeyPairBackupSuffix
+		}
+		for _, file := range files {
+			if err := backupFile(fs, file.name, file.name+suffix); err != nil {
+				return err
+			}
+		}
+	}
+
+	tmpNames := []string{}
+	defer func() {
+		for _, tmpName := range tmpNames {
+			fs.Remove(tmpName)
+		}
+	}()
+	for _, file := range files {
+		tmpName, err := writeTempFile(fs, file.name, file.data, file.perm)
+		if err != nil {
+			return err
+		}
+		tmpNames = append(tmpNames, tmpName)
+	}
+
+	// Keep the files replaced before the last aside, to restore them if a later rename fails.
+	asideNames := make([]string, len(files)-1)
+	defer func() {
+		for _, asideName := range asideNames {
+			if asideName != "" {
+				fs.Remove(asideName)
+			}
+		}
+	}()
+	for i, file := range files[:len(files)-1] {
+		if asideNames[i], err = setAside(fs, file.name); err != nil {
+			return err
+		}
+	}
+
+	for i, file := range files {
+		if err := fs.Rename(tmpNames[i], file.name); err != nil {
+			for j := i - 1; j >= 0; j-- {
+				if asideNames[j] == "" {
+					fs.Remove(files[j].name)
+				} else {
+					fs.Rename(asideNames[j], files[j].name)
+				}
+			}
+			return err
+		}
+	}
+	tmpNames = nil
+	return nil
+}
+
+// setAside copies filename to a temporary file next to it and returns the copy's name, or ""
+// if filename does not exist.
+func setAside(fs afero.Fs, filename string) (string, error) {
+	info, err := fs.Stat(filename)
+	if errors.Is(err, os.ErrNotExist) {
+		return "", nil
+	} else if err != nil {
+		return "", err
+	}
+	data, err := afero.ReadFile(fs, filename)
+	if err != nil {
+		return "", err
+	}
+	return writeTempFile(fs, filename, data, info.Mode().Perm())
+}
+
+// backupFile copies filename to backupName, keeping its permissions. A missing file is not
+// an error.
+func backupFile(fs afero.Fs, filename, backupName string) error {
+	info, err := fs.Stat(filename)
+	if errors.Is(err, os.ErrNotExist) {
+		return nil
+	} else if err != nil {
+		return err
+	}
+	data, err := afero.ReadFile(fs, filename)
+	if err != nil {
+		return err
+	}
+	return writeFileAtomic(fs, backupName, data, info.Mode().Perm())
+}
+
+// writeFileAtomic writes data to a temporary file next to filename and renames it into
+// place, so readers never observe a partially written file.
+func writeFileAtomic(fs afero.Fs, filename string, data []byte, perm os.FileMode) error {
+	tmpName, err := writeTempFile(fs, filename, data, perm)
+	if err != nil {
+		return err
+	}
+	if err := fs.Rename(tmpName, filename); err != nil {
+		fs.Remove(tmpName)
+		return err
+	}
+	return nil
+}
+
+// writeTempFile writes data to a temporary file in the same directory as filename, ready to
+// be renamed over it.
+func writeTempFile(fs afero.Fs, filename string, data []byte, perm os.FileMode) (string, error) {
+	dir, base := filepath.Split(filename)
+	if dir == "" {
+		dir = "."
+	}
+
+	tmp, err := afero.TempFile(fs, dir, "."+base+".tmp")
+	if err != nil {
+		return "", err
+	}
+	tmpName := tmp.Name()
+
+	if _, err := tmp.Write(data); err != nil {
+		tmp.Close()
+		fs.Remove(tmpName)
+		return "", err
+	}
+	if err := tmp.Sync(); err != nil {
+		tmp.Close()
+		fs.Remove(tmpName)
+		return "", err
+	}
+	if err := tmp.Close(); err != nil {
+		fs.Remove(tmpName)
+		return "", err
+	}
+	if err := fs.Chmod(tmpName, perm); err != nil {
+		fs.Remove(tmpName)
+		return "", err
+	}
+	return tmpName, nil
+}
@@ -0,0 +1,145 @@
+package certutils
+
+import (
+	"errors"
+	"strings"
+
+	"github.com/spf13/afero"
+	. "gopkg.in/check.v1"
+)
+
+type StorageSuite struct {
+}
+
+var _ = Suite(&StorageSuite{})
+
+func (s *StorageSuite) TestSaveAndLoad(c *C) {
+	fs := afero.NewMemMapFs()
+	ca, caKey := newTestCA(c, PrivateKeyTypeEcp256)
+	certificate := newTestLeaf(c, ca, caKey, PrivateKeyTypeEcp256, "www.example.com")
+
+	c.Assert(SaveX509KeyPair(fs, "/tls/tls.crt", "/tls/tls.key", *certificate), IsNil)
+	info, err := fs.Stat("/tls/tls.key")
+	c.Assert(err, IsNil)
+	c.Check(info.Mode().Perm(), Equals, privateKeyFileMode)
+	info, err = fs.Stat("/tls/tls.crt")
+	c.Assert(err, IsNil)
+	c.Check(info.Mode().Perm(), Equals, certificateFileMode)
+
+	loaded, err := LoadX509KeyPair(fs, "/tls/tls.crt", "/tls/tls.key")
+	c.Assert(err, IsNil)
+	c.Check(loaded.Certificate, DeepEquals, certificate.Certificate)
+	c.Check(loaded.PrivateKey, DeepEquals, certificate.PrivateKey)
+
+	// No temporary files are left behind.
+	entries, err := afero.ReadDir(fs, "/tls")
+	c.Assert(err, IsNil)
+	c.Check(entries, HasLen, 2)
+
+	// A single file holds the key followed by the chain.
+	c.Assert(SaveX509KeyPair(fs, "/tls/combined.pem", "/tls/combined.pem", *certificate), IsNil)
+	loaded, err = LoadX509KeyPair(fs, "/tls/combined.pem", "/tls/combined.pem")
+	c.Assert(err, IsNil)
+	c.Check(loaded.Certificate, DeepEquals, certificate.Certificate)
+	info, err = fs.Stat("/tls/combined.pem")
+	c.Assert(err, IsNil)
+	c.Check(info.Mode().Perm(), Equals, privateKeyFileMode)
+}
+
+func (s *StorageSuite) TestKeyMismatchWritesNothing(c *C) {
+	fs := afero.NewMemMapFs()
+	ca, caKey := newTestCA(c, PrivateKeyTypeEcp256)
+	certificate := newTestLeaf(c, ca, caKey, PrivateKeyTypeEcp256, "www.example.com")
+	other := newTestLeaf(c, ca, caKey, PrivateKeyTypeEcp256, "other.example.com")
+
+	c.Check(CheckKeyMatchesCertificate(other.PrivateKey, certificate.Leaf), Equals, ErrKeyMismatch)
+	c.Check(CheckKeyMatchesCertificate(certificate.PrivateKey, certificate.Leaf), IsNil)
+
+	mismatched := *certificate
+	mismatched.PrivateKey = other.PrivateKey
+	mismatched.Leaf = nil
+	c.Check(SaveX509KeyPair(fs, "/tls/tls.crt", "/tls/tls.key", mismatched), Equals, ErrKeyMismatch)
+	for _, path := range []string{"/tls/tls.crt", "/tls/tls.key"} {
+		exists, err := afero.Exists(fs, path)
+		c.Assert(err, IsNil)
+		c.Check(exists, Equals, false, Commentf(path))
+	}
+}
+
+// failingRenameFs fails renames onto one file.
+type failingRenameFs struct {
+	afero.Fs
+	name string
+}
+
+func (fs failingRenameFs) Rename(oldname, newname string) error {
+	if newname == fs.name {
+		return errors.New("rename failed")
+	}
+	return fs.Fs.Rename(oldname, newname)
+}
+
+func (s *StorageSuite) TestFailedRenameKeepsPreviousPair(c *C) {
+	fs := afero.NewMemMapFs()
+	ca, caKey := newTestCA(c, PrivateKeyTypeEcp256)
+	previous := newTestLeaf(c, ca, caKey, PrivateKeyTypeEcp256, "www.example.com")
+	c.Assert(SaveX509KeyPair(fs, "/tls/tls.crt", "/tls/tls.key", *previous), IsNil)
+	certPEM, err := afero.ReadFile(fs, "/tls/tls.crt")
+	c.Assert(err, IsNil)
+	keyPEM, err := afero.ReadFile(fs, "/tls/tls.key")
+	c.Assert(err, IsNil)
+
+	replacement := newTestLeaf(c, ca, caKey, PrivateKeyTypeEcp256, "www.example.com")
+	err = SaveX509KeyPair(failingRenameFs{fs, "/tls/tls.crt"}, "/tls/tls.crt", "/tls/tls.key", *replacement)
+	c.Assert(err, NotNil)
+
+	contents, err := afero.ReadFile(fs, "/tls/tls.crt")
+	c.Assert(err, IsNil)
+	c.Check(contents, DeepEquals, certPEM)
+	contents, err = afero.ReadFile(fs, "/tls/tls.key")
+	c.Assert(err, IsNil)
+	c.Check(contents, DeepEquals, keyPEM)
+	entries, err := afero.ReadDir(fs, "/tls")
+	c.Assert(err, IsNil)
+	c.Check(entries, HasLen, 2)
+
+	// Without a previous pair nothing is left behind.
+	err = SaveX509KeyPair(failingRenameFs{fs, "/new/tls.crt"}, "/new/tls.crt", "/new/tls.key", *replacement)
+	c.Assert(err, NotNil)
+	entries, err = afero.ReadDir(fs, "/new")
+	c.Assert(err, IsNil)
+	c.Check(entries, HasLen, 0)
+}
+
+func (s *StorageSuite) TestBackupAndEncryption(c *C) {
+	fs := afero.NewMemMapFs()
+	ca, caKey := newTestCA(c, PrivateKeyTypeEcp256)
+	first := newTestLeaf(c, ca, caKey, PrivateKeyTypeEcp256, "www.example.com")
+	second := newTestLeaf(c, ca, caKey, PrivateKeyTypeEcp256, "www.example.com")
+	options := KeyPairSaveOptions{Backup: true}
+
+	// There is nothing to back up the first time.
+	c.Assert(SaveX509KeyPairWithOptions(fs, "/tls/tls.crt", "/tls/tls.key", *first, options), IsNil)
+	exists, err := afero.Exists(fs, "/tls/tls.crt.bak")
+	c.Assert(err, IsNil)
+	c.Check(exists, Equals, false)
+
+	options.Passphrase = []byte("key pair passphrase")
+	options.KeyEncryption = KeyEncryptionParameters{Iterations: 1000}
+	c.Assert(SaveX509KeyPairWithOptions(fs, "/tls/tls.crt", "/tls/tls.key", *second, options), IsNil)
+
+	backup, err := LoadX509KeyPair(fs, "/tls/tls.crt.bak", "/tls/tls.key.bak")
+	c.Assert(err, IsNil)
+	c.Check(backup.Certificate, DeepEquals, first.Certificate)
+	info, err := fs.Stat("/tls/tls.key.bak")
+	c.Assert(err, IsNil)
+	c.Check(info.Mode().Perm(), Equals, privateKeyFileMode)
+
+	keyPEM, err := afero.ReadFile(fs, "/tls/tls.key")
+	c.Assert(err, IsNil)
+	c.Check(strings.Contains(string(keyPEM), EncryptedPrivateKeyBlockType), Equals, true)
+	keys, err := LoadEncryptedPrivateKeysFromPem(keyPEM, StaticPassphrase(options.Passphrase))
+	c.Assert(err, IsNil)
+	c.Assert(keys, HasLen, 1)
+	c.Check(CheckKeyMatchesCertificate(keys[0], second.Leaf), IsNil)
+}